@@ -2,13 +2,18 @@ package discoverkit
 
 import (
 	"context"
-	"fmt"
+	"errors"
+	"sync"
+	"time"
 
 	"github.com/dogmatiq/configkit"
 	"github.com/dogmatiq/interopspec/discoverspec"
+	"github.com/dogmatiq/linger"
 	"github.com/dogmatiq/linger/backoff"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 )
 
@@ -22,6 +27,14 @@ type Application struct {
 
 	// Connection is the connection that was used to discover the application.
 	Connection grpc.ClientConnInterface
+
+	// Metadata is the header metadata that the target sent when the
+	// application was discovered.
+	Metadata metadata.MD
+
+	// state is the discoverer's state for the application, which is used to
+	// report its connectivity.
+	state *applicationState
 }
 
 // ApplicationObserver is a function that handles the discovery of a Dogma
@@ -62,20 +75,57 @@ type ApplicationDiscoverer struct {
 	// LogError is an optional function that logs errors that occur while
 	// attempting to watch a gRPC target.
 	LogError func(Target, error)
+
+	// Credentials is an optional function that returns the per-RPC
+	// credentials used to watch the given target, such as a BearerToken.
+	//
+	// If it is nil, or returns nil, no per-RPC credentials are used.
+	Credentials func(Target) credentials.PerRPCCredentials
+
+	// GracePeriod is the amount of time that applications remain available
+	// after the "watch stream" to their target fails.
+	//
+	// During this period each application's Connectivity() is Degraded. If the
+	// target announces the application again before the period elapses it
+	// becomes Connected, otherwise its context is canceled.
+	//
+	// If GracePeriod is positive, the connection to the target is retained
+	// between attempts to watch it so that the Connection of each retained
+	// application remains usable. If it is non-positive, application contexts
+	// are canceled as soon as the watch stream fails.
+	GracePeriod time.Duration
+
+	// ObserverPanics determines how a panic in an ApplicationObserver is
+	// handled.
+	//
+	// If it is FailOnPanic, DiscoverApplications() and
+	// DiscoverApplicationsOnTargets() stop and return the *ObserverPanicError.
+	ObserverPanics PanicPolicy
+
+	m sync.Mutex
+
+	// targets is the set of targets that are currently being watched by calls
+	// to DiscoverApplications().
+	targets map[*targetState]struct{}
 }
 
 // DiscoverApplications invokes an observer for each Dogma application target
 // that is discovered on a specific gRPC target.
 //
 // It returns a nil error if the target is contactable but it does not implement
-// the DiscoverAPI service. Otherwise, it runs until ctx is canceled.
+// the DiscoverAPI service. Otherwise, it runs until ctx is canceled, or until
+// the observer panics if the discoverer's ObserverPanics policy is FailOnPanic.
 //
 // Errors that occur while communicating with the target are logged to the
 // LogError function, if present, before retrying. The retry interval is
-// determined by the discoverer's BackoffStrategy.
+// determined by the target's RetryPolicy, if present, or otherwise by the
+// discoverer's BackoffStrategy. If the policy's circuit breaker opens and it
+// has no ProbeInterval, it returns a *CircuitOpenError.
 //
 // The context passed to the observer is canceled when the application becomes
-// unavailable or the discover is stopped.
+// unavailable or the discover is stopped. If the discoverer has a GracePeriod,
+// the context is not canceled when the watch stream fails unless the
+// application is not announced again before the grace period elapses.
 //
 // The discoverer MAY block on calls to the observer. It is the observer's
 // responsibility to start new goroutines to handle background tasks, as
@@ -89,9 +139,19 @@ func (d *ApplicationDiscoverer) DiscoverApplications(
 		Strategy: d.BackoffStrategy,
 	}
 
+	st := d.track(t)
+	defer d.untrack(st)
+	defer d.removeAll(st)
+
+	defer func() {
+		if st.conn != nil {
+			st.conn.Close()
+		}
+	}()
+
 	for {
 		// Attempt to discover applications via the given connection.
-		err := d.watch(ctx, ctr, t, obs)
+		err := d.watch(ctx, ctr, st, t, obs)
 
 		// If the error is nil it means that the target does not implement the
 		// DiscoverAPI. This is not an error, it simply means that we will never
@@ -106,82 +166,231 @@ func (d *ApplicationDiscoverer) DiscoverApplications(
 			return ctx.Err()
 		}
 
+		// If the observer panicked and the policy is to fail, there is no
+		// point retrying. The panic has already been logged.
+		var panicErr *ObserverPanicError
+		if errors.As(err, &panicErr) {
+			return err
+		}
+
 		// Log the error, if a log function was provided.
-		if d.LogError != nil {
-			d.LogError(t, err)
+		d.logError(st, t, err)
+
+		// Determine how long to wait before trying again. Once the target has
+		// failed too many times in a row the circuit opens, and we either
+		// probe it at the policy's slower rate or give up entirely.
+		policy := d.retryPolicy(t)
+		failures := d.failed(st)
+		open := policy.MaxFailures > 0 && failures >= uint(policy.MaxFailures)
+
+		var delay time.Duration
+		if !open {
+			ctr.Strategy = policy.BackoffStrategy
+			delay = ctr.Fail(err)
+		} else if policy.ProbeInterval > 0 {
+			delay = policy.ProbeInterval
+		} else {
+			err := &CircuitOpenError{
+				Target:   t,
+				Failures: failures,
+				Cause:    err,
+			}
+			d.logError(st, t, err)
+			return err
 		}
 
-		// Finally, we sleep using the backoff counter until it's time to try
-		// watching again.
-		if err := ctr.Sleep(ctx, err); err != nil {
+		// Finally, we sleep until it's time to try watching again.
+		d.retrying(st, delay, open)
+
+		if err := linger.Sleep(ctx, delay); err != nil {
 			return err
 		}
 	}
 }
 
+// DiscoverApplicationsOnTargets invokes an observer for each Dogma application
+// that is discovered on any of the gRPC targets discovered by td.
+//
+// It runs until ctx is canceled or td returns an error, or until the observer
+// panics if the discoverer's ObserverPanics policy is FailOnPanic. Applications
+// on each target are discovered as per DiscoverApplications().
+//
+// If the discoverer gives up watching a target because the circuit breaker in
+// its RetryPolicy opens, the target is not watched again unless td reports it
+// again.
+//
+// The context passed to the observer is canceled when the application becomes
+// unavailable, its target becomes unavailable or the discoverer is stopped.
+//
+// The discoverer MAY block on calls to the observer. It is the observer's
+// responsibility to start new goroutines to handle background tasks, as
+// appropriate.
+func (d *ApplicationDiscoverer) DiscoverApplicationsOnTargets(
+	ctx context.Context,
+	td TargetDiscoverer,
+	obs ApplicationObserver,
+) error {
+	var (
+		g       sync.WaitGroup
+		failure firstError
+	)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	err := td.DiscoverTargets(
+		ctx,
+		func(ctx context.Context, t Target) {
+			g.Add(1)
+			go func() {
+				defer g.Done()
+
+				// If an observer panicked and the policy is to fail, stop
+				// discovering applications on every target.
+				var panicErr *ObserverPanicError
+				if err := d.DiscoverApplications(ctx, t, obs); errors.As(err, &panicErr) {
+					failure.set(err)
+					cancel()
+				}
+			}()
+		},
+	)
+
+	cancel()
+	g.Wait()
+
+	if err := failure.get(); err != nil {
+		return err
+	}
+
+	return err
+}
+
 var emptyWatchApplicationsRequest discoverspec.WatchApplicationsRequest
 
+// stableWatchDuration is the amount of time that a watch stream must remain
+// open to be considered successful if no responses are received on it.
+const stableWatchDuration = 1 * time.Minute
+
 // watch dials a target and watches it for updates to application
 // availability.
 func (d *ApplicationDiscoverer) watch(
 	ctx context.Context,
 	ctr *backoff.Counter,
+	st *targetState,
 	t Target,
 	obs ApplicationObserver,
 ) error {
-	// Dial the target using the configurered dialer, or otherwise using the
-	// default gRPC dialer.
-	dial := d.Dial
-	if dial == nil {
-		dial = grpc.DialContext
+	conn, err := d.dial(ctx, st, t)
+	if err != nil {
+		return err
 	}
 
-	conn, err := dial(ctx, t.Name, t.DialOptions...)
-	if err != nil {
-		return fmt.Errorf("unable to dial target: %w", err)
+	if st.conn == nil {
+		defer conn.Close()
 	}
-	defer conn.Close()
 
 	// Create a cancellable context specifically to abort the gRPC stream when
 	// this function returns. There's no Close() method on a stream, it's
 	// lifetime is tied to the context that created it.
-	ctx, cancel := context.WithCancel(ctx)
+	streamCtx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
 	cli := discoverspec.NewDiscoverAPIClient(conn)
-	stream, err := cli.WatchApplications(ctx, &emptyWatchApplicationsRequest)
+	stream, err := cli.WatchApplications(streamCtx, &emptyWatchApplicationsRequest)
 	if err != nil {
 		// Note that the gRPC package does NOT report "unimplemented" errors
 		// here, even though this is where we call the RPC. Instead, they are
 		// delivered on the first call to stream.Recv().
-		return fmt.Errorf("unable to watch target: %w", err)
+		return &WatchError{
+			Phase:  WatchPhaseOpenStream,
+			Target: t,
+			Cause:  err,
+		}
+	}
+
+	d.connected(st)
+	defer d.disconnected(st)
+
+	// Opening the stream does not mean that the target is healthy, as a faulty
+	// target may accept the stream and then fail every read. The target is
+	// only considered to have been watched successfully once recv() receives a
+	// response, or if the stream stays open for long enough.
+	openedAt := time.Now()
+	err = d.recv(ctx, ctr, st, t, conn, stream, obs)
+
+	if time.Since(openedAt) >= stableWatchDuration {
+		ctr.Reset()
+		d.succeeded(st)
+	}
+
+	return err
+}
+
+// dial returns the connection used to watch a target.
+//
+// If the discoverer has a grace period the connection is retained in st and
+// re-used by subsequent calls.
+func (d *ApplicationDiscoverer) dial(
+	ctx context.Context,
+	st *targetState,
+	t Target,
+) (*grpc.ClientConn, error) {
+	if st.conn != nil {
+		return st.conn, nil
+	}
+
+	// Dial the target using the configurered dialer, or otherwise using the
+	// default gRPC dialer.
+	dial := d.Dial
+	if dial == nil {
+		dial = grpc.DialContext
+	}
+
+	options := t.DialOptions
+	if d.Credentials != nil {
+		if creds := d.Credentials(t); creds != nil {
+			options = append(
+				options[:len(options):len(options)],
+				grpc.WithPerRPCCredentials(creds),
+			)
+		}
+	}
+
+	conn, err := dial(ctx, t.Name, options...)
+	if err != nil {
+		return nil, &WatchError{
+			Phase:  WatchPhaseDial,
+			Target: t,
+			Cause:  err,
+		}
 	}
 
-	// Reset the backoff counter now that we've made a successful call to the
-	// server. We expect at this point that the emptyWatchApplicationsRequest
-	// has at least been sent successfully, even though we don't see its result
-	// until we call stream.Recv().
-	ctr.Reset()
+	if d.GracePeriod > 0 {
+		st.conn = conn
+	}
 
-	return d.recv(ctx, t, conn, stream, obs)
+	return conn, nil
 }
 
 // recv waits for the next response on the "watch stream" and invokes observers
 // / cancels their contexts as applications become available and unavailable.
 func (d *ApplicationDiscoverer) recv(
 	ctx context.Context,
+	ctr *backoff.Counter,
+	st *targetState,
 	t Target,
 	conn *grpc.ClientConn,
 	stream discoverspec.DiscoverAPI_WatchApplicationsClient,
 	obs ApplicationObserver,
 ) error {
-	applications := map[configkit.Identity]context.CancelFunc{}
+	// Read the header metadata. If the stream fails before the header is
+	// received the error is reported by the first call to stream.Recv().
+	md, _ := stream.Header()
 
-	defer func() {
-		for _, cancel := range applications {
-			cancel()
-		}
-	}()
+	defer d.degrade(st)
+
+	received := false
 
 	for {
 		res, err := stream.Recv()
@@ -195,7 +404,19 @@ func (d *ApplicationDiscoverer) recv(
 				}
 			}
 
-			return fmt.Errorf("unable to read from stream: %w", err)
+			return &WatchError{
+				Phase:  WatchPhaseReceive,
+				Target: t,
+				Cause:  err,
+			}
+		}
+
+		// The first response shows that the target is serving the stream, so
+		// reset the backoff counter and the circuit breaker.
+		if !received {
+			received = true
+			ctr.Reset()
+			d.succeeded(st)
 		}
 
 		id, err := configkit.NewIdentity(
@@ -209,41 +430,55 @@ func (d *ApplicationDiscoverer) recv(
 			// This approach is taken (as opposed to returning the error) so
 			// that we can continue to use other applications with well-formed
 			// identities on the same server.
-			if d.LogError != nil {
-				err = fmt.Errorf("invalid application identity: %w", err)
-				d.LogError(t, err)
+			err = &WatchError{
+				Phase:  WatchPhaseInvalidIdentity,
+				Target: t,
+				Identity: configkit.Identity{
+					Name: res.GetIdentity().GetName(),
+					Key:  res.GetIdentity().GetKey(),
+				},
+				Cause: err,
 			}
+			d.logError(st, t, err)
 
 			continue
 		}
 
-		cancel, available := applications[id]
-
-		if res.Available == available {
-			// There has been no change in availability. Perhaps the server
-			// re-announced the same application. This is not the *expected*
-			// behavior, but we are lenient in the interest of robustness.
-			continue
-		}
-
 		if !res.Available {
 			// The application has been marked as unavailable. Cancel its
 			// goroutine and remove it from the list of known applications.
-			cancel()
-			delete(applications, id)
+			d.unavailable(st, id)
 			continue
 		}
 
 		// Create a context specific for this application. It will be canceled
 		// if the server sends an "unavailable" response for this application
 		// over the stream.
-		appCtx, cancel := context.WithCancel(ctx)
-		applications[id] = cancel
-
-		obs(appCtx, Application{
-			Identity:   id,
-			Target:     t,
-			Connection: conn,
-		})
+		appCtx, state, ok := d.available(ctx, st, id)
+		if !ok {
+			// The application is already known. Perhaps the server
+			// re-announced the same application. This is not the *expected*
+			// behavior, but we are lenient in the interest of robustness.
+			continue
+		}
+
+		if err := observeApplication(
+			appCtx,
+			d.ObserverPanics,
+			obs,
+			Application{
+				Identity:   id,
+				Target:     t,
+				Connection: conn,
+				Metadata:   md,
+				state:      state,
+			},
+		); err != nil {
+			d.logError(st, t, err)
+
+			if d.ObserverPanics == FailOnPanic {
+				return err
+			}
+		}
 	}
 }
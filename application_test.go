@@ -9,6 +9,7 @@ import (
 	"github.com/dogmatiq/configkit"
 	. "github.com/dogmatiq/discoverkit"
 	"github.com/dogmatiq/interopspec/discoverspec"
+	"github.com/dogmatiq/linger/backoff"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	. "github.com/onsi/gomega/gstruct"
@@ -140,6 +141,8 @@ var _ = Describe("type ApplicationDiscoverer", func() {
 									"Identity":   Equal(configkit.MustNewIdentity("<app-name>", appKey)),
 									"Target":     Equal(target),
 									"Connection": Not(BeNil()),
+									"Metadata":   HaveKey("content-type"),
+									"state":      Ignore(),
 								},
 							))
 						},
@@ -267,6 +270,35 @@ var _ = Describe("type ApplicationDiscoverer", func() {
 					}
 				})
 
+				It("reports the target as connected with the application available", func() {
+					err := discoverer.DiscoverApplications(
+						ctx,
+						target,
+						func(
+							context.Context,
+							Application,
+						) {
+							defer cancel()
+
+							Expect(discoverer.Targets()).To(ConsistOf(
+								MatchFields(
+									IgnoreExtras,
+									Fields{
+										"Target":       Equal(target),
+										"Connected":    BeTrue(),
+										"ConnectedAt":  Not(BeZero()),
+										"Failures":     BeZero(),
+										"Applications": ConsistOf(configkit.MustNewIdentity("<app-name>", appKey)),
+									},
+								),
+							))
+						},
+					)
+
+					Expect(err).To(Equal(context.Canceled))
+					Expect(discoverer.Targets()).To(BeEmpty())
+				})
+
 				It("does not invoke the observer if the server sends a duplicate response", func() {
 					count := 0
 
@@ -356,6 +388,12 @@ var _ = Describe("type ApplicationDiscoverer", func() {
 
 						Expect(t).To(Equal(target))
 						Expect(err).To(MatchError(`invalid application identity: invalid name "", names must be non-empty, printable UTF-8 strings with no whitespace`))
+						Expect(err).To(PointTo(MatchAllFields(Fields{
+							"Phase":    Equal(WatchPhaseInvalidIdentity),
+							"Target":   Equal(target),
+							"Identity": Equal(configkit.Identity{}),
+							"Cause":    HaveOccurred(),
+						})))
 					}
 
 					err := discoverer.DiscoverApplications(ctx, target, nil)
@@ -382,6 +420,12 @@ var _ = Describe("type ApplicationDiscoverer", func() {
 
 						Expect(t).To(Equal(target))
 						Expect(err).To(MatchError(`unable to read from stream: rpc error: code = Unknown desc = <error>`))
+						Expect(err).To(PointTo(MatchAllFields(Fields{
+							"Phase":    Equal(WatchPhaseReceive),
+							"Target":   Equal(target),
+							"Identity": Equal(configkit.Identity{}),
+							"Cause":    HaveOccurred(),
+						})))
 					}
 
 					err := discoverer.DiscoverApplications(ctx, target, nil)
@@ -405,11 +449,37 @@ var _ = Describe("type ApplicationDiscoverer", func() {
 
 						Expect(t).To(Equal(target))
 						Expect(err).To(MatchError(`unable to dial target: grpc: no transport security set (use grpc.WithTransportCredentials(insecure.NewCredentials()) explicitly or set credentials)`))
+						Expect(err).To(PointTo(MatchAllFields(Fields{
+							"Phase":    Equal(WatchPhaseDial),
+							"Target":   Equal(target),
+							"Identity": Equal(configkit.Identity{}),
+							"Cause":    HaveOccurred(),
+						})))
 					}
 
 					err := discoverer.DiscoverApplications(ctx, target, nil)
 					Expect(err).To(Equal(context.Canceled))
 				})
+
+				It("reports the error and backoff state of the target", func() {
+					discoverer.BackoffStrategy = backoff.Constant(10 * time.Second)
+
+					go discoverer.DiscoverApplications(ctx, target, nil)
+
+					Eventually(discoverer.Targets).Should(ConsistOf(
+						MatchFields(
+							IgnoreExtras,
+							Fields{
+								"Target":      Equal(target),
+								"Connected":   BeFalse(),
+								"Failures":    BeEquivalentTo(1),
+								"LastError":   MatchError(ContainSubstring("unable to dial target")),
+								"LastErrorAt": Not(BeZero()),
+								"RetryAt":     BeTemporally(">", time.Now()),
+							},
+						),
+					))
+				})
 			})
 		})
 
@@ -0,0 +1,160 @@
+package discoverkit
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"strings"
+
+	"github.com/dogmatiq/configkit"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Authenticator is a function that authenticates the client of a DiscoverAPI
+// call.
+//
+// ctx is the context of the call, from which the gRPC metadata and peer
+// information can be obtained. It returns the principal that identifies the
+// client. An error that is not a gRPC status error is reported to the client
+// with the codes.Unauthenticated code.
+type Authenticator func(ctx context.Context) (principal string, err error)
+
+// Authorizer is a function that determines whether an authenticated client may
+// see a specific application.
+//
+// ctx is the context of the call, and principal is the value returned by the
+// server's Authenticator, if any.
+type Authorizer func(ctx context.Context, principal string, id configkit.Identity) bool
+
+// BearerTokenAuthenticator returns an Authenticator that authenticates clients
+// that send a bearer token in the "authorization" metadata of the call.
+//
+// tokens maps each valid token to the principal that it identifies.
+func BearerTokenAuthenticator(tokens map[string]string) Authenticator {
+	return func(ctx context.Context) (string, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+
+		for _, v := range md.Get("authorization") {
+			scheme, token, ok := strings.Cut(v, " ")
+			if !ok || !strings.EqualFold(scheme, "bearer") {
+				continue
+			}
+
+			for t, principal := range tokens {
+				if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+					return principal, nil
+				}
+			}
+		}
+
+		return "", errors.New("a valid bearer token is required")
+	}
+}
+
+// PeerCertificateAuthenticator is an Authenticator that authenticates clients
+// using their TLS client certificate.
+//
+// The principal is the certificate's SPIFFE ID, if it has one, otherwise it is
+// the certificate's subject common name.
+//
+// It does not verify the certificate itself. The server must be configured
+// with transport credentials that require and verify client certificates, such
+// as those returned by TLSConfig.ServerTransportCredentials().
+func PeerCertificateAuthenticator(ctx context.Context) (string, error) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return "", errors.New("no peer information is available")
+	}
+
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.PeerCertificates) == 0 {
+		return "", errors.New("a TLS client certificate is required")
+	}
+
+	cert := info.State.PeerCertificates[0]
+
+	if id, err := SPIFFEID(cert); err == nil {
+		return id.String(), nil
+	}
+
+	if cert.Subject.CommonName != "" {
+		return cert.Subject.CommonName, nil
+	}
+
+	return "", errors.New("the TLS client certificate has no SPIFFE ID or common name")
+}
+
+// BearerToken is a credentials.PerRPCCredentials implementation that sends a
+// bearer token in the "authorization" metadata of each call.
+//
+// It is compatible with BearerTokenAuthenticator.
+type BearerToken struct {
+	// Token is the bearer token.
+	Token string
+
+	// AllowInsecure permits the token to be sent over connections that do
+	// not use transport security.
+	AllowInsecure bool
+}
+
+var _ credentials.PerRPCCredentials = BearerToken{}
+
+// GetRequestMetadata returns the metadata that contains the bearer token.
+func (t BearerToken) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{
+		"authorization": "Bearer " + t.Token,
+	}, nil
+}
+
+// RequireTransportSecurity returns true unless t.AllowInsecure is true.
+func (t BearerToken) RequireTransportSecurity() bool {
+	return !t.AllowInsecure
+}
+
+// authenticate authenticates the client of a WatchApplications() call.
+func (s *Server) authenticate(ctx context.Context) (string, error) {
+	if s.Authenticate == nil {
+		return "", nil
+	}
+
+	principal, err := s.Authenticate(ctx)
+	if err == nil {
+		return principal, nil
+	}
+
+	if _, ok := status.FromError(err); ok {
+		return "", err
+	}
+
+	return "", status.Error(codes.Unauthenticated, err.Error())
+}
+
+// authorizer returns a function that reports whether the client of a
+// WatchApplications() call may see an application.
+//
+// Decisions are cached for the lifetime of the call so that an "unavailable"
+// response is only sent for applications that were previously reported as
+// "available".
+func (s *Server) authorizer(ctx context.Context, principal string) func(configkit.Identity) bool {
+	if s.Authorize == nil {
+		return func(configkit.Identity) bool {
+			return true
+		}
+	}
+
+	decisions := map[configkit.Identity]bool{}
+
+	return func(id configkit.Identity) bool {
+		allowed, ok := decisions[id]
+		if !ok {
+			allowed = s.Authorize(ctx, principal, id)
+			decisions[id] = allowed
+		}
+
+		return allowed
+	}
+}
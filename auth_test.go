@@ -0,0 +1,260 @@
+package discoverkit_test
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"time"
+
+	"github.com/dogmatiq/configkit"
+	. "github.com/dogmatiq/discoverkit"
+	"github.com/dogmatiq/interopspec/discoverspec"
+	"github.com/dogmatiq/linger/backoff"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+var _ = Describe("authentication and authorization", func() {
+	var (
+		ctx        context.Context
+		cancel     context.CancelFunc
+		app1, app2 configkit.Identity
+		server     *Server
+		discoverer *ApplicationDiscoverer
+		target     Target
+		errs       chan error
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 2*time.Second)
+		DeferCleanup(cancel)
+
+		app1 = configkit.MustNewIdentity("<app-1-name>", "a2b30343-b86c-485c-94e0-de84dda069a7")
+		app2 = configkit.MustNewIdentity("<app-2-name>", "e7f11e2c-791f-4083-8c71-6aa966fc3db1")
+
+		server = &Server{
+			Authenticate: BearerTokenAuthenticator(map[string]string{
+				"<alice-token>": "<alice>",
+				"<bob-token>":   "<bob>",
+			}),
+			Authorize: func(_ context.Context, principal string, id configkit.Identity) bool {
+				return principal == "<bob>" || id == app1
+			},
+		}
+
+		server.Available(app1)
+		server.Available(app2)
+
+		logged := make(chan error, 100)
+		errs = logged
+
+		discoverer = &ApplicationDiscoverer{
+			BackoffStrategy: backoff.Constant(10 * time.Millisecond),
+			LogError: func(_ Target, err error) {
+				logged <- err
+			},
+		}
+
+		listener, err := net.Listen("tcp", "127.0.0.1:")
+		Expect(err).ShouldNot(HaveOccurred())
+
+		gserver := grpc.NewServer()
+		discoverspec.RegisterDiscoverAPIServer(gserver, server)
+		go gserver.Serve(listener)
+		DeferCleanup(gserver.Stop)
+
+		target = Target{
+			Name: listener.Addr().String(),
+			DialOptions: []grpc.DialOption{
+				grpc.WithTransportCredentials(insecure.NewCredentials()),
+			},
+		}
+	})
+
+	// discover starts discovering applications on the target and returns a
+	// channel that receives the identity of each available application, or
+	// the zero-value when an application becomes unavailable.
+	discover := func() <-chan configkit.Identity {
+		apps := make(chan configkit.Identity, 100)
+		done := make(chan struct{})
+
+		DeferCleanup(func() {
+			cancel()
+			<-done
+		})
+
+		go func() {
+			defer close(done)
+			discoverer.DiscoverApplications(
+				ctx,
+				target,
+				func(appCtx context.Context, a Application) {
+					apps <- a.Identity
+
+					go func() {
+						<-appCtx.Done()
+						apps <- configkit.Identity{}
+					}()
+				},
+			)
+		}()
+
+		return apps
+	}
+
+	withToken := func(token string) func(Target) credentials.PerRPCCredentials {
+		return func(Target) credentials.PerRPCCredentials {
+			return BearerToken{
+				Token:         token,
+				AllowInsecure: true,
+			}
+		}
+	}
+
+	It("only sends the applications that the client is authorized to see", func() {
+		discoverer.Credentials = withToken("<alice-token>")
+		apps := discover()
+
+		Eventually(apps).Should(Receive(Equal(app1)))
+		Consistently(apps, 100*time.Millisecond).ShouldNot(Receive())
+
+		server.Unavailable(app2)
+		Consistently(apps, 100*time.Millisecond).ShouldNot(Receive())
+
+		server.Unavailable(app1)
+		Eventually(apps).Should(Receive(BeZero()))
+	})
+
+	It("sends all applications that the client is authorized to see", func() {
+		discoverer.Credentials = withToken("<bob-token>")
+		apps := discover()
+
+		var id1, id2 configkit.Identity
+		Eventually(apps).Should(Receive(&id1))
+		Eventually(apps).Should(Receive(&id2))
+
+		Expect([]configkit.Identity{id1, id2}).To(ConsistOf(app1, app2))
+	})
+
+	It("rejects clients with an invalid token", func() {
+		discoverer.Credentials = withToken("<invalid>")
+		apps := discover()
+
+		var err error
+		Eventually(errs).Should(Receive(&err))
+		Expect(status.Code(err)).To(Equal(codes.Unauthenticated))
+		Expect(err).To(MatchError(ContainSubstring("a valid bearer token is required")))
+		Consistently(apps, 50*time.Millisecond).ShouldNot(Receive())
+	})
+
+	It("rejects clients without credentials", func() {
+		discover()
+
+		var err error
+		Eventually(errs).Should(Receive(&err))
+		Expect(status.Code(err)).To(Equal(codes.Unauthenticated))
+	})
+
+	It("preserves status errors returned by the authenticator", func() {
+		server.Authenticate = func(context.Context) (string, error) {
+			return "", status.Error(codes.PermissionDenied, "<denied>")
+		}
+
+		discover()
+
+		var err error
+		Eventually(errs).Should(Receive(&err))
+		Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+	})
+})
+
+var _ = Describe("func PeerCertificateAuthenticator()", func() {
+	It("returns the SPIFFE ID of the client certificate", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		dir := GinkgoT().TempDir()
+		ca := newTestCA()
+		ca.WriteCA(filepath.Join(dir, "ca.pem"))
+		ca.WriteCert(
+			filepath.Join(dir, "server.pem"),
+			filepath.Join(dir, "server-key.pem"),
+			"engine.example.org",
+			"",
+		)
+		ca.WriteCert(
+			filepath.Join(dir, "client.pem"),
+			filepath.Join(dir, "client-key.pem"),
+			"",
+			"spiffe://example.org/client",
+		)
+
+		serverTLS := &TLSConfig{
+			CAFile:   filepath.Join(dir, "ca.pem"),
+			CertFile: filepath.Join(dir, "server.pem"),
+			KeyFile:  filepath.Join(dir, "server-key.pem"),
+		}
+
+		clientTLS := &TLSConfig{
+			CAFile:   filepath.Join(dir, "ca.pem"),
+			CertFile: filepath.Join(dir, "client.pem"),
+			KeyFile:  filepath.Join(dir, "client-key.pem"),
+		}
+
+		principals := make(chan string, 1)
+
+		server := &Server{
+			Authenticate: PeerCertificateAuthenticator,
+			Authorize: func(_ context.Context, principal string, _ configkit.Identity) bool {
+				principals <- principal
+				return true
+			},
+		}
+		server.Available(configkit.MustNewIdentity("<app-name>", appKey))
+
+		listener, err := net.Listen("tcp", "127.0.0.1:")
+		Expect(err).ShouldNot(HaveOccurred())
+
+		gserver := grpc.NewServer(grpc.Creds(serverTLS.ServerTransportCredentials()))
+		discoverspec.RegisterDiscoverAPIServer(gserver, server)
+		go gserver.Serve(listener)
+		defer gserver.Stop()
+
+		d := &ApplicationDiscoverer{}
+		go d.DiscoverApplications(
+			ctx,
+			Target{
+				Name:        listener.Addr().String(),
+				DialOptions: clientTLS.DialOptions("engine.example.org"),
+			},
+			func(context.Context, Application) {},
+		)
+
+		Eventually(principals).Should(Receive(Equal("spiffe://example.org/client")))
+	})
+
+	It("returns an error if there is no peer information", func() {
+		_, err := PeerCertificateAuthenticator(context.Background())
+		Expect(err).To(MatchError("no peer information is available"))
+	})
+})
+
+var _ = Describe("type BearerToken", func() {
+	It("sends the token in the authorization metadata", func() {
+		md, err := BearerToken{Token: "<token>"}.GetRequestMetadata(context.Background())
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(md).To(Equal(map[string]string{
+			"authorization": "Bearer <token>",
+		}))
+	})
+
+	It("requires transport security by default", func() {
+		Expect(BearerToken{}.RequireTransportSecurity()).To(BeTrue())
+		Expect(BearerToken{AllowInsecure: true}.RequireTransportSecurity()).To(BeFalse())
+	})
+})
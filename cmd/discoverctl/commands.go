@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"io"
+	"sync"
+
+	"github.com/dogmatiq/discoverkit"
+)
+
+// runTargets runs the "targets" command, which prints targets as they are
+// discovered and become unavailable.
+func runTargets(ctx context.Context, fs *flag.FlagSet, args []string, stdout io.Writer) error {
+	var (
+		out     outputOptions
+		tls     tlsOptions
+		targets targetOptions
+	)
+
+	out.register(fs)
+	tls.register(fs)
+	targets.register(fs)
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	d, err := targets.Discoverer(tls.Config())
+	if err != nil {
+		return err
+	}
+
+	p := newPrinter(stdout, out)
+
+	var g sync.WaitGroup
+	defer g.Wait()
+
+	return d.DiscoverTargets(
+		ctx,
+		func(ctx context.Context, t discoverkit.Target) {
+			p.Target(targetAvailable, t)
+
+			g.Add(1)
+			go func() {
+				defer g.Done()
+				<-ctx.Done()
+				p.Target(targetUnavailable, t)
+			}()
+		},
+	)
+}
+
+// runApps runs the "apps" command, which prints the applications that become
+// available and unavailable on a single target.
+func runApps(ctx context.Context, fs *flag.FlagSet, args []string, stdout io.Writer) error {
+	var (
+		out outputOptions
+		tls tlsOptions
+	)
+
+	out.register(fs)
+	tls.register(fs)
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("exactly one target name is required")
+	}
+
+	p := newPrinter(stdout, out)
+	d := &discoverkit.ApplicationDiscoverer{
+		LogError: p.Error,
+	}
+
+	var g sync.WaitGroup
+	defer g.Wait()
+
+	return d.DiscoverApplications(
+		ctx,
+		discoverkit.Target{
+			Name:        fs.Arg(0),
+			DialOptions: dialOptions(tls.Config(), ""),
+		},
+		observeApplications(p, &g),
+	)
+}
+
+// runWatch runs the "watch" command, which discovers targets and prints the
+// applications that become available and unavailable on each of them.
+func runWatch(ctx context.Context, fs *flag.FlagSet, args []string, stdout io.Writer) error {
+	var (
+		out     outputOptions
+		tls     tlsOptions
+		targets targetOptions
+	)
+
+	out.register(fs)
+	tls.register(fs)
+	targets.register(fs)
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	td, err := targets.Discoverer(tls.Config())
+	if err != nil {
+		return err
+	}
+
+	p := newPrinter(stdout, out)
+	ad := &discoverkit.ApplicationDiscoverer{
+		LogError: p.Error,
+	}
+
+	var g sync.WaitGroup
+	defer g.Wait()
+
+	obs := observeApplications(p, &g)
+
+	return td.DiscoverTargets(
+		ctx,
+		func(ctx context.Context, t discoverkit.Target) {
+			p.Target(targetAvailable, t)
+
+			g.Add(1)
+			go func() {
+				defer g.Done()
+
+				// DiscoverApplications() returns early if the target does
+				// not implement the DiscoverAPI, so we wait for the target
+				// to actually become unavailable before printing.
+				ad.DiscoverApplications(ctx, t, obs)
+				<-ctx.Done()
+
+				p.Target(targetUnavailable, t)
+			}()
+		},
+	)
+}
+
+// observeApplications returns an observer that prints application
+// availability changes to p.
+//
+// g tracks the goroutines started by the observer.
+func observeApplications(p *printer, g *sync.WaitGroup) discoverkit.ApplicationObserver {
+	return func(ctx context.Context, a discoverkit.Application) {
+		p.Application(applicationAvailable, a)
+
+		g.Add(1)
+		go func() {
+			defer g.Done()
+			<-ctx.Done()
+			p.Application(applicationUnavailable, a)
+		}()
+	}
+}
@@ -0,0 +1,73 @@
+// Command discoverctl discovers gRPC targets and the Dogma applications that
+// they host, printing changes as they occur.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+const usage = `usage: discoverctl <command> [flags]
+
+commands:
+  targets         discover gRPC targets and print them as they come and go
+  apps <target>   watch a single target and print application availability
+  watch           discover targets and print application availability on each
+
+Run 'discoverctl <command> -h' for a list of flags accepted by each command.
+`
+
+func main() {
+	ctx, cancel := signal.NotifyContext(
+		context.Background(),
+		os.Interrupt,
+		syscall.SIGTERM,
+	)
+	defer cancel()
+
+	if err := run(ctx, os.Args[1:], os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// run executes the command described by args.
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return errors.New("no command specified")
+	}
+
+	var cmd func(context.Context, *flag.FlagSet, []string, io.Writer) error
+
+	switch args[0] {
+	case "targets":
+		cmd = runTargets
+	case "apps":
+		cmd = runApps
+	case "watch":
+		cmd = runWatch
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, usage)
+		return nil
+	default:
+		fmt.Fprint(stderr, usage)
+		return fmt.Errorf("unknown command: %s", args[0])
+	}
+
+	fs := flag.NewFlagSet("discoverctl "+args[0], flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	err := cmd(ctx, fs, args[1:], stdout)
+	if errors.Is(err, flag.ErrHelp) || errors.Is(err, context.Canceled) {
+		return nil
+	}
+
+	return err
+}
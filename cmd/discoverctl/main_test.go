@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/dogmatiq/configkit"
+	"github.com/dogmatiq/discoverkit"
+	"github.com/dogmatiq/interopspec/discoverspec"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+)
+
+var _ = Describe("func run()", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+		stdout *syncBuffer
+		stderr *syncBuffer
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithCancel(context.Background())
+		DeferCleanup(cancel)
+
+		stdout = &syncBuffer{}
+		stderr = &syncBuffer{}
+	})
+
+	It("returns an error if no command is specified", func() {
+		err := run(ctx, nil, stdout, stderr)
+		Expect(err).To(MatchError("no command specified"))
+		Expect(stderr.String()).To(ContainSubstring("usage: discoverctl"))
+	})
+
+	It("returns an error if the command is unknown", func() {
+		err := run(ctx, []string{"<unknown>"}, stdout, stderr)
+		Expect(err).To(MatchError("unknown command: <unknown>"))
+	})
+
+	Describe("command targets", func() {
+		It("requires a target discoverer", func() {
+			err := run(ctx, []string{"targets"}, stdout, stderr)
+			Expect(err).To(MatchError("one of -dns, -srv, -static or -kubernetes is required"))
+		})
+
+		It("rejects multiple target discoverers", func() {
+			err := run(ctx, []string{"targets", "-static", "a", "-dns", "b"}, stdout, stderr)
+			Expect(err).To(MatchError("only one of -dns, -srv, -static or -kubernetes may be used"))
+		})
+
+		It("prints targets as JSON lines", func() {
+			go func() {
+				defer GinkgoRecover()
+				Eventually(stdout.String).Should(ContainSubstring("<target-2>"))
+				cancel()
+			}()
+
+			err := run(
+				ctx,
+				[]string{"targets", "-json", "-static", "<target-1>,<target-2>"},
+				stdout,
+				stderr,
+			)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			events := parseEvents(stdout.String())
+			Expect(events).To(ConsistOf(
+				HaveKeyWithValue("type", "target-available"),
+				HaveKeyWithValue("type", "target-available"),
+				HaveKeyWithValue("type", "target-unavailable"),
+				HaveKeyWithValue("type", "target-unavailable"),
+			))
+			Expect(events[0]).To(HaveKeyWithValue("target", "<target-1>"))
+			Expect(events[1]).To(HaveKeyWithValue("target", "<target-2>"))
+		})
+
+		It("prints targets as text by default", func() {
+			go func() {
+				defer GinkgoRecover()
+				Eventually(stdout.String).Should(ContainSubstring("<target>"))
+				cancel()
+			}()
+
+			err := run(ctx, []string{"targets", "-static", "<target>"}, stdout, stderr)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(stdout.String()).To(ContainSubstring(" + target <target>\n"))
+			Expect(stdout.String()).To(ContainSubstring(" - target <target>\n"))
+		})
+	})
+
+	When("there is a discovery server", func() {
+		var (
+			server *discoverkit.Server
+			addr   string
+			app    configkit.Identity
+		)
+
+		BeforeEach(func() {
+			app = configkit.MustNewIdentity("<app-name>", "420a8fe8-0c57-44e0-8332-d5c5f93a63fc")
+			server = &discoverkit.Server{}
+			server.Available(app)
+
+			listener, err := net.Listen("tcp", "127.0.0.1:")
+			Expect(err).ShouldNot(HaveOccurred())
+
+			gserver := grpc.NewServer()
+			discoverspec.RegisterDiscoverAPIServer(gserver, server)
+			go gserver.Serve(listener)
+			DeferCleanup(gserver.Stop)
+
+			addr = listener.Addr().String()
+		})
+
+		Describe("command apps", func() {
+			It("requires a target name", func() {
+				err := run(ctx, []string{"apps"}, stdout, stderr)
+				Expect(err).To(MatchError("exactly one target name is required"))
+			})
+
+			It("prints application availability changes", func() {
+				go func() {
+					defer GinkgoRecover()
+					Eventually(stdout.String).Should(ContainSubstring("application-available"))
+					server.Unavailable(app)
+					Eventually(stdout.String).Should(ContainSubstring("application-unavailable"))
+					cancel()
+				}()
+
+				err := run(ctx, []string{"apps", "-json", addr}, stdout, stderr)
+				Expect(err).ShouldNot(HaveOccurred())
+
+				events := parseEvents(stdout.String())
+				Expect(events).To(HaveLen(2))
+				Expect(events[0]).To(HaveKeyWithValue("type", "application-available"))
+				Expect(events[0]).To(HaveKeyWithValue("target", addr))
+				Expect(events[0]).To(HaveKeyWithValue("application", "<app-name>"))
+				Expect(events[0]).To(HaveKeyWithValue("key", app.Key))
+				Expect(events[1]).To(HaveKeyWithValue("type", "application-unavailable"))
+			})
+		})
+
+		Describe("command watch", func() {
+			It("prints targets and application availability changes", func() {
+				go func() {
+					defer GinkgoRecover()
+					Eventually(stdout.String).Should(ContainSubstring("application-available"))
+					cancel()
+				}()
+
+				err := run(ctx, []string{"watch", "-json", "-static", addr}, stdout, stderr)
+				Expect(err).ShouldNot(HaveOccurred())
+
+				events := parseEvents(stdout.String())
+				Expect(events).To(HaveLen(4))
+				Expect(events[0]).To(HaveKeyWithValue("type", "target-available"))
+				Expect(events[1]).To(HaveKeyWithValue("type", "application-available"))
+				Expect(events[1]).To(HaveKeyWithValue("application", "<app-name>"))
+				Expect(events[2:]).To(ConsistOf(
+					HaveKeyWithValue("type", "application-unavailable"),
+					HaveKeyWithValue("type", "target-unavailable"),
+				))
+			})
+		})
+	})
+})
+
+var _ = Describe("type tlsOptions", func() {
+	Describe("func Config()", func() {
+		It("returns nil if no TLS flags are set", func() {
+			var o tlsOptions
+			Expect(o.Config()).To(BeNil())
+		})
+
+		It("returns a configuration if TLS is enabled", func() {
+			o := tlsOptions{
+				Enabled: true,
+			}
+
+			Expect(o.Config()).To(Equal(&discoverkit.TLSConfig{}))
+		})
+
+		It("maps the flags onto the configuration", func() {
+			o := tlsOptions{
+				CAFile:            "<ca>",
+				CertFile:          "<cert>",
+				KeyFile:           "<key>",
+				ServerName:        "<server-name>",
+				SPIFFEID:          "spiffe://example.org/engine",
+				SPIFFETrustDomain: "example.org",
+			}
+
+			Expect(o.Config()).To(Equal(&discoverkit.TLSConfig{
+				CAFile:            "<ca>",
+				CertFile:          "<cert>",
+				KeyFile:           "<key>",
+				ServerName:        "<server-name>",
+				SPIFFEIDs:         []string{"spiffe://example.org/engine"},
+				SPIFFETrustDomain: "example.org",
+			}))
+		})
+	})
+})
+
+// parseEvents parses JSON-lines output.
+func parseEvents(out string) []map[string]any {
+	var events []map[string]any
+
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		ev := map[string]any{}
+		err := json.Unmarshal([]byte(line), &ev)
+		ExpectWithOffset(1, err).ShouldNot(HaveOccurred())
+		ExpectWithOffset(1, ev).To(HaveKey("time"))
+		events = append(events, ev)
+	}
+
+	return events
+}
+
+// syncBuffer is a bytes.Buffer that is safe for concurrent use.
+type syncBuffer struct {
+	m   sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(data []byte) (int, error) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	return b.buf.Write(data)
+}
+
+func (b *syncBuffer) String() string {
+	b.m.Lock()
+	defer b.m.Unlock()
+	return b.buf.String()
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dogmatiq/discoverkit"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// outputOptions are the flags that control how events are printed.
+type outputOptions struct {
+	JSON bool
+}
+
+// register adds the output flags to fs.
+func (o *outputOptions) register(fs *flag.FlagSet) {
+	fs.BoolVar(&o.JSON, "json", false, "print events as JSON lines")
+}
+
+// tlsOptions are the flags that control the dial options used when dialing
+// discovered targets.
+type tlsOptions struct {
+	Enabled           bool
+	CAFile            string
+	CertFile          string
+	KeyFile           string
+	ServerName        string
+	SPIFFEID          string
+	SPIFFETrustDomain string
+}
+
+// register adds the TLS flags to fs.
+func (o *tlsOptions) register(fs *flag.FlagSet) {
+	fs.BoolVar(&o.Enabled, "tls", false, "use TLS when dialing targets (implied by the other -tls-* flags)")
+	fs.StringVar(&o.CAFile, "tls-ca", "", "PEM file containing the CA certificates used to verify targets")
+	fs.StringVar(&o.CertFile, "tls-cert", "", "PEM file containing the client certificate")
+	fs.StringVar(&o.KeyFile, "tls-key", "", "PEM file containing the client certificate's private key")
+	fs.StringVar(&o.ServerName, "tls-server-name", "", "server name used to verify targets, overrides the discovered name")
+	fs.StringVar(&o.SPIFFEID, "tls-spiffe-id", "", "verify targets by this SPIFFE ID instead of by server name")
+	fs.StringVar(&o.SPIFFETrustDomain, "tls-spiffe-trust-domain", "", "verify targets by SPIFFE ID, accepting any ID in this trust domain")
+}
+
+// Config returns the TLS configuration described by the flags, or nil if TLS
+// is not enabled.
+func (o *tlsOptions) Config() *discoverkit.TLSConfig {
+	c := &discoverkit.TLSConfig{
+		CAFile:            o.CAFile,
+		CertFile:          o.CertFile,
+		KeyFile:           o.KeyFile,
+		ServerName:        o.ServerName,
+		SPIFFETrustDomain: o.SPIFFETrustDomain,
+	}
+
+	if o.SPIFFEID != "" {
+		c.SPIFFEIDs = []string{o.SPIFFEID}
+	}
+
+	if !o.Enabled &&
+		c.CAFile == "" &&
+		c.CertFile == "" &&
+		c.KeyFile == "" &&
+		c.ServerName == "" &&
+		c.SPIFFEIDs == nil &&
+		c.SPIFFETrustDomain == "" {
+		return nil
+	}
+
+	return c
+}
+
+// dialOptions returns the dial options used to dial a target that was
+// discovered by querying serverName, which may be empty.
+func dialOptions(c *discoverkit.TLSConfig, serverName string) []grpc.DialOption {
+	if c == nil {
+		return []grpc.DialOption{
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+		}
+	}
+
+	return c.DialOptions(serverName)
+}
+
+// targetOptions are the flags that select and configure a target discoverer.
+type targetOptions struct {
+	DNS        string
+	SRV        string
+	Static     string
+	Kubernetes bool
+	PortName   string
+	Port       string
+	Interval   time.Duration
+}
+
+// register adds the target discovery flags to fs.
+func (o *targetOptions) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.DNS, "dns", "", "discover targets by querying the A/AAAA records of this host")
+	fs.StringVar(&o.SRV, "srv", "", "discover targets by querying the SRV records of this name, such as _dogma._tcp.example.org")
+	fs.StringVar(&o.Static, "static", "", "comma-separated list of static target names")
+	fs.BoolVar(&o.Kubernetes, "kubernetes", false, "discover targets from Kubernetes service environment variables")
+	fs.StringVar(&o.PortName, "port-name", discoverkit.DefaultKubernetesPortName, "Kubernetes port name used with -kubernetes")
+	fs.StringVar(&o.Port, "port", discoverkit.DefaultGRPCPort, "port used for addresses discovered with -dns")
+	fs.DurationVar(&o.Interval, "interval", discoverkit.DefaultDNSQueryInterval, "query interval used with -dns and -srv")
+}
+
+// Discoverer returns the target discoverer selected by the flags.
+func (o *targetOptions) Discoverer(c *discoverkit.TLSConfig) (discoverkit.TargetDiscoverer, error) {
+	var (
+		d discoverkit.TargetDiscoverer
+		n int
+	)
+
+	if o.DNS != "" {
+		n++
+		d = &discoverkit.DNSTargetDiscoverer{
+			QueryHost:     o.DNS,
+			QueryInterval: o.Interval,
+			NewTargets: func(_ context.Context, addr string) ([]discoverkit.Target, error) {
+				return []discoverkit.Target{
+					{
+						Name:        net.JoinHostPort(addr, o.Port),
+						DialOptions: dialOptions(c, o.DNS),
+					},
+				}, nil
+			},
+		}
+	}
+
+	if o.SRV != "" {
+		n++
+		d = &discoverkit.DNSTargetDiscoverer{
+			QueryHost:     o.SRV,
+			QueryInterval: o.Interval,
+			LookupHost:    lookupSRV,
+			NewTargets: func(_ context.Context, addr string) ([]discoverkit.Target, error) {
+				host, _, err := net.SplitHostPort(addr)
+				if err != nil {
+					return nil, err
+				}
+
+				return []discoverkit.Target{
+					{
+						Name:        addr,
+						DialOptions: dialOptions(c, host),
+					},
+				}, nil
+			},
+		}
+	}
+
+	if o.Static != "" {
+		n++
+		var s discoverkit.StaticTargetDiscoverer
+		for _, name := range strings.Split(o.Static, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				s = append(s, discoverkit.Target{
+					Name:        name,
+					DialOptions: dialOptions(c, ""),
+				})
+			}
+		}
+		d = s
+	}
+
+	if o.Kubernetes {
+		n++
+		k := &discoverkit.KubernetesEnvironmentTargetDiscoverer{
+			PortName: o.PortName,
+			TLS:      c,
+		}
+
+		if c == nil {
+			k.DialOptions = func(string) []grpc.DialOption {
+				return dialOptions(nil, "")
+			}
+		}
+
+		d = k
+	}
+
+	switch n {
+	case 0:
+		return nil, errors.New("one of -dns, -srv, -static or -kubernetes is required")
+	case 1:
+		return d, nil
+	default:
+		return nil, errors.New("only one of -dns, -srv, -static or -kubernetes may be used")
+	}
+}
+
+// lookupSRV performs an SRV query for the given name and returns each result
+// as a "host:port" address.
+//
+// It has the same signature as DNSTargetDiscoverer.LookupHost, which allows
+// the DNS discoverer to be used to discover SRV targets.
+func lookupSRV(ctx context.Context, name string) ([]string, error) {
+	_, records, err := net.DefaultResolver.LookupSRV(ctx, "", "", name)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := make([]string, 0, len(records))
+	for _, r := range records {
+		addrs = append(addrs, net.JoinHostPort(
+			strings.TrimSuffix(r.Target, "."),
+			strconv.Itoa(int(r.Port)),
+		))
+	}
+
+	return addrs, nil
+}
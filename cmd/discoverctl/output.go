@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/dogmatiq/configkit"
+	"github.com/dogmatiq/discoverkit"
+)
+
+// event is a change in the discovered targets or applications.
+type event struct {
+	Time        time.Time `json:"time"`
+	Type        string    `json:"type"`
+	Target      string    `json:"target"`
+	Application string    `json:"application,omitempty"`
+	Key         string    `json:"key,omitempty"`
+	Error       string    `json:"error,omitempty"`
+}
+
+const (
+	targetAvailable        = "target-available"
+	targetUnavailable      = "target-unavailable"
+	targetError            = "target-error"
+	applicationAvailable   = "application-available"
+	applicationUnavailable = "application-unavailable"
+)
+
+// printer writes events to an output stream.
+//
+// It is safe for concurrent use.
+type printer struct {
+	m    sync.Mutex
+	w    io.Writer
+	json bool
+	now  func() time.Time
+}
+
+// newPrinter returns a printer that writes to w.
+func newPrinter(w io.Writer, o outputOptions) *printer {
+	return &printer{
+		w:    w,
+		json: o.JSON,
+		now:  time.Now,
+	}
+}
+
+// Target prints a target-related event.
+func (p *printer) Target(typ string, t discoverkit.Target) {
+	p.print(event{
+		Type:   typ,
+		Target: t.Name,
+	})
+}
+
+// Application prints an application-related event.
+func (p *printer) Application(typ string, a discoverkit.Application) {
+	p.print(event{
+		Type:        typ,
+		Target:      a.Target.Name,
+		Application: a.Identity.Name,
+		Key:         a.Identity.Key,
+	})
+}
+
+// Error prints an error that occurred while watching a target.
+func (p *printer) Error(t discoverkit.Target, err error) {
+	p.print(event{
+		Type:   targetError,
+		Target: t.Name,
+		Error:  err.Error(),
+	})
+}
+
+// print writes ev to the output stream.
+func (p *printer) print(ev event) {
+	ev.Time = p.now()
+
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	if p.json {
+		enc := json.NewEncoder(p.w)
+		enc.SetEscapeHTML(false)
+		enc.Encode(ev)
+		return
+	}
+
+	ts := ev.Time.Format(time.RFC3339)
+
+	switch ev.Type {
+	case targetAvailable:
+		fmt.Fprintf(p.w, "%s + target %s\n", ts, ev.Target)
+	case targetUnavailable:
+		fmt.Fprintf(p.w, "%s - target %s\n", ts, ev.Target)
+	case targetError:
+		fmt.Fprintf(p.w, "%s ! target %s: %s\n", ts, ev.Target, ev.Error)
+	case applicationAvailable:
+		fmt.Fprintf(p.w, "%s + application %s on %s\n", ts, identity(ev), ev.Target)
+	case applicationUnavailable:
+		fmt.Fprintf(p.w, "%s - application %s on %s\n", ts, identity(ev), ev.Target)
+	}
+}
+
+// identity returns a string representation of the application identity in
+// ev.
+func identity(ev event) string {
+	return configkit.Identity{
+		Name: ev.Application,
+		Key:  ev.Key,
+	}.String()
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/dogmatiq/configkit"
+	"github.com/dogmatiq/discoverkit"
+)
+
+// newAdminHandler returns the HTTP handler for the admin API.
+//
+// The API allows external processes to advertise applications on the server
+// by acquiring leases:
+//
+//	GET    /v1/leases       list the current leases
+//	POST   /v1/leases       grant a new lease
+//	PUT    /v1/leases/{id}  renew an existing lease
+//	DELETE /v1/leases/{id}  revoke an existing lease
+//	GET    /debug           render the server's state
+func newAdminHandler(r *registry, defaultTTL time.Duration) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /v1/leases", func(w http.ResponseWriter, _ *http.Request) {
+		leases := []leaseResponse{}
+		for _, l := range r.Leases() {
+			leases = append(leases, newLeaseResponse(l))
+		}
+
+		writeJSON(w, http.StatusOK, leases)
+	})
+
+	mux.HandleFunc("POST /v1/leases", func(w http.ResponseWriter, req *http.Request) {
+		var body struct {
+			Name string `json:"name"`
+			Key  string `json:"key"`
+			TTL  string `json:"ttl"`
+		}
+
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+			return
+		}
+
+		ttl := defaultTTL
+		if body.TTL != "" {
+			var err error
+			ttl, err = time.ParseDuration(body.TTL)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("invalid TTL: %w", err))
+				return
+			}
+		}
+
+		if ttl <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid TTL %s, must be positive", ttl))
+			return
+		}
+
+		id, err := configkit.NewIdentity(body.Name, body.Key)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		l, err := r.Grant(id, ttl)
+		if err != nil {
+			writeError(w, http.StatusConflict, err)
+			return
+		}
+
+		writeJSON(w, http.StatusCreated, newLeaseResponse(l))
+	})
+
+	mux.HandleFunc("PUT /v1/leases/{id}", func(w http.ResponseWriter, req *http.Request) {
+		l, err := r.Renew(req.PathValue("id"))
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, newLeaseResponse(l))
+	})
+
+	mux.HandleFunc("DELETE /v1/leases/{id}", func(w http.ResponseWriter, req *http.Request) {
+		if err := r.Revoke(req.PathValue("id")); err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.Handle("GET /debug", &discoverkit.ServerDebugHandler{
+		Server: r.Server,
+	})
+
+	return mux
+}
+
+// leaseResponse is the JSON representation of a lease.
+type leaseResponse struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Key       string    `json:"key"`
+	TTL       string    `json:"ttl"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// newLeaseResponse returns the JSON representation of l.
+func newLeaseResponse(l lease) leaseResponse {
+	return leaseResponse{
+		ID:        l.ID,
+		Name:      l.Identity.Name,
+		Key:       l.Identity.Key,
+		TTL:       l.TTL.String(),
+		ExpiresAt: l.ExpiresAt.UTC(),
+	}
+}
+
+// writeJSON writes v to w as JSON.
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes err to w as a JSON error response.
+func writeError(w http.ResponseWriter, code int, err error) {
+	if errors.Is(err, errLeaseNotFound) {
+		code = http.StatusNotFound
+	}
+
+	writeJSON(w, code, struct {
+		Error string `json:"error"`
+	}{err.Error()})
+}
@@ -0,0 +1,112 @@
+// Command discoverd runs a stand-alone DiscoverAPI server.
+//
+// It allows processes that can not embed a discoverkit.Server, such as
+// engines written in languages other than Go, to advertise their applications
+// by acquiring leases via an HTTP admin API. An application remains available
+// for as long as it holds at least one unexpired lease.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/dogmatiq/discoverkit"
+	"github.com/dogmatiq/interopspec/discoverspec"
+	"google.golang.org/grpc"
+)
+
+func main() {
+	ctx, cancel := signal.NotifyContext(
+		context.Background(),
+		os.Interrupt,
+		syscall.SIGTERM,
+	)
+	defer cancel()
+
+	if err := run(ctx, os.Args[1:], os.Stderr, nil); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// run starts the servers and blocks until ctx is canceled.
+//
+// If ready is non-nil it is called with the addresses of the gRPC and admin
+// listeners once both servers are accepting connections.
+func run(
+	ctx context.Context,
+	args []string,
+	stderr io.Writer,
+	ready func(grpcAddr, adminAddr net.Addr),
+) error {
+	fs := flag.NewFlagSet("discoverd", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	grpcAddr := fs.String("listen", ":"+discoverkit.DefaultGRPCPort, "address on which the DiscoverAPI server listens")
+	adminAddr := fs.String("admin-listen", "127.0.0.1:8080", "address on which the HTTP admin API listens")
+	defaultTTL := fs.Duration("default-ttl", 30*time.Second, "lease TTL used when a request does not specify one")
+
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	grpcListener, err := net.Listen("tcp", *grpcAddr)
+	if err != nil {
+		return err
+	}
+	defer grpcListener.Close()
+
+	adminListener, err := net.Listen("tcp", *adminAddr)
+	if err != nil {
+		return err
+	}
+	defer adminListener.Close()
+
+	server := &discoverkit.Server{}
+	reg := &registry{Server: server}
+	defer reg.Close()
+
+	gserver := grpc.NewServer()
+	discoverspec.RegisterDiscoverAPIServer(gserver, server)
+
+	hserver := &http.Server{
+		Handler:           newAdminHandler(reg, *defaultTTL),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errs := make(chan error, 2)
+
+	go func() {
+		errs <- gserver.Serve(grpcListener)
+	}()
+
+	go func() {
+		errs <- hserver.Serve(adminListener)
+	}()
+
+	if ready != nil {
+		ready(grpcListener.Addr(), adminListener.Addr())
+	}
+
+	select {
+	case <-ctx.Done():
+	case err = <-errs:
+	}
+
+	hserver.Close()
+	gserver.Stop()
+
+	return err
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/dogmatiq/configkit"
+	"github.com/dogmatiq/discoverkit"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+var _ = Describe("func run()", func() {
+	var (
+		ctx       context.Context
+		cancel    context.CancelFunc
+		grpcAddr  string
+		adminURL  string
+		done      chan error
+		appKey    = "420a8fe8-0c57-44e0-8332-d5c5f93a63fc"
+		available chan configkit.Identity
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		DeferCleanup(cancel)
+
+		ready := make(chan struct{})
+		result := make(chan error, 1)
+		done = result
+
+		go func() {
+			result <- run(
+				ctx,
+				[]string{"-listen", "127.0.0.1:", "-admin-listen", "127.0.0.1:"},
+				io.Discard,
+				func(g, a net.Addr) {
+					grpcAddr = g.String()
+					adminURL = "http://" + a.String()
+					close(ready)
+				},
+			)
+		}()
+
+		select {
+		case <-ready:
+		case err := <-done:
+			Fail("run() returned early: " + err.Error())
+		}
+
+		available = make(chan configkit.Identity, 10)
+		disc := &discoverkit.ApplicationDiscoverer{}
+		go disc.DiscoverApplications(
+			ctx,
+			discoverkit.Target{
+				Name: grpcAddr,
+				DialOptions: []grpc.DialOption{
+					grpc.WithTransportCredentials(insecure.NewCredentials()),
+				},
+			},
+			func(appCtx context.Context, a discoverkit.Application) {
+				available <- a.Identity
+
+				go func() {
+					<-appCtx.Done()
+					available <- configkit.Identity{}
+				}()
+			},
+		)
+	})
+
+	It("advertises applications that hold a lease", func() {
+		res := request("POST", adminURL+"/v1/leases", `{"name": "<app-name>", "key": "`+appKey+`", "ttl": "1m"}`)
+		Expect(res.StatusCode).To(Equal(http.StatusCreated))
+
+		var l map[string]any
+		err := json.NewDecoder(res.Body).Decode(&l)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(l).To(HaveKeyWithValue("name", "<app-name>"))
+		Expect(l).To(HaveKeyWithValue("key", appKey))
+		Expect(l).To(HaveKeyWithValue("ttl", "1m0s"))
+		Expect(l).To(HaveKey("expires_at"))
+
+		Eventually(available).Should(Receive(Equal(
+			configkit.MustNewIdentity("<app-name>", appKey),
+		)))
+
+		res = request("PUT", adminURL+"/v1/leases/"+l["id"].(string), "")
+		Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+		res = request("GET", adminURL+"/v1/leases", "")
+		Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+		var leases []map[string]any
+		err = json.NewDecoder(res.Body).Decode(&leases)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(leases).To(ConsistOf(HaveKeyWithValue("id", l["id"])))
+
+		res = request("DELETE", adminURL+"/v1/leases/"+l["id"].(string), "")
+		Expect(res.StatusCode).To(Equal(http.StatusNoContent))
+
+		Eventually(available).Should(Receive(BeZero()))
+
+		res = request("DELETE", adminURL+"/v1/leases/"+l["id"].(string), "")
+		Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+	})
+
+	It("rejects invalid identities", func() {
+		res := request("POST", adminURL+"/v1/leases", `{"name": "", "key": "`+appKey+`"}`)
+		Expect(res.StatusCode).To(Equal(http.StatusBadRequest))
+	})
+
+	It("rejects invalid TTLs", func() {
+		res := request("POST", adminURL+"/v1/leases", `{"name": "<app-name>", "key": "`+appKey+`", "ttl": "-1s"}`)
+		Expect(res.StatusCode).To(Equal(http.StatusBadRequest))
+	})
+
+	It("serves the debug page", func() {
+		res := request("GET", adminURL+"/debug?format=json", "")
+		Expect(res.StatusCode).To(Equal(http.StatusOK))
+		Expect(res.Header.Get("Content-Type")).To(Equal("application/json"))
+	})
+
+	It("stops when the context is canceled", func() {
+		cancel()
+		Eventually(done).Should(Receive(BeNil()))
+	})
+})
+
+// request performs an HTTP request against the admin API.
+func request(method, url, body string) *http.Response {
+	req, err := http.NewRequest(method, url, bytes.NewBufferString(body))
+	ExpectWithOffset(1, err).ShouldNot(HaveOccurred())
+
+	res, err := http.DefaultClient.Do(req)
+	ExpectWithOffset(1, err).ShouldNot(HaveOccurred())
+	DeferCleanup(res.Body.Close)
+
+	return res
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dogmatiq/configkit"
+	"github.com/dogmatiq/discoverkit"
+)
+
+// errLeaseNotFound is returned when an operation refers to a lease that does
+// not exist, or that has already expired.
+var errLeaseNotFound = errors.New("lease not found")
+
+// lease is a time-limited registration of an application.
+type lease struct {
+	ID        string
+	Identity  configkit.Identity
+	TTL       time.Duration
+	ExpiresAt time.Time
+
+	timer *time.Timer
+}
+
+// registry advertises applications on a discoverkit.Server for as long as
+// they hold at least one unexpired lease.
+//
+// Multiple leases may be held for the same application, for example by
+// several sidecars that each advertise the same application on behalf of
+// different processes.
+type registry struct {
+	Server *discoverkit.Server
+
+	m      sync.Mutex
+	leases map[string]*lease
+	counts map[string]int // number of leases, by identity key
+}
+
+// Grant creates a new lease for the application with the given identity. The
+// application is available until the lease expires or is revoked.
+func (r *registry) Grant(id configkit.Identity, ttl time.Duration) (lease, error) {
+	if err := id.Validate(); err != nil {
+		return lease{}, err
+	}
+
+	if ttl <= 0 {
+		return lease{}, fmt.Errorf("invalid TTL %s, must be positive", ttl)
+	}
+
+	leaseID, err := newLeaseID()
+	if err != nil {
+		return lease{}, err
+	}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	for _, l := range r.leases {
+		if l.Identity.ConflictsWith(id) && l.Identity != id {
+			return lease{}, fmt.Errorf(
+				"%s conflicts with the existing application %s",
+				id,
+				l.Identity,
+			)
+		}
+	}
+
+	if r.leases == nil {
+		r.leases = map[string]*lease{}
+		r.counts = map[string]int{}
+	}
+
+	l := &lease{
+		ID:        leaseID,
+		Identity:  id,
+		TTL:       ttl,
+		ExpiresAt: time.Now().Add(ttl),
+	}
+
+	l.timer = time.AfterFunc(ttl, func() {
+		r.expire(l)
+	})
+
+	r.leases[l.ID] = l
+	r.counts[id.Key]++
+
+	if r.counts[id.Key] == 1 {
+		r.Server.Available(id)
+	}
+
+	return *l, nil
+}
+
+// Renew extends the lease with the given ID by its TTL.
+func (r *registry) Renew(leaseID string) (lease, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	l, ok := r.leases[leaseID]
+	if !ok {
+		return lease{}, errLeaseNotFound
+	}
+
+	if !l.timer.Stop() {
+		// The timer has already fired, the lease will be removed as soon as
+		// we release the lock.
+		return lease{}, errLeaseNotFound
+	}
+
+	l.ExpiresAt = time.Now().Add(l.TTL)
+	l.timer.Reset(l.TTL)
+
+	return *l, nil
+}
+
+// Revoke removes the lease with the given ID.
+func (r *registry) Revoke(leaseID string) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	l, ok := r.leases[leaseID]
+	if !ok {
+		return errLeaseNotFound
+	}
+
+	l.timer.Stop()
+	r.remove(l)
+
+	return nil
+}
+
+// Leases returns the current leases, sorted by application name.
+func (r *registry) Leases() []lease {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	leases := make([]lease, 0, len(r.leases))
+	for _, l := range r.leases {
+		leases = append(leases, *l)
+	}
+
+	sort.Slice(leases, func(i, j int) bool {
+		if leases[i].Identity.Name != leases[j].Identity.Name {
+			return leases[i].Identity.Name < leases[j].Identity.Name
+		}
+		return leases[i].ID < leases[j].ID
+	})
+
+	return leases
+}
+
+// Close revokes all leases.
+func (r *registry) Close() {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	for _, l := range r.leases {
+		l.timer.Stop()
+		r.remove(l)
+	}
+}
+
+// expire removes l if it is still present in the registry.
+func (r *registry) expire(l *lease) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.leases[l.ID] == l {
+		r.remove(l)
+	}
+}
+
+// remove removes l from the registry, marking its application as unavailable
+// if it was the last lease for that application.
+//
+// r.m must be held.
+func (r *registry) remove(l *lease) {
+	delete(r.leases, l.ID)
+	r.counts[l.Identity.Key]--
+
+	if r.counts[l.Identity.Key] == 0 {
+		delete(r.counts, l.Identity.Key)
+		r.Server.Unavailable(l.Identity)
+	}
+}
+
+// newLeaseID returns a new random lease ID.
+func newLeaseID() (string, error) {
+	var data [16]byte
+	if _, err := rand.Read(data[:]); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(data[:]), nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"time"
+
+	"github.com/dogmatiq/configkit"
+	"github.com/dogmatiq/discoverkit"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("type registry", func() {
+	var (
+		app    configkit.Identity
+		server *discoverkit.Server
+		reg    *registry
+	)
+
+	BeforeEach(func() {
+		app = configkit.MustNewIdentity("<app-name>", "420a8fe8-0c57-44e0-8332-d5c5f93a63fc")
+		server = &discoverkit.Server{}
+		reg = &registry{Server: server}
+		DeferCleanup(reg.Close)
+	})
+
+	Describe("func Grant()", func() {
+		It("makes the application available", func() {
+			l, err := reg.Grant(app, time.Minute)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(l.ID).NotTo(BeEmpty())
+			Expect(l.Identity).To(Equal(app))
+			Expect(l.ExpiresAt).To(BeTemporally("~", time.Now().Add(time.Minute), time.Second))
+
+			Expect(server.Applications()).To(ConsistOf(app))
+		})
+
+		It("makes the application unavailable when the lease expires", func() {
+			_, err := reg.Grant(app, 20*time.Millisecond)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			Eventually(server.Applications).Should(BeEmpty())
+			Expect(reg.Leases()).To(BeEmpty())
+		})
+
+		It("keeps the application available while any lease remains", func() {
+			_, err := reg.Grant(app, 20*time.Millisecond)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			_, err = reg.Grant(app, time.Minute)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			Eventually(reg.Leases).Should(HaveLen(1))
+			Expect(server.Applications()).To(ConsistOf(app))
+		})
+
+		It("returns an error if the identity conflicts with another application", func() {
+			_, err := reg.Grant(app, time.Minute)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			_, err = reg.Grant(
+				configkit.MustNewIdentity("<app-name>", "f2c08525-623e-4c76-851c-3172953269e3"),
+				time.Minute,
+			)
+			Expect(err).To(MatchError(ContainSubstring("conflicts with the existing application")))
+		})
+
+		It("returns an error if the TTL is not positive", func() {
+			_, err := reg.Grant(app, 0)
+			Expect(err).To(MatchError("invalid TTL 0s, must be positive"))
+		})
+	})
+
+	Describe("func Renew()", func() {
+		It("extends the lease", func() {
+			l, err := reg.Grant(app, 50*time.Millisecond)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			for i := 0; i < 5; i++ {
+				time.Sleep(20 * time.Millisecond)
+
+				r, err := reg.Renew(l.ID)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(r.ExpiresAt).To(BeTemporally(">", l.ExpiresAt))
+			}
+
+			Expect(server.Applications()).To(ConsistOf(app))
+		})
+
+		It("returns an error if the lease does not exist", func() {
+			_, err := reg.Renew("<unknown>")
+			Expect(err).To(Equal(errLeaseNotFound))
+		})
+	})
+
+	Describe("func Revoke()", func() {
+		It("makes the application unavailable", func() {
+			l, err := reg.Grant(app, time.Minute)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			err = reg.Revoke(l.ID)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			Expect(server.Applications()).To(BeEmpty())
+		})
+
+		It("returns an error if the lease does not exist", func() {
+			err := reg.Revoke("<unknown>")
+			Expect(err).To(Equal(errLeaseNotFound))
+		})
+	})
+})
@@ -0,0 +1,168 @@
+package discoverkit
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/dogmatiq/configkit"
+)
+
+// Connectivity is the state of the connection to the gRPC target that hosts an
+// application.
+type Connectivity int32
+
+const (
+	// Connected indicates that the discoverer has an open "watch stream" to
+	// the application's target.
+	Connected Connectivity = iota
+
+	// Degraded indicates that the watch stream to the application's target
+	// has failed, but the application is still considered available because
+	// the discoverer's grace period has not yet elapsed.
+	Degraded
+)
+
+// String returns a human-readable representation of the connectivity state.
+func (c Connectivity) String() string {
+	switch c {
+	case Connected:
+		return "connected"
+	case Degraded:
+		return "degraded"
+	default:
+		return "unknown"
+	}
+}
+
+// Connectivity returns the current state of the connection to the target that
+// hosts the application.
+//
+// The state may change at any time during the lifetime of the context passed
+// to the observer. It is always Connected if the discoverer's GracePeriod is
+// not positive.
+func (a Application) Connectivity() Connectivity {
+	if a.state == nil {
+		return Connected
+	}
+
+	return Connectivity(a.state.connectivity.Load())
+}
+
+// applicationState is the state of an application that has been passed to an
+// observer by a call to ApplicationDiscoverer.DiscoverApplications().
+//
+// Its fields, other than connectivity, are protected by the discoverer's
+// mutex.
+type applicationState struct {
+	cancel       context.CancelFunc
+	connectivity atomic.Int32
+	expiry       *time.Timer
+}
+
+// available records that an application is available on a target.
+//
+// It returns true if the application was not already known, in which case the
+// observer must be invoked with the returned context and state.
+func (d *ApplicationDiscoverer) available(
+	ctx context.Context,
+	st *targetState,
+	id configkit.Identity,
+) (context.Context, *applicationState, bool) {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	if s, ok := st.applications[id]; ok {
+		// The application is already known. Either the server has re-announced
+		// it, or it has been announced again after reconnecting to the target
+		// within the grace period.
+		if s.expiry != nil {
+			s.expiry.Stop()
+			s.expiry = nil
+			s.connectivity.Store(int32(Connected))
+		}
+
+		return nil, nil, false
+	}
+
+	appCtx, cancel := context.WithCancel(ctx)
+	s := &applicationState{cancel: cancel}
+	st.applications[id] = s
+
+	return appCtx, s, true
+}
+
+// unavailable records that an application is no longer available on a target
+// and cancels its context.
+func (d *ApplicationDiscoverer) unavailable(st *targetState, id configkit.Identity) {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	if s, ok := st.applications[id]; ok {
+		removeApplication(st, id, s)
+	}
+}
+
+// degrade records that the watch stream to a target has closed.
+//
+// If the discoverer has a grace period, the target's applications are marked
+// as Degraded and their contexts are canceled if they are not announced again
+// before the grace period elapses. Otherwise, their contexts are canceled
+// immediately.
+func (d *ApplicationDiscoverer) degrade(st *targetState) {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	for id, s := range st.applications {
+		if d.GracePeriod <= 0 {
+			removeApplication(st, id, s)
+			continue
+		}
+
+		if s.expiry != nil {
+			// The application is already degraded, don't extend its grace
+			// period.
+			continue
+		}
+
+		s.connectivity.Store(int32(Degraded))
+
+		var timer *time.Timer
+		timer = time.AfterFunc(d.GracePeriod, func() {
+			d.m.Lock()
+			defer d.m.Unlock()
+
+			// Only remove the application if it has not been announced again
+			// since this timer was started.
+			if st.applications[id] == s && s.expiry == timer {
+				removeApplication(st, id, s)
+			}
+		})
+
+		s.expiry = timer
+	}
+}
+
+// removeAll cancels the contexts of all of the applications on a target.
+func (d *ApplicationDiscoverer) removeAll(st *targetState) {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	for id, s := range st.applications {
+		removeApplication(st, id, s)
+	}
+}
+
+// removeApplication removes an application from a target's state and cancels
+// its context.
+//
+// It assumes the discoverer's mutex is locked.
+func removeApplication(st *targetState, id configkit.Identity, s *applicationState) {
+	if s.expiry != nil {
+		s.expiry.Stop()
+		s.expiry = nil
+	}
+
+	delete(st.applications, id)
+	s.cancel()
+}
@@ -0,0 +1,184 @@
+package discoverkit_test
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/dogmatiq/configkit"
+	. "github.com/dogmatiq/discoverkit"
+	"github.com/dogmatiq/interopspec/discoverspec"
+	"github.com/dogmatiq/linger/backoff"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	grpcbackoff "google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+var _ = Describe("type Connectivity", func() {
+	Describe("func String()", func() {
+		It("returns a human-readable representation of the state", func() {
+			Expect(Connected.String()).To(Equal("connected"))
+			Expect(Degraded.String()).To(Equal("degraded"))
+			Expect(Connectivity(-1).String()).To(Equal("unknown"))
+		})
+	})
+})
+
+var _ = Describe("type Application", func() {
+	Describe("func Connectivity()", func() {
+		It("returns Connected if the application was not produced by a discoverer", func() {
+			Expect(Application{}.Connectivity()).To(Equal(Connected))
+		})
+	})
+})
+
+var _ = Describe("type ApplicationDiscoverer (grace period)", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+		app    configkit.Identity
+		server *Server
+		addr   string
+		stop   func()
+	)
+
+	// serve starts a gRPC server that serves server on addr.
+	serve := func() {
+		listener, err := net.Listen("tcp", addr)
+		Expect(err).ShouldNot(HaveOccurred())
+		addr = listener.Addr().String()
+
+		gserver := grpc.NewServer()
+		discoverspec.RegisterDiscoverAPIServer(gserver, server)
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			gserver.Serve(listener)
+		}()
+
+		stop = func() {
+			gserver.Stop()
+			<-done
+		}
+		DeferCleanup(stop)
+	}
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		DeferCleanup(cancel)
+
+		app = configkit.MustNewIdentity("<app-name>", appKey)
+		server = &Server{}
+		server.Available(app)
+
+		addr = "127.0.0.1:"
+		serve()
+	})
+
+	// discover starts discovering applications with the given grace period
+	// and returns a channel that receives each discovered application and its
+	// context.
+	discover := func(grace time.Duration) <-chan discovered {
+		d := &ApplicationDiscoverer{
+			BackoffStrategy: backoff.Constant(10 * time.Millisecond),
+			GracePeriod:     grace,
+		}
+
+		target := Target{
+			Name: addr,
+			DialOptions: []grpc.DialOption{
+				grpc.WithTransportCredentials(insecure.NewCredentials()),
+				grpc.WithConnectParams(grpc.ConnectParams{
+					Backoff: grpcbackoff.Config{
+						BaseDelay:  10 * time.Millisecond,
+						Multiplier: 1,
+						MaxDelay:   10 * time.Millisecond,
+					},
+					MinConnectTimeout: 100 * time.Millisecond,
+				}),
+			},
+		}
+
+		apps := make(chan discovered, 100)
+		done := make(chan struct{})
+
+		go func() {
+			defer close(done)
+			d.DiscoverApplications(
+				ctx,
+				target,
+				func(appCtx context.Context, a Application) {
+					apps <- discovered{appCtx, a}
+				},
+			)
+		}()
+
+		DeferCleanup(func() {
+			cancel()
+			<-done
+		})
+
+		return apps
+	}
+
+	It("retains the application while the target reconnects within the grace period", func() {
+		apps := discover(5 * time.Second)
+
+		var d discovered
+		Eventually(apps).Should(Receive(&d))
+		Expect(d.App.Connectivity()).To(Equal(Connected))
+
+		stop()
+		Eventually(d.App.Connectivity).Should(Equal(Degraded))
+		Expect(d.Ctx.Err()).ShouldNot(HaveOccurred())
+
+		serve()
+		Eventually(d.App.Connectivity).Should(Equal(Connected))
+		Expect(d.Ctx.Err()).ShouldNot(HaveOccurred())
+		Consistently(apps, 50*time.Millisecond).ShouldNot(Receive())
+	})
+
+	It("cancels the application context if the target does not reconnect within the grace period", func() {
+		apps := discover(100 * time.Millisecond)
+
+		var d discovered
+		Eventually(apps).Should(Receive(&d))
+
+		stop()
+		Eventually(d.App.Connectivity).Should(Equal(Degraded))
+		Eventually(d.Ctx.Done()).Should(BeClosed())
+	})
+
+	It("cancels the application context if the target no longer lists the application within the grace period", func() {
+		apps := discover(200 * time.Millisecond)
+
+		var d discovered
+		Eventually(apps).Should(Receive(&d))
+
+		stop()
+		server.Unavailable(app)
+		serve()
+
+		Eventually(d.Ctx.Done()).Should(BeClosed())
+	})
+
+	It("cancels the application context immediately if there is no grace period", func() {
+		apps := discover(0)
+
+		var d discovered
+		Eventually(apps).Should(Receive(&d))
+
+		stop()
+		Eventually(d.Ctx.Done()).Should(BeClosed())
+		Expect(d.App.Connectivity()).To(Equal(Connected))
+	})
+})
+
+// discovered is an application passed to an observer, along with its context.
+type discovered struct {
+	Ctx context.Context
+	App Application
+}
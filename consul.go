@@ -0,0 +1,303 @@
+package discoverkit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dogmatiq/linger"
+	"github.com/dogmatiq/linger/backoff"
+	"google.golang.org/grpc"
+)
+
+const (
+	// DefaultConsulAddress is the default address of the Consul HTTP API.
+	DefaultConsulAddress = "http://127.0.0.1:8500"
+
+	// DefaultConsulWait is the default maximum duration of each blocking query
+	// performed by a ConsulTargetDiscoverer.
+	DefaultConsulWait = 5 * time.Minute
+)
+
+// ConsulTargetDiscoverer discovers gRPC targets by querying the Consul health
+// API for the passing instances of a service.
+//
+// It uses Consul's blocking queries to be notified of changes as they occur.
+type ConsulTargetDiscoverer struct {
+	// Address is the base URL of the Consul HTTP API, such as
+	// "http://127.0.0.1:8500".
+	//
+	// If it is empty, DefaultConsulAddress is used.
+	Address string
+
+	// Service is the name of the Consul service to discover.
+	Service string
+
+	// Tags is an optional set of tags. If it is non-empty, only instances that
+	// have all of these tags are discovered.
+	Tags []string
+
+	// Datacenter is the Consul datacenter to query. If it is empty, the
+	// datacenter of the Consul agent is used.
+	Datacenter string
+
+	// Token is an optional ACL token used to authenticate with Consul.
+	Token string
+
+	// Wait is the maximum duration of each blocking query.
+	//
+	// If it is non-positive, DefaultConsulWait is used.
+	Wait time.Duration
+
+	// HTTPClient is the client used to communicate with Consul.
+	//
+	// If it is nil, http.DefaultClient is used.
+	HTTPClient *http.Client
+
+	// DialOptions returns the dial options used to dial the given address.
+	DialOptions func(addr string) []grpc.DialOption
+
+	// TLS is the TLS configuration used to dial the discovered targets.
+	//
+	// The server certificate is verified against the name of the Consul
+	// service, not the address of the instance. If TLS is nil, only the
+	// options returned by DialOptions are used.
+	TLS *TLSConfig
+
+	// BackoffStrategy is the strategy that determines when to retry a query
+	// that fails.
+	BackoffStrategy backoff.Strategy
+
+	// LogError is an optional function that logs errors that occur while
+	// querying Consul.
+	//
+	// The discoverer retries failed queries, and continues to report the
+	// instances found by the last successful query in the meantime.
+	LogError func(error)
+}
+
+// DiscoverTargets invokes an observer for each gRPC target that is discovered.
+//
+// It runs until ctx is canceled or an error occurs.
+//
+// The context passed to the observer is canceled when the target becomes
+// unavailable or the discover is stopped.
+//
+// The discoverer MAY block on calls to the observer. It is the observer's
+// responsibility to start new goroutines to handle background tasks, as
+// appropriate.
+func (d *ConsulTargetDiscoverer) DiscoverTargets(ctx context.Context, obs TargetObserver) error {
+	if d.Service == "" {
+		return errors.New("consul service name must not be empty")
+	}
+
+	ctr := &backoff.Counter{
+		Strategy: d.BackoffStrategy,
+	}
+
+	set := targetSet{}
+	defer set.cancelAll()
+
+	var index uint64
+
+	for {
+		targets, next, err := d.query(ctx, index)
+		if err != nil {
+			// If the context has been canceled we don't really care what
+			// happens. Bail here before we log it.
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			if d.LogError != nil {
+				d.LogError(err)
+			}
+
+			// Start again from scratch with a non-blocking query after the
+			// backoff delay.
+			index = 0
+
+			if err := linger.Sleep(ctx, ctr.Fail(err)); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		ctr.Reset()
+		set.sync(ctx, targets, obs)
+
+		// The index must be reset if it goes backwards, and must never be zero
+		// once a query has succeeded, otherwise the next query does not block.
+		//
+		// See https://developer.hashicorp.com/consul/api-docs/features/blocking.
+		switch {
+		case next < index:
+			index = 0
+		case next == 0:
+			index = 1
+		default:
+			index = next
+		}
+	}
+}
+
+// consulServiceEntry is an entry in the response to a Consul health API
+// query.
+type consulServiceEntry struct {
+	Node struct {
+		Node    string `json:"Node"`
+		Address string `json:"Address"`
+	} `json:"Node"`
+	Service struct {
+		ID      string   `json:"ID"`
+		Service string   `json:"Service"`
+		Address string   `json:"Address"`
+		Port    int      `json:"Port"`
+		Tags    []string `json:"Tags"`
+	} `json:"Service"`
+}
+
+// query performs a blocking query for the passing instances of the service.
+//
+// It returns the targets keyed by node and service ID, and the index to use
+// for the next query.
+func (d *ConsulTargetDiscoverer) query(
+	ctx context.Context,
+	index uint64,
+) (map[string]Target, uint64, error) {
+	req, err := d.request(ctx, index)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	cli := d.HTTPClient
+	if cli == nil {
+		cli = http.DefaultClient
+	}
+
+	res, err := cli.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("unable to query consul: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+
+		return nil, 0, fmt.Errorf(
+			"unable to query consul: consul API responded with %s: %s",
+			res.Status,
+			strings.TrimSpace(string(body)),
+		)
+	}
+
+	next, err := strconv.ParseUint(res.Header.Get("X-Consul-Index"), 10, 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("unable to query consul: invalid X-Consul-Index header: %w", err)
+	}
+
+	var entries []consulServiceEntry
+	if err := json.NewDecoder(res.Body).Decode(&entries); err != nil {
+		return nil, 0, fmt.Errorf("unable to query consul: %w", err)
+	}
+
+	targets := map[string]Target{}
+
+	for _, e := range entries {
+		if !d.hasTags(e.Service.Tags) {
+			continue
+		}
+
+		host := e.Service.Address
+		if host == "" {
+			host = e.Node.Address
+		}
+
+		t := Target{
+			Name: net.JoinHostPort(host, strconv.Itoa(e.Service.Port)),
+		}
+
+		if d.DialOptions != nil {
+			t.DialOptions = d.DialOptions(t.Name)
+		}
+
+		if d.TLS != nil {
+			t.DialOptions = append(
+				t.DialOptions,
+				d.TLS.DialOptions(d.Service)...,
+			)
+		}
+
+		targets[e.Node.Node+"/"+e.Service.ID] = t
+	}
+
+	return targets, next, nil
+}
+
+// request returns the HTTP request for a blocking query.
+func (d *ConsulTargetDiscoverer) request(ctx context.Context, index uint64) (*http.Request, error) {
+	addr := d.Address
+	if addr == "" {
+		addr = DefaultConsulAddress
+	}
+
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid consul address: %w", err)
+	}
+
+	u = u.JoinPath("v1", "health", "service", d.Service)
+
+	q := url.Values{}
+	q.Set("passing", "true")
+
+	for _, t := range d.Tags {
+		q.Add("tag", t)
+	}
+
+	if d.Datacenter != "" {
+		q.Set("dc", d.Datacenter)
+	}
+
+	if index != 0 {
+		q.Set("index", strconv.FormatUint(index, 10))
+		q.Set("wait", linger.MustCoalesce(d.Wait, DefaultConsulWait).String())
+	}
+
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.Token != "" {
+		req.Header.Set("X-Consul-Token", d.Token)
+	}
+
+	return req, nil
+}
+
+// hasTags returns true if tags contains all of the discoverer's tags.
+//
+// Consul filters instances by tag itself, this check guards against older
+// versions that only honor the first "tag" parameter.
+func (d *ConsulTargetDiscoverer) hasTags(tags []string) bool {
+	for _, t := range d.Tags {
+		if !slices.Contains(tags, t) {
+			return false
+		}
+	}
+
+	return true
+}
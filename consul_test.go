@@ -0,0 +1,498 @@
+package discoverkit_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	. "github.com/dogmatiq/discoverkit"
+	"github.com/dogmatiq/linger/backoff"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("type ConsulTargetDiscoverer", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+		consul *consulServerStub
+		disc   *ConsulTargetDiscoverer
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 2*time.Second)
+		DeferCleanup(cancel)
+
+		consul = &consulServerStub{}
+		consul.Set(
+			consulInstanceStub{
+				Service:     "engine",
+				Node:        "node1",
+				NodeAddress: "10.0.0.1",
+				ID:          "engine-1",
+				Address:     "192.168.0.1",
+				Port:        50555,
+				Tags:        []string{"dogma", "blue"},
+				Passing:     true,
+			},
+			consulInstanceStub{
+				Service:     "engine",
+				Node:        "node2",
+				NodeAddress: "10.0.0.2",
+				ID:          "engine-2",
+				Port:        50556,
+				Tags:        []string{"dogma", "green"},
+				Passing:     true,
+			},
+			consulInstanceStub{
+				Service:     "engine",
+				Node:        "node3",
+				NodeAddress: "10.0.0.3",
+				ID:          "engine-3",
+				Port:        50555,
+				Passing:     false,
+			},
+			consulInstanceStub{
+				Service:     "database",
+				Node:        "node1",
+				NodeAddress: "10.0.0.1",
+				ID:          "database-1",
+				Port:        5432,
+				Passing:     true,
+			},
+		)
+
+		disc = &ConsulTargetDiscoverer{
+			Service: "engine",
+		}
+	})
+
+	JustBeforeEach(func() {
+		consul.Start()
+		disc.Address = consul.URL
+	})
+
+	Describe("func DiscoverTargets()", func() {
+		It("invokes the observer for each passing instance of the service", func() {
+			var actual []Target
+
+			err := disc.DiscoverTargets(
+				ctx,
+				func(
+					_ context.Context,
+					t Target,
+				) {
+					actual = append(actual, t)
+
+					if len(actual) == 2 {
+						cancel()
+					}
+				},
+			)
+
+			Expect(err).To(Equal(context.Canceled))
+			Expect(actual).To(ConsistOf(
+				Target{Name: "192.168.0.1:50555"},
+				Target{Name: "10.0.0.2:50556"}, // uses the node address
+			))
+		})
+
+		It("only discovers instances with all of the tags", func() {
+			disc.Tags = []string{"dogma", "green"}
+
+			var actual []Target
+
+			err := disc.DiscoverTargets(
+				ctx,
+				func(
+					_ context.Context,
+					t Target,
+				) {
+					actual = append(actual, t)
+					cancel()
+				},
+			)
+
+			Expect(err).To(Equal(context.Canceled))
+			Expect(actual).To(ConsistOf(
+				Target{Name: "10.0.0.2:50556"},
+			))
+		})
+
+		It("sends the datacenter and token with each query", func() {
+			disc.Datacenter = "dc2"
+			disc.Token = "<token>"
+
+			err := disc.DiscoverTargets(
+				ctx,
+				func(context.Context, Target) {
+					cancel()
+				},
+			)
+
+			Expect(err).To(Equal(context.Canceled))
+
+			req := consul.Requests()[0]
+			Expect(req.Query.Get("dc")).To(Equal("dc2"))
+			Expect(req.Token).To(Equal("<token>"))
+		})
+
+		It("uses blocking queries after the first query", func() {
+			disc.Wait = 100 * time.Millisecond
+
+			runTargetDiscoverer(ctx, cancel, disc, func(context.Context, Target) {})
+
+			Eventually(func() int {
+				return len(consul.Requests())
+			}).Should(BeNumerically(">=", 3))
+
+			requests := consul.Requests()
+			Expect(requests[0].Query.Has("index")).To(BeFalse())
+			Expect(requests[1].Query.Get("index")).To(Equal("1"))
+			Expect(requests[1].Query.Get("wait")).To(Equal("100ms"))
+		})
+
+		It("invokes the observer when a new instance passes its health checks", func() {
+			targets := make(chan Target, 10)
+
+			runTargetDiscoverer(ctx, cancel, disc, func(_ context.Context, t Target) {
+				targets <- t
+			})
+
+			Eventually(targets).Should(Receive())
+			Eventually(targets).Should(Receive())
+
+			consul.Update("node3", "engine-3", func(i *consulInstanceStub) {
+				i.Passing = true
+			})
+
+			Eventually(targets).Should(Receive(Equal(Target{Name: "10.0.0.3:50555"})))
+		})
+
+		It("cancels the context when an instance becomes critical", func() {
+			contexts := make(chan context.Context, 10)
+
+			runTargetDiscoverer(ctx, cancel, disc, func(c context.Context, _ Target) {
+				contexts <- c
+			})
+
+			var ctx1, ctx2 context.Context
+			Eventually(contexts).Should(Receive(&ctx1))
+			Eventually(contexts).Should(Receive(&ctx2))
+
+			consul.Update("node1", "engine-1", func(i *consulInstanceStub) {
+				i.Passing = false
+			})
+
+			Eventually(ctx1.Done()).Should(BeClosed())
+			Consistently(ctx2.Done(), 50*time.Millisecond).ShouldNot(BeClosed())
+		})
+
+		It("cancels the context when an instance is deregistered", func() {
+			contexts := make(chan context.Context, 10)
+
+			runTargetDiscoverer(ctx, cancel, disc, func(c context.Context, _ Target) {
+				contexts <- c
+			})
+
+			var ctx1, ctx2 context.Context
+			Eventually(contexts).Should(Receive(&ctx1))
+			Eventually(contexts).Should(Receive(&ctx2))
+
+			consul.Deregister("node2", "engine-2")
+
+			Eventually(ctx2.Done()).Should(BeClosed())
+			Consistently(ctx1.Done(), 50*time.Millisecond).ShouldNot(BeClosed())
+		})
+
+		It("replaces the target when an instance's address changes", func() {
+			contexts := make(chan context.Context, 10)
+			targets := make(chan Target, 10)
+
+			runTargetDiscoverer(ctx, cancel, disc, func(c context.Context, t Target) {
+				contexts <- c
+				targets <- t
+			})
+
+			var ctx1 context.Context
+			Eventually(contexts).Should(Receive(&ctx1))
+			Eventually(targets).Should(Receive())
+			Eventually(contexts).Should(Receive())
+			Eventually(targets).Should(Receive())
+
+			consul.Update("node1", "engine-1", func(i *consulInstanceStub) {
+				i.Port = 50557
+			})
+
+			Eventually(targets).Should(Receive(Equal(Target{Name: "192.168.0.1:50557"})))
+			Expect(ctx1.Done()).To(BeClosed())
+		})
+
+		It("logs query errors and retries without canceling existing targets", func() {
+			errs := make(chan error, 10)
+			disc.LogError = func(err error) {
+				errs <- err
+			}
+			disc.BackoffStrategy = backoff.Constant(10 * time.Millisecond)
+
+			contexts := make(chan context.Context, 10)
+			targets := make(chan Target, 10)
+
+			runTargetDiscoverer(ctx, cancel, disc, func(c context.Context, t Target) {
+				contexts <- c
+				targets <- t
+			})
+
+			var ctx1 context.Context
+			Eventually(contexts).Should(Receive(&ctx1))
+			Eventually(contexts).Should(Receive())
+
+			consul.Fail("rpc error: no cluster leader")
+
+			var err error
+			Eventually(errs).Should(Receive(&err))
+			Expect(err).To(MatchError(
+				"unable to query consul: consul API responded with 500 Internal Server Error: rpc error: no cluster leader",
+			))
+			Expect(ctx1.Err()).ShouldNot(HaveOccurred())
+
+			consul.Update("node3", "engine-3", func(i *consulInstanceStub) {
+				i.Passing = true
+			})
+			consul.Fail("")
+
+			Eventually(targets).Should(Receive(Equal(Target{Name: "10.0.0.3:50555"})))
+			Expect(ctx1.Err()).ShouldNot(HaveOccurred())
+		})
+
+		It("uses the TLS configuration to dial the targets", func() {
+			disc.TLS = &TLSConfig{}
+
+			var actual []Target
+
+			err := disc.DiscoverTargets(
+				ctx,
+				func(
+					_ context.Context,
+					t Target,
+				) {
+					actual = append(actual, t)
+
+					if len(actual) == 2 {
+						cancel()
+					}
+				},
+			)
+
+			Expect(err).To(Equal(context.Canceled))
+			Expect(actual).To(HaveEach(
+				HaveField("DialOptions", HaveLen(2)), // credentials and authority
+			))
+		})
+
+		It("returns an error if the service name is empty", func() {
+			disc.Service = ""
+
+			err := disc.DiscoverTargets(ctx, func(context.Context, Target) {
+				Fail("unexpected call")
+			})
+
+			Expect(err).To(MatchError("consul service name must not be empty"))
+		})
+	})
+})
+
+// consulInstanceStub is a service instance registered with a
+// consulServerStub.
+type consulInstanceStub struct {
+	Service     string
+	Node        string
+	NodeAddress string
+	ID          string
+	Address     string
+	Port        int
+	Tags        []string
+	Passing     bool
+}
+
+// consulRequestStub is a request received by a consulServerStub.
+type consulRequestStub struct {
+	Query url.Values
+	Token string
+}
+
+// consulServerStub is a stub implementation of the Consul health API that
+// supports blocking queries.
+type consulServerStub struct {
+	// URL is the base URL of the server, set by Start().
+	URL string
+
+	m         sync.Mutex
+	index     uint64
+	changed   chan struct{}
+	instances []consulInstanceStub
+	failure   string
+	requests  []consulRequestStub
+}
+
+// Start starts the server. It is stopped when the current spec ends.
+func (s *consulServerStub) Start() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/health/service/{service}", s.health)
+
+	server := httptest.NewServer(mux)
+	DeferCleanup(server.Close)
+
+	s.URL = server.URL
+}
+
+// Set replaces all of the registered instances.
+func (s *consulServerStub) Set(instances ...consulInstanceStub) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.instances = instances
+	s.notify()
+}
+
+// Update modifies a registered instance.
+func (s *consulServerStub) Update(node, id string, fn func(*consulInstanceStub)) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	for i := range s.instances {
+		if s.instances[i].Node == node && s.instances[i].ID == id {
+			fn(&s.instances[i])
+		}
+	}
+
+	s.notify()
+}
+
+// Deregister removes a registered instance.
+func (s *consulServerStub) Deregister(node, id string) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.instances = slices.DeleteFunc(s.instances, func(i consulInstanceStub) bool {
+		return i.Node == node && i.ID == id
+	})
+
+	s.notify()
+}
+
+// Fail causes all queries to fail with the given message. If message is empty
+// queries succeed again.
+func (s *consulServerStub) Fail(message string) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.failure = message
+	s.notify()
+}
+
+// Requests returns the requests received by the server.
+func (s *consulServerStub) Requests() []consulRequestStub {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return slices.Clone(s.requests)
+}
+
+// notify increments the index and wakes any blocking queries. s.m must be
+// locked.
+func (s *consulServerStub) notify() {
+	s.index++
+
+	if s.changed != nil {
+		close(s.changed)
+	}
+	s.changed = make(chan struct{})
+}
+
+func (s *consulServerStub) health(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	s.m.Lock()
+	s.requests = append(s.requests, consulRequestStub{
+		Query: q,
+		Token: r.Header.Get("X-Consul-Token"),
+	})
+	index, changed := s.index, s.changed
+	s.m.Unlock()
+
+	if q.Has("index") {
+		min, err := strconv.ParseUint(q.Get("index"), 10, 64)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		wait, err := time.ParseDuration(q.Get("wait"))
+		Expect(err).ShouldNot(HaveOccurred())
+
+		if min >= index {
+			select {
+			case <-changed:
+			case <-time.After(wait):
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.failure != "" {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(s.failure))
+		return
+	}
+
+	entries := []any{}
+
+	for _, i := range s.instances {
+		if i.Service != r.PathValue("service") {
+			continue
+		}
+
+		if q.Get("passing") == "true" && !i.Passing {
+			continue
+		}
+
+		if !hasAllTags(i.Tags, q["tag"]) {
+			continue
+		}
+
+		entries = append(entries, map[string]any{
+			"Node": map[string]any{
+				"Node":    i.Node,
+				"Address": i.NodeAddress,
+			},
+			"Service": map[string]any{
+				"ID":      i.ID,
+				"Service": i.Service,
+				"Address": i.Address,
+				"Port":    i.Port,
+				"Tags":    i.Tags,
+			},
+		})
+	}
+
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(s.index, 10))
+	json.NewEncoder(w).Encode(entries)
+}
+
+// hasAllTags returns true if tags contains all of want.
+func hasAllTags(tags, want []string) bool {
+	for _, t := range want {
+		if !slices.Contains(tags, t) {
+			return false
+		}
+	}
+	return true
+}
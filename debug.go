@@ -0,0 +1,223 @@
+package discoverkit
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dogmatiq/configkit"
+)
+
+// DiscovererDebugHandler is an http.Handler that renders the current state of
+// an ApplicationDiscoverer.
+//
+// The state is rendered as JSON if the request's "format" query parameter is
+// "json" or its Accept header includes "application/json". Otherwise, it is
+// rendered as a simple HTML page.
+type DiscovererDebugHandler struct {
+	// Discoverer is the discoverer to inspect.
+	Discoverer *ApplicationDiscoverer
+}
+
+// ServeHTTP renders the current state of h.Discoverer.
+func (h *DiscovererDebugHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	targets := []debugTarget{}
+
+	for _, s := range h.Discoverer.Targets() {
+		t := debugTarget{
+			Name:         s.Target.Name,
+			Connected:    s.Connected,
+			ConnectedAt:  debugTime(s.ConnectedAt),
+			Failures:     s.Failures,
+			LastErrorAt:  debugTime(s.LastErrorAt),
+			RetryAt:      debugTime(s.RetryAt),
+			CircuitOpen:  s.CircuitOpen,
+			Applications: debugIdentities(s.Applications),
+		}
+
+		if s.LastError != nil {
+			t.LastError = s.LastError.Error()
+		}
+
+		targets = append(targets, t)
+	}
+
+	renderDebug(w, r, discovererDebugTemplate, struct {
+		Targets []debugTarget `json:"targets"`
+	}{targets})
+}
+
+// ServerDebugHandler is an http.Handler that renders the current state of a
+// Server.
+//
+// The state is rendered as JSON if the request's "format" query parameter is
+// "json" or its Accept header includes "application/json". Otherwise, it is
+// rendered as a simple HTML page.
+type ServerDebugHandler struct {
+	// Server is the server to inspect.
+	Server *Server
+}
+
+// ServeHTTP renders the current state of h.Server.
+func (h *ServerDebugHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	watchers := []debugWatcher{}
+
+	for _, s := range h.Server.Watchers() {
+		watchers = append(watchers, debugWatcher{
+			Peer:        s.Peer,
+			ConnectedAt: debugTime(s.ConnectedAt),
+		})
+	}
+
+	renderDebug(w, r, serverDebugTemplate, struct {
+		Applications []debugIdentity `json:"applications"`
+		Watchers     []debugWatcher  `json:"watchers"`
+	}{
+		debugIdentities(h.Server.Applications()),
+		watchers,
+	})
+}
+
+// debugTarget is the representation of a TargetStatus rendered by
+// DiscovererDebugHandler.
+type debugTarget struct {
+	Name         string          `json:"name"`
+	Connected    bool            `json:"connected"`
+	ConnectedAt  *time.Time      `json:"connected_at,omitempty"`
+	Failures     uint            `json:"failures"`
+	LastError    string          `json:"last_error,omitempty"`
+	LastErrorAt  *time.Time      `json:"last_error_at,omitempty"`
+	RetryAt      *time.Time      `json:"retry_at,omitempty"`
+	CircuitOpen  bool            `json:"circuit_open"`
+	Applications []debugIdentity `json:"applications"`
+}
+
+// debugWatcher is the representation of a WatcherStatus rendered by
+// ServerDebugHandler.
+type debugWatcher struct {
+	Peer        string     `json:"peer,omitempty"`
+	ConnectedAt *time.Time `json:"connected_at,omitempty"`
+}
+
+// debugIdentity is the representation of an application identity rendered by
+// the debug handlers.
+type debugIdentity struct {
+	Name string `json:"name"`
+	Key  string `json:"key"`
+}
+
+// debugIdentities converts identities to their debug representation.
+func debugIdentities(ids []configkit.Identity) []debugIdentity {
+	result := make([]debugIdentity, 0, len(ids))
+
+	for _, id := range ids {
+		result = append(result, debugIdentity{id.Name, id.Key})
+	}
+
+	return result
+}
+
+// debugTime returns a pointer to t, or nil if t is the zero-value.
+func debugTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+
+	t = t.UTC()
+	return &t
+}
+
+// renderDebug writes data to w as either JSON or HTML depending on the
+// request.
+func renderDebug(
+	w http.ResponseWriter,
+	r *http.Request,
+	tmpl *template.Template,
+	data any,
+) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	if wantsJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(data)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	tmpl.Execute(w, data)
+}
+
+// wantsJSON returns true if r is requesting a JSON response.
+func wantsJSON(r *http.Request) bool {
+	if f := r.URL.Query().Get("format"); f != "" {
+		return f == "json"
+	}
+
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+var debugFuncs = template.FuncMap{
+	"time": func(t *time.Time) string {
+		if t == nil {
+			return "-"
+		}
+		return t.Format(time.RFC3339)
+	},
+}
+
+var discovererDebugTemplate = template.Must(
+	template.New("discoverer").Funcs(debugFuncs).Parse(`<!DOCTYPE html>
+<html>
+<head><title>Application Discoverer</title></head>
+<body>
+<h1>Targets</h1>
+<table border="1">
+<tr><th>Name</th><th>Connected</th><th>Connected At</th><th>Failures</th><th>Last Error</th><th>Last Error At</th><th>Retry At</th><th>Circuit Open</th><th>Applications</th></tr>
+{{- range .Targets}}
+<tr>
+<td>{{.Name}}</td>
+<td>{{.Connected}}</td>
+<td>{{time .ConnectedAt}}</td>
+<td>{{.Failures}}</td>
+<td>{{.LastError}}</td>
+<td>{{time .LastErrorAt}}</td>
+<td>{{time .RetryAt}}</td>
+<td>{{.CircuitOpen}}</td>
+<td>{{range .Applications}}{{.Name}} ({{.Key}})<br>{{end}}</td>
+</tr>
+{{- end}}
+</table>
+</body>
+</html>
+`),
+)
+
+var serverDebugTemplate = template.Must(
+	template.New("server").Funcs(debugFuncs).Parse(`<!DOCTYPE html>
+<html>
+<head><title>Discovery Server</title></head>
+<body>
+<h1>Applications</h1>
+<table border="1">
+<tr><th>Name</th><th>Key</th></tr>
+{{- range .Applications}}
+<tr><td>{{.Name}}</td><td>{{.Key}}</td></tr>
+{{- end}}
+</table>
+<h1>Watchers</h1>
+<table border="1">
+<tr><th>Peer</th><th>Connected At</th></tr>
+{{- range .Watchers}}
+<tr><td>{{.Peer}}</td><td>{{time .ConnectedAt}}</td></tr>
+{{- end}}
+</table>
+</body>
+</html>
+`),
+)
@@ -0,0 +1,140 @@
+package discoverkit_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"time"
+
+	"github.com/dogmatiq/configkit"
+	. "github.com/dogmatiq/discoverkit"
+	"github.com/dogmatiq/linger/backoff"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("type DiscovererDebugHandler", func() {
+	var (
+		ctx        context.Context
+		discoverer *ApplicationDiscoverer
+		handler    *DiscovererDebugHandler
+	)
+
+	BeforeEach(func() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(context.Background(), 1*time.Second)
+		DeferCleanup(cancel)
+
+		discoverer = &ApplicationDiscoverer{
+			BackoffStrategy: backoff.Constant(10 * time.Second),
+		}
+
+		handler = &DiscovererDebugHandler{
+			Discoverer: discoverer,
+		}
+
+		// Watch a target without any transport credentials, which causes the
+		// dialer to fail.
+		go discoverer.DiscoverApplications(ctx, Target{Name: "<target>"}, nil)
+
+		Eventually(discoverer.Targets).Should(
+			ContainElement(HaveField("Failures", BeEquivalentTo(1))),
+		)
+	})
+
+	Describe("func ServeHTTP()", func() {
+		It("renders the state of each target as JSON", func() {
+			req := httptest.NewRequest("GET", "/?format=json", nil)
+			res := httptest.NewRecorder()
+
+			handler.ServeHTTP(res, req)
+
+			Expect(res.Header().Get("Content-Type")).To(Equal("application/json"))
+
+			var body struct {
+				Targets []map[string]any `json:"targets"`
+			}
+			err := json.Unmarshal(res.Body.Bytes(), &body)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			Expect(body.Targets).To(HaveLen(1))
+			Expect(body.Targets[0]).To(HaveKeyWithValue("name", "<target>"))
+			Expect(body.Targets[0]).To(HaveKeyWithValue("connected", false))
+			Expect(body.Targets[0]).To(HaveKeyWithValue("failures", BeEquivalentTo(1)))
+			Expect(body.Targets[0]).To(HaveKeyWithValue("last_error", ContainSubstring("unable to dial target")))
+			Expect(body.Targets[0]).To(HaveKey("retry_at"))
+			Expect(body.Targets[0]).To(HaveKeyWithValue("circuit_open", false))
+			Expect(body.Targets[0]).NotTo(HaveKey("connected_at"))
+		})
+
+		It("renders JSON if the client accepts it", func() {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("Accept", "application/json")
+			res := httptest.NewRecorder()
+
+			handler.ServeHTTP(res, req)
+
+			Expect(res.Header().Get("Content-Type")).To(Equal("application/json"))
+		})
+
+		It("renders the state of each target as HTML by default", func() {
+			req := httptest.NewRequest("GET", "/", nil)
+			res := httptest.NewRecorder()
+
+			handler.ServeHTTP(res, req)
+
+			Expect(res.Header().Get("Content-Type")).To(HavePrefix("text/html"))
+			Expect(res.Body.String()).To(ContainSubstring("&lt;target&gt;"))
+			Expect(res.Body.String()).To(ContainSubstring("unable to dial target"))
+		})
+	})
+})
+
+var _ = Describe("type ServerDebugHandler", func() {
+	var (
+		app1, app2 configkit.Identity
+		handler    *ServerDebugHandler
+	)
+
+	BeforeEach(func() {
+		app1 = configkit.MustNewIdentity("<app-1-name>", "a2b30343-b86c-485c-94e0-de84dda069a7")
+		app2 = configkit.MustNewIdentity("<app-2-name>", "e7f11e2c-791f-4083-8c71-6aa966fc3db1")
+
+		server := &Server{}
+		server.Available(app1)
+		server.Available(app2)
+
+		handler = &ServerDebugHandler{
+			Server: server,
+		}
+	})
+
+	Describe("func ServeHTTP()", func() {
+		It("renders the available applications as JSON", func() {
+			req := httptest.NewRequest("GET", "/?format=json", nil)
+			res := httptest.NewRecorder()
+
+			handler.ServeHTTP(res, req)
+
+			Expect(res.Header().Get("Content-Type")).To(Equal("application/json"))
+			Expect(res.Body.String()).To(MatchJSON(`{
+				"applications": [
+					{"name": "<app-1-name>", "key": "a2b30343-b86c-485c-94e0-de84dda069a7"},
+					{"name": "<app-2-name>", "key": "e7f11e2c-791f-4083-8c71-6aa966fc3db1"}
+				],
+				"watchers": []
+			}`))
+		})
+
+		It("renders the available applications as HTML by default", func() {
+			req := httptest.NewRequest("GET", "/", nil)
+			res := httptest.NewRecorder()
+
+			handler.ServeHTTP(res, req)
+
+			Expect(res.Header().Get("Content-Type")).To(HavePrefix("text/html"))
+			Expect(res.Body.String()).To(ContainSubstring("&lt;app-1-name&gt;"))
+			Expect(res.Body.String()).To(ContainSubstring("e7f11e2c-791f-4083-8c71-6aa966fc3db1"))
+		})
+	})
+})
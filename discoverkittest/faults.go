@@ -0,0 +1,162 @@
+package discoverkittest
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// DefaultFaultDelay is the default duration of a FaultDelay.
+const DefaultFaultDelay = 100 * time.Millisecond
+
+// Fault is a type of fault that is injected into a discovery event, such as a
+// response sent by a DiscoverAPI server or a target reported by a
+// TargetDiscoverer.
+type Fault int
+
+const (
+	// FaultNone indicates that no fault is injected; the event is passed
+	// through unchanged.
+	FaultNone Fault = iota
+
+	// FaultDrop discards the event.
+	FaultDrop
+
+	// FaultDelay delays the event.
+	FaultDelay
+
+	// FaultDuplicate repeats the event.
+	FaultDuplicate
+
+	// FaultCorrupt replaces the event with an invalid one. Responses are sent
+	// with an invalid application identity, and targets are reported with a
+	// name in the reserved ".invalid" domain.
+	FaultCorrupt
+
+	// FaultReorder holds the event back until after the next event.
+	FaultReorder
+
+	// FaultReset interrupts the source of the event. A DiscoverAPI stream
+	// fails with an "unavailable" error, and a target becomes unavailable
+	// immediately after it is reported, then is reported again.
+	FaultReset
+)
+
+// faults is the list of faults, in the order they are considered when choosing
+// a fault by probability.
+var faults = []Fault{
+	FaultDrop,
+	FaultDelay,
+	FaultDuplicate,
+	FaultCorrupt,
+	FaultReorder,
+	FaultReset,
+}
+
+func (f Fault) String() string {
+	switch f {
+	case FaultNone:
+		return "none"
+	case FaultDrop:
+		return "drop"
+	case FaultDelay:
+		return "delay"
+	case FaultDuplicate:
+		return "duplicate"
+	case FaultCorrupt:
+		return "corrupt"
+	case FaultReorder:
+		return "reorder"
+	case FaultReset:
+		return "reset"
+	default:
+		return fmt.Sprintf("Fault(%d)", int(f))
+	}
+}
+
+// FaultInjector chooses the fault to inject into each discovery event.
+//
+// Faults are first taken from the Script, in order. Once the script is
+// exhausted each fault is chosen at random according to its Probability.
+// Random choices are deterministic for a given Seed, so the same sequence of
+// events always receives the same faults.
+//
+// It is safe for concurrent use, however events that occur concurrently may
+// receive their faults in any order. It must not be copied after first use.
+type FaultInjector struct {
+	// Seed is the seed used to choose faults at random.
+	Seed uint64
+
+	// Script is a sequence of faults injected into the first events, in order.
+	Script []Fault
+
+	// Probability is the probability of injecting each fault into an event
+	// once the script is exhausted, from 0 to 1.
+	//
+	// The sum of the probabilities should not exceed 1. No fault is injected
+	// with the remaining probability.
+	Probability map[Fault]float64
+
+	// Delay is the duration of a FaultDelay.
+	//
+	// If it is non-positive, DefaultFaultDelay is used.
+	Delay time.Duration
+
+	m        sync.Mutex
+	rand     *rand.Rand
+	injected []Fault
+}
+
+// Next returns the fault to inject into the next event.
+func (f *FaultInjector) Next() Fault {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	fault := f.choose()
+	f.injected = append(f.injected, fault)
+
+	return fault
+}
+
+// Injected returns the fault chosen for each event so far, including
+// FaultNone for events into which no fault was injected.
+func (f *FaultInjector) Injected() []Fault {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	return append([]Fault(nil), f.injected...)
+}
+
+// choose returns the fault for the next event. f.m must be locked.
+func (f *FaultInjector) choose() Fault {
+	if n := len(f.injected); n < len(f.Script) {
+		return f.Script[n]
+	}
+
+	if f.rand == nil {
+		f.rand = rand.New(rand.NewPCG(f.Seed, 0))
+	}
+
+	// Always consume a random number, regardless of the probabilities, so
+	// that the sequence of choices depends only on the seed.
+	r := f.rand.Float64()
+
+	for _, fault := range faults {
+		p := f.Probability[fault]
+		if r < p {
+			return fault
+		}
+		r -= p
+	}
+
+	return FaultNone
+}
+
+// delay returns the duration of a FaultDelay.
+func (f *FaultInjector) delay() time.Duration {
+	if f.Delay > 0 {
+		return f.Delay
+	}
+	return DefaultFaultDelay
+}
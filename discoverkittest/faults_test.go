@@ -0,0 +1,89 @@
+package discoverkittest_test
+
+import (
+	. "github.com/dogmatiq/discoverkit/discoverkittest"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("type FaultInjector", func() {
+	Describe("func Next()", func() {
+		It("returns the scripted faults in order", func() {
+			f := &FaultInjector{
+				Script: []Fault{FaultDrop, FaultReorder, FaultDrop},
+			}
+
+			Expect(f.Next()).To(Equal(FaultDrop))
+			Expect(f.Next()).To(Equal(FaultReorder))
+			Expect(f.Next()).To(Equal(FaultDrop))
+			Expect(f.Next()).To(Equal(FaultNone))
+		})
+
+		It("chooses faults according to their probability", func() {
+			f := &FaultInjector{
+				Probability: map[Fault]float64{
+					FaultDuplicate: 1,
+				},
+			}
+
+			for i := 0; i < 10; i++ {
+				Expect(f.Next()).To(Equal(FaultDuplicate))
+			}
+		})
+
+		It("chooses the same faults given the same seed", func() {
+			probability := map[Fault]float64{
+				FaultDrop:      0.2,
+				FaultDelay:     0.2,
+				FaultDuplicate: 0.2,
+				FaultReset:     0.2,
+			}
+
+			f1 := &FaultInjector{Seed: 123, Probability: probability}
+			f2 := &FaultInjector{Seed: 123, Probability: probability}
+			f3 := &FaultInjector{Seed: 456, Probability: probability}
+
+			var s1, s2, s3 []Fault
+			for i := 0; i < 100; i++ {
+				s1 = append(s1, f1.Next())
+				s2 = append(s2, f2.Next())
+				s3 = append(s3, f3.Next())
+			}
+
+			Expect(s1).To(Equal(s2))
+			Expect(s1).NotTo(Equal(s3))
+			Expect(s1).To(ContainElements(FaultNone, FaultDrop, FaultDelay, FaultDuplicate, FaultReset))
+			Expect(s1).NotTo(ContainElements(FaultCorrupt, FaultReorder))
+		})
+	})
+
+	Describe("func Injected()", func() {
+		It("returns the faults chosen so far", func() {
+			f := &FaultInjector{
+				Script: []Fault{FaultDrop},
+			}
+
+			f.Next()
+			f.Next()
+
+			Expect(f.Injected()).To(Equal([]Fault{FaultDrop, FaultNone}))
+		})
+	})
+})
+
+var _ = Describe("type Fault", func() {
+	DescribeTable(
+		"func String()",
+		func(f Fault, expect string) {
+			Expect(f.String()).To(Equal(expect))
+		},
+		Entry("none", FaultNone, "none"),
+		Entry("drop", FaultDrop, "drop"),
+		Entry("delay", FaultDelay, "delay"),
+		Entry("duplicate", FaultDuplicate, "duplicate"),
+		Entry("corrupt", FaultCorrupt, "corrupt"),
+		Entry("reorder", FaultReorder, "reorder"),
+		Entry("reset", FaultReset, "reset"),
+		Entry("unknown", Fault(100), "Fault(100)"),
+	)
+})
@@ -0,0 +1,125 @@
+package discoverkittest
+
+import (
+	"sync"
+
+	"github.com/dogmatiq/discoverkit"
+	"github.com/dogmatiq/interopspec/discoverspec"
+	"github.com/dogmatiq/linger"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// FaultyServer is a discoverspec.DiscoverAPIServer that injects faults into
+// the responses sent by another server.
+//
+// Each response sent on a WatchApplications() stream is a separate event for
+// the purposes of fault injection.
+type FaultyServer struct {
+	// Server is the server that sends the responses.
+	Server discoverspec.DiscoverAPIServer
+
+	// Faults chooses the fault to inject into each response.
+	Faults *FaultInjector
+}
+
+var _ discoverspec.DiscoverAPIServer = (*FaultyServer)(nil)
+
+// WatchApplications starts watching the server for updates to the availability
+// of Dogma applications.
+func (s *FaultyServer) WatchApplications(
+	req *discoverspec.WatchApplicationsRequest,
+	stream discoverspec.DiscoverAPI_WatchApplicationsServer,
+) error {
+	return s.Server.WatchApplications(
+		req,
+		&faultyStream{
+			DiscoverAPI_WatchApplicationsServer: stream,
+			faults:                              s.Faults,
+		},
+	)
+}
+
+// NewFaultyServer starts a new in-process server that injects faults into the
+// responses sent by a new discoverkit.Server.
+//
+// The server is stopped by calling Close().
+func NewFaultyServer(faults *FaultInjector, options ...grpc.ServerOption) *Server {
+	s := &discoverkit.Server{}
+
+	return serve(
+		s,
+		&FaultyServer{
+			Server: s,
+			Faults: faults,
+		},
+		options,
+	)
+}
+
+// faultyStream is a WatchApplications() stream that injects faults into the
+// responses that are sent.
+type faultyStream struct {
+	discoverspec.DiscoverAPI_WatchApplicationsServer
+
+	faults *FaultInjector
+
+	m    sync.Mutex
+	held *discoverspec.WatchApplicationsResponse
+}
+
+// Send sends res to the client, subject to fault injection.
+func (s *faultyStream) Send(res *discoverspec.WatchApplicationsResponse) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	switch s.faults.Next() {
+	case FaultDrop:
+		return nil
+
+	case FaultDelay:
+		if err := linger.Sleep(s.Context(), s.faults.delay()); err != nil {
+			return err
+		}
+
+	case FaultDuplicate:
+		if err := s.send(res); err != nil {
+			return err
+		}
+
+	case FaultCorrupt:
+		res = &discoverspec.WatchApplicationsResponse{
+			Identity: &discoverspec.Identity{
+				Name: res.GetIdentity().GetName(),
+			},
+			Available: res.GetAvailable(),
+		}
+
+	case FaultReorder:
+		if s.held == nil {
+			s.held = res
+			return nil
+		}
+
+	case FaultReset:
+		return status.Error(codes.Unavailable, "injected fault: stream reset")
+	}
+
+	return s.send(res)
+}
+
+// send sends res to the client, followed by any response that is being held
+// back. s.m must be locked.
+func (s *faultyStream) send(res *discoverspec.WatchApplicationsResponse) error {
+	if err := s.DiscoverAPI_WatchApplicationsServer.Send(res); err != nil {
+		return err
+	}
+
+	if held := s.held; held != nil {
+		s.held = nil
+		return s.DiscoverAPI_WatchApplicationsServer.Send(held)
+	}
+
+	return nil
+}
@@ -0,0 +1,161 @@
+package discoverkittest_test
+
+import (
+	"context"
+	"time"
+
+	"github.com/dogmatiq/configkit"
+	"github.com/dogmatiq/discoverkit"
+	. "github.com/dogmatiq/discoverkit/discoverkittest"
+	"github.com/dogmatiq/linger/backoff"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("type FaultyServer", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+
+		app1, app2 configkit.Identity
+		faults     *FaultInjector
+		server     *Server
+		rec        *ApplicationRecorder
+		errors     chan error
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 2*time.Second)
+		DeferCleanup(cancel)
+
+		app1 = configkit.MustNewIdentity("<app-1-name>", "a2b30343-b86c-485c-94e0-de84dda069a7")
+		app2 = configkit.MustNewIdentity("<app-2-name>", "e7f11e2c-791f-4083-8c71-6aa966fc3db1")
+
+		faults = &FaultInjector{
+			Delay: 200 * time.Millisecond,
+		}
+
+		server = NewFaultyServer(faults)
+		DeferCleanup(server.Close)
+
+		rec = &ApplicationRecorder{}
+		errors = make(chan error, 10)
+	})
+
+	// discover runs an application discoverer against the server in the
+	// background until the current spec ends, and waits for it to start
+	// watching.
+	discover := func() {
+		ctx, cancel, server, rec, errors := ctx, cancel, server, rec, errors
+		done := make(chan struct{})
+
+		disc := &discoverkit.ApplicationDiscoverer{
+			BackoffStrategy: backoff.Constant(10 * time.Millisecond),
+			LogError: func(_ discoverkit.Target, err error) {
+				errors <- err
+			},
+		}
+
+		go func() {
+			defer close(done)
+			disc.DiscoverApplications(ctx, server.Target, rec.Observe)
+		}()
+
+		DeferCleanup(func() {
+			cancel()
+			<-done
+		})
+
+		Eventually(server.Server.Watchers).Should(HaveLen(1))
+	}
+
+	// available makes app available and waits for the response to be sent.
+	available := func(app configkit.Identity) {
+		n := len(faults.Injected())
+		server.Available(app)
+		Eventually(faults.Injected).Should(HaveLen(n + 1))
+	}
+
+	It("passes responses through when no fault is injected", func() {
+		discover()
+		available(app1)
+
+		Eventually(rec).Should(HaveApplication(app1))
+	})
+
+	It("drops responses", func() {
+		faults.Script = []Fault{FaultDrop}
+
+		discover()
+		available(app1)
+		available(app2)
+
+		Eventually(rec).Should(HaveApplication(app2))
+		Expect(rec).NotTo(HaveApplication(app1))
+	})
+
+	It("delays responses", func() {
+		faults.Script = []Fault{FaultDelay}
+
+		discover()
+
+		start := time.Now()
+		server.Available(app1)
+
+		Eventually(rec).Should(HaveApplication(app1))
+		Expect(time.Since(start)).To(BeNumerically(">=", faults.Delay))
+	})
+
+	It("duplicates responses", func() {
+		faults.Script = []Fault{FaultDuplicate}
+
+		discover()
+		available(app1)
+
+		Eventually(rec).Should(HaveApplication(app1))
+		Consistently(rec.Applications, 50*time.Millisecond).Should(HaveLen(1))
+		Expect(errors).NotTo(Receive())
+	})
+
+	It("corrupts responses", func() {
+		faults.Script = []Fault{FaultCorrupt}
+
+		discover()
+		available(app1)
+
+		var err error
+		Eventually(errors).Should(Receive(&err))
+		Expect(err).To(MatchError(ContainSubstring("invalid application identity")))
+		Expect(rec).NotTo(HaveApplication(app1))
+	})
+
+	It("reorders responses", func() {
+		faults.Script = []Fault{FaultReorder}
+
+		discover()
+		available(app1)
+
+		Consistently(rec, 50*time.Millisecond).ShouldNot(HaveApplication(app1))
+
+		available(app2)
+
+		Eventually(rec.Applications).Should(HaveLen(2))
+
+		apps := rec.Applications()
+		Expect(apps[0].Identity).To(Equal(app2))
+		Expect(apps[1].Identity).To(Equal(app1))
+	})
+
+	It("resets the stream", func() {
+		faults.Script = []Fault{FaultReset}
+
+		discover()
+		server.Available(app1)
+
+		var err error
+		Eventually(errors).Should(Receive(&err))
+		Expect(err).To(MatchError(ContainSubstring("injected fault: stream reset")))
+
+		Eventually(rec).Should(HaveApplication(app1))
+	})
+})
@@ -0,0 +1,105 @@
+package discoverkittest
+
+import (
+	"context"
+	"sync"
+
+	"github.com/dogmatiq/discoverkit"
+	"github.com/dogmatiq/linger"
+)
+
+// FaultyTargetDiscoverer is a discoverkit.TargetDiscoverer that injects faults
+// into the targets reported by another discoverer.
+//
+// Each target reported to the observer is a separate event for the purposes
+// of fault injection.
+type FaultyTargetDiscoverer struct {
+	// Discoverer is the discoverer that reports the targets.
+	Discoverer discoverkit.TargetDiscoverer
+
+	// Faults chooses the fault to inject into each target.
+	Faults *FaultInjector
+}
+
+var _ discoverkit.TargetDiscoverer = (*FaultyTargetDiscoverer)(nil)
+
+// DiscoverTargets invokes an observer for each gRPC target that is discovered.
+//
+// It runs until ctx is canceled or an error occurs.
+//
+// The context passed to the observer is canceled when the target becomes
+// unavailable or the discover is stopped.
+//
+// The discoverer MAY block on calls to the observer. It is the observer's
+// responsibility to start new goroutines to handle background tasks, as
+// appropriate.
+func (d *FaultyTargetDiscoverer) DiscoverTargets(
+	ctx context.Context,
+	obs discoverkit.TargetObserver,
+) error {
+	var (
+		m    sync.Mutex
+		held *heldTarget
+	)
+
+	// observe invokes the observer, followed by the observer for any target
+	// that is being held back.
+	observe := func(ctx context.Context, t discoverkit.Target) {
+		obs(ctx, t)
+
+		m.Lock()
+		h := held
+		held = nil
+		m.Unlock()
+
+		if h != nil && h.ctx.Err() == nil {
+			obs(h.ctx, h.target)
+		}
+	}
+
+	return d.Discoverer.DiscoverTargets(
+		ctx,
+		func(ctx context.Context, t discoverkit.Target) {
+			switch d.Faults.Next() {
+			case FaultDrop:
+				return
+
+			case FaultDelay:
+				if err := linger.Sleep(ctx, d.Faults.delay()); err != nil {
+					return
+				}
+
+			case FaultDuplicate:
+				obs(ctx, t)
+
+			case FaultCorrupt:
+				t = discoverkit.Target{
+					Name:        "corrupt.invalid",
+					DialOptions: t.DialOptions,
+				}
+
+			case FaultReorder:
+				m.Lock()
+				if held == nil {
+					held = &heldTarget{ctx, t}
+					m.Unlock()
+					return
+				}
+				m.Unlock()
+
+			case FaultReset:
+				flapCtx, cancel := context.WithCancel(ctx)
+				obs(flapCtx, t)
+				cancel()
+			}
+
+			observe(ctx, t)
+		},
+	)
+}
+
+// heldTarget is a target that is held back by a FaultReorder.
+type heldTarget struct {
+	ctx    context.Context
+	target discoverkit.Target
+}
@@ -0,0 +1,178 @@
+package discoverkittest_test
+
+import (
+	"context"
+	"time"
+
+	"github.com/dogmatiq/discoverkit"
+	. "github.com/dogmatiq/discoverkit/discoverkittest"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("type FaultyTargetDiscoverer", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+
+		faults   *FaultInjector
+		upstream *discoverkit.MemoryTargetDiscoverer
+		disc     *FaultyTargetDiscoverer
+		observed chan observedTarget
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 2*time.Second)
+		DeferCleanup(cancel)
+
+		faults = &FaultInjector{
+			Delay: 200 * time.Millisecond,
+		}
+
+		upstream = &discoverkit.MemoryTargetDiscoverer{}
+
+		disc = &FaultyTargetDiscoverer{
+			Discoverer: upstream,
+			Faults:     faults,
+		}
+
+		observed = make(chan observedTarget, 10)
+	})
+
+	// discover runs the discoverer in the background until the current spec
+	// ends.
+	discover := func() {
+		ctx, cancel, disc, observed := ctx, cancel, disc, observed
+		done := make(chan struct{})
+
+		go func() {
+			defer close(done)
+			disc.DiscoverTargets(ctx, func(ctx context.Context, t discoverkit.Target) {
+				observed <- observedTarget{ctx, t}
+			})
+		}()
+
+		DeferCleanup(func() {
+			cancel()
+			<-done
+		})
+	}
+
+	// add adds a target to the upstream discoverer and waits for the fault
+	// injector to be consulted.
+	add := func(name string) {
+		n := len(faults.Injected())
+		upstream.Add(discoverkit.Target{Name: name})
+		Eventually(faults.Injected).Should(HaveLen(n + 1))
+	}
+
+	It("passes targets through when no fault is injected", func() {
+		discover()
+		add("<target>")
+
+		var o observedTarget
+		Eventually(observed).Should(Receive(&o))
+		Expect(o.target.Name).To(Equal("<target>"))
+	})
+
+	It("drops targets", func() {
+		faults.Script = []Fault{FaultDrop}
+
+		discover()
+		add("<target-1>")
+		add("<target-2>")
+
+		var o observedTarget
+		Eventually(observed).Should(Receive(&o))
+		Expect(o.target.Name).To(Equal("<target-2>"))
+		Consistently(observed, 50*time.Millisecond).ShouldNot(Receive())
+	})
+
+	It("delays targets", func() {
+		faults.Script = []Fault{FaultDelay}
+
+		discover()
+
+		start := time.Now()
+		upstream.Add(discoverkit.Target{Name: "<target>"})
+
+		Eventually(observed).Should(Receive())
+		Expect(time.Since(start)).To(BeNumerically(">=", faults.Delay))
+	})
+
+	It("duplicates targets", func() {
+		faults.Script = []Fault{FaultDuplicate}
+
+		discover()
+		add("<target>")
+
+		var o1, o2 observedTarget
+		Eventually(observed).Should(Receive(&o1))
+		Eventually(observed).Should(Receive(&o2))
+		Expect(o1.target.Name).To(Equal("<target>"))
+		Expect(o2.target.Name).To(Equal("<target>"))
+	})
+
+	It("corrupts targets", func() {
+		faults.Script = []Fault{FaultCorrupt}
+
+		discover()
+		add("<target>")
+
+		var o observedTarget
+		Eventually(observed).Should(Receive(&o))
+		Expect(o.target.Name).To(Equal("corrupt.invalid"))
+	})
+
+	It("reorders targets", func() {
+		faults.Script = []Fault{FaultReorder}
+
+		discover()
+		add("<target-1>")
+
+		Consistently(observed, 50*time.Millisecond).ShouldNot(Receive())
+
+		add("<target-2>")
+
+		var o1, o2 observedTarget
+		Eventually(observed).Should(Receive(&o1))
+		Eventually(observed).Should(Receive(&o2))
+		Expect(o1.target.Name).To(Equal("<target-2>"))
+		Expect(o2.target.Name).To(Equal("<target-1>"))
+	})
+
+	It("does not deliver a reordered target that has become unavailable", func() {
+		faults.Script = []Fault{FaultReorder}
+
+		discover()
+		add("<target-1>")
+		upstream.Remove("<target-1>")
+		add("<target-2>")
+
+		var o observedTarget
+		Eventually(observed).Should(Receive(&o))
+		Expect(o.target.Name).To(Equal("<target-2>"))
+		Consistently(observed, 50*time.Millisecond).ShouldNot(Receive())
+	})
+
+	It("resets targets", func() {
+		faults.Script = []Fault{FaultReset}
+
+		discover()
+		add("<target>")
+
+		var o1, o2 observedTarget
+		Eventually(observed).Should(Receive(&o1))
+		Eventually(observed).Should(Receive(&o2))
+		Expect(o1.target.Name).To(Equal("<target>"))
+		Expect(o2.target.Name).To(Equal("<target>"))
+		Expect(o1.ctx.Err()).To(HaveOccurred())
+		Expect(o2.ctx.Err()).ShouldNot(HaveOccurred())
+	})
+})
+
+// observedTarget is a target that was passed to an observer.
+type observedTarget struct {
+	ctx    context.Context
+	target discoverkit.Target
+}
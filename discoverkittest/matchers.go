@@ -0,0 +1,127 @@
+package discoverkittest
+
+import (
+	"fmt"
+
+	"github.com/dogmatiq/configkit"
+	"github.com/dogmatiq/discoverkit"
+	"github.com/onsi/gomega/gcustom"
+	"github.com/onsi/gomega/types"
+)
+
+// HaveApplication returns a Gomega matcher that succeeds if an application
+// with the given identity is available on any target.
+//
+// The actual value must be an *ApplicationRecorder or a slice of
+// discoverkit.Application. When used with an *ApplicationRecorder it may be
+// used with Eventually() and Consistently(), for example:
+//
+//	Eventually(rec).Should(HaveApplication(id))
+func HaveApplication(id configkit.Identity) types.GomegaMatcher {
+	return gcustom.MakeMatcher(
+		func(actual any) (bool, error) {
+			apps, err := applicationsOf(actual)
+			if err != nil {
+				return false, err
+			}
+
+			for _, a := range apps {
+				if a.Identity == id {
+					return true, nil
+				}
+			}
+
+			return false, nil
+		},
+		fmt.Sprintf("to have application %s", id),
+	)
+}
+
+// HaveApplicationOnTarget returns a Gomega matcher that succeeds if an
+// application with the given identity is available on the target with the
+// given name.
+//
+// The actual value must be an *ApplicationRecorder or a slice of
+// discoverkit.Application. When used with an *ApplicationRecorder it may be
+// used with Eventually() and Consistently(), for example:
+//
+//	Eventually(rec).Should(HaveApplicationOnTarget(id, srv.Target.Name))
+func HaveApplicationOnTarget(id configkit.Identity, target string) types.GomegaMatcher {
+	return gcustom.MakeMatcher(
+		func(actual any) (bool, error) {
+			apps, err := applicationsOf(actual)
+			if err != nil {
+				return false, err
+			}
+
+			for _, a := range apps {
+				if a.Identity == id && a.Target.Name == target {
+					return true, nil
+				}
+			}
+
+			return false, nil
+		},
+		fmt.Sprintf("to have application %s on target %s", id, target),
+	)
+}
+
+// HaveTarget returns a Gomega matcher that succeeds if a target with the given
+// name is available.
+//
+// The actual value must be a *TargetRecorder or a slice of discoverkit.Target.
+// When used with a *TargetRecorder it may be used with Eventually() and
+// Consistently(), for example:
+//
+//	Eventually(rec).Should(HaveTarget("example.org:50555"))
+func HaveTarget(name string) types.GomegaMatcher {
+	return gcustom.MakeMatcher(
+		func(actual any) (bool, error) {
+			targets, err := targetsOf(actual)
+			if err != nil {
+				return false, err
+			}
+
+			for _, t := range targets {
+				if t.Name == name {
+					return true, nil
+				}
+			}
+
+			return false, nil
+		},
+		fmt.Sprintf("to have target %s", name),
+	)
+}
+
+// applicationsOf returns the applications represented by the actual value
+// passed to a matcher.
+func applicationsOf(actual any) ([]discoverkit.Application, error) {
+	switch v := actual.(type) {
+	case *ApplicationRecorder:
+		return v.Applications(), nil
+	case []discoverkit.Application:
+		return v, nil
+	default:
+		return nil, fmt.Errorf(
+			"expected an *ApplicationRecorder or []discoverkit.Application, got %T",
+			actual,
+		)
+	}
+}
+
+// targetsOf returns the targets represented by the actual value passed to a
+// matcher.
+func targetsOf(actual any) ([]discoverkit.Target, error) {
+	switch v := actual.(type) {
+	case *TargetRecorder:
+		return v.Targets(), nil
+	case []discoverkit.Target:
+		return v, nil
+	default:
+		return nil, fmt.Errorf(
+			"expected a *TargetRecorder or []discoverkit.Target, got %T",
+			actual,
+		)
+	}
+}
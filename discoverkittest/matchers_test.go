@@ -0,0 +1,96 @@
+package discoverkittest_test
+
+import (
+	"context"
+
+	"github.com/dogmatiq/configkit"
+	"github.com/dogmatiq/discoverkit"
+	. "github.com/dogmatiq/discoverkit/discoverkittest"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("func HaveApplication()", func() {
+	app := configkit.MustNewIdentity("<app-name>", "a2b30343-b86c-485c-94e0-de84dda069a7")
+
+	It("matches a slice containing the application", func() {
+		Expect([]discoverkit.Application{
+			{Identity: app, Target: discoverkit.Target{Name: "<target>"}},
+		}).To(HaveApplication(app))
+	})
+
+	It("does not match a slice that does not contain the application", func() {
+		Expect([]discoverkit.Application{}).NotTo(HaveApplication(app))
+	})
+
+	It("describes the application in the failure message", func() {
+		m := HaveApplication(app)
+
+		ok, err := m.Match([]discoverkit.Application{})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(ok).To(BeFalse())
+		Expect(m.FailureMessage([]discoverkit.Application{})).To(
+			ContainSubstring("to have application <app-name>/a2b30343-b86c-485c-94e0-de84dda069a7"),
+		)
+	})
+
+	It("returns an error if the actual value is not supported", func() {
+		_, err := HaveApplication(app).Match(123)
+		Expect(err).To(MatchError("expected an *ApplicationRecorder or []discoverkit.Application, got int"))
+	})
+})
+
+var _ = Describe("func HaveApplicationOnTarget()", func() {
+	app := configkit.MustNewIdentity("<app-name>", "a2b30343-b86c-485c-94e0-de84dda069a7")
+
+	apps := []discoverkit.Application{
+		{Identity: app, Target: discoverkit.Target{Name: "<target>"}},
+	}
+
+	It("matches a slice containing the application on the target", func() {
+		Expect(apps).To(HaveApplicationOnTarget(app, "<target>"))
+	})
+
+	It("does not match a slice containing the application on another target", func() {
+		Expect(apps).NotTo(HaveApplicationOnTarget(app, "<other>"))
+	})
+})
+
+var _ = Describe("func HaveTarget()", func() {
+	It("matches a slice containing the target", func() {
+		Expect([]discoverkit.Target{{Name: "<target>"}}).To(HaveTarget("<target>"))
+	})
+
+	It("does not match a slice that does not contain the target", func() {
+		Expect([]discoverkit.Target{{Name: "<other>"}}).NotTo(HaveTarget("<target>"))
+	})
+
+	It("matches a TargetRecorder", func() {
+		disc := &discoverkit.MemoryTargetDiscoverer{}
+		disc.Add(discoverkit.Target{Name: "<target>"})
+
+		rec := &TargetRecorder{}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+
+		go func() {
+			defer close(done)
+			disc.DiscoverTargets(ctx, rec.Observe)
+		}()
+
+		Eventually(rec).Should(HaveTarget("<target>"))
+
+		disc.Remove("<target>")
+
+		Eventually(rec).ShouldNot(HaveTarget("<target>"))
+
+		cancel()
+		<-done
+	})
+
+	It("returns an error if the actual value is not supported", func() {
+		_, err := HaveTarget("<target>").Match(123)
+		Expect(err).To(MatchError("expected a *TargetRecorder or []discoverkit.Target, got int"))
+	})
+})
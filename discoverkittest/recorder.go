@@ -0,0 +1,121 @@
+package discoverkittest
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/dogmatiq/discoverkit"
+)
+
+// ApplicationRecorder records the applications that are currently available,
+// as reported to its Observe() method.
+//
+// It is safe for concurrent use. The zero value is ready to use.
+type ApplicationRecorder struct {
+	m    sync.Mutex
+	seq  uint64
+	apps map[uint64]discoverkit.Application
+}
+
+// Observe records a as available until ctx is canceled.
+//
+// It matches the signature of discoverkit.ApplicationObserver.
+func (r *ApplicationRecorder) Observe(ctx context.Context, a discoverkit.Application) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.apps == nil {
+		r.apps = map[uint64]discoverkit.Application{}
+	}
+
+	r.seq++
+	seq := r.seq
+	r.apps[seq] = a
+
+	go func() {
+		<-ctx.Done()
+
+		r.m.Lock()
+		defer r.m.Unlock()
+
+		delete(r.apps, seq)
+	}()
+}
+
+// Applications returns the applications that are currently available, in the
+// order they were observed.
+func (r *ApplicationRecorder) Applications() []discoverkit.Application {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	apps := make([]discoverkit.Application, 0, len(r.apps))
+	for _, seq := range sortedKeys(r.apps) {
+		apps = append(apps, r.apps[seq])
+	}
+
+	return apps
+}
+
+// TargetRecorder records the targets that are currently available, as
+// reported to its Observe() method.
+//
+// It is safe for concurrent use. The zero value is ready to use.
+type TargetRecorder struct {
+	m       sync.Mutex
+	seq     uint64
+	targets map[uint64]discoverkit.Target
+}
+
+// Observe records t as available until ctx is canceled.
+//
+// It matches the signature of discoverkit.TargetObserver.
+func (r *TargetRecorder) Observe(ctx context.Context, t discoverkit.Target) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.targets == nil {
+		r.targets = map[uint64]discoverkit.Target{}
+	}
+
+	r.seq++
+	seq := r.seq
+	r.targets[seq] = t
+
+	go func() {
+		<-ctx.Done()
+
+		r.m.Lock()
+		defer r.m.Unlock()
+
+		delete(r.targets, seq)
+	}()
+}
+
+// Targets returns the targets that are currently available, in the order they
+// were observed.
+func (r *TargetRecorder) Targets() []discoverkit.Target {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	targets := make([]discoverkit.Target, 0, len(r.targets))
+	for _, seq := range sortedKeys(r.targets) {
+		targets = append(targets, r.targets[seq])
+	}
+
+	return targets
+}
+
+// sortedKeys returns the keys of m in ascending order.
+func sortedKeys[T any](m map[uint64]T) []uint64 {
+	keys := make([]uint64, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i] < keys[j]
+	})
+
+	return keys
+}
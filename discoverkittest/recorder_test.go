@@ -0,0 +1,65 @@
+package discoverkittest_test
+
+import (
+	"context"
+
+	"github.com/dogmatiq/configkit"
+	"github.com/dogmatiq/discoverkit"
+	. "github.com/dogmatiq/discoverkit/discoverkittest"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("type ApplicationRecorder", func() {
+	It("records applications until their context is canceled", func() {
+		app1 := discoverkit.Application{
+			Identity: configkit.MustNewIdentity("<app-1-name>", "a2b30343-b86c-485c-94e0-de84dda069a7"),
+		}
+		app2 := discoverkit.Application{
+			Identity: configkit.MustNewIdentity("<app-2-name>", "e7f11e2c-791f-4083-8c71-6aa966fc3db1"),
+		}
+
+		rec := &ApplicationRecorder{}
+
+		ctx1, cancel1 := context.WithCancel(context.Background())
+		defer cancel1()
+
+		ctx2, cancel2 := context.WithCancel(context.Background())
+		defer cancel2()
+
+		rec.Observe(ctx1, app1)
+		rec.Observe(ctx2, app2)
+
+		Expect(rec.Applications()).To(Equal([]discoverkit.Application{app1, app2}))
+
+		cancel1()
+
+		Eventually(rec.Applications).Should(Equal([]discoverkit.Application{app2}))
+	})
+})
+
+var _ = Describe("type TargetRecorder", func() {
+	It("records targets until their context is canceled", func() {
+		rec := &TargetRecorder{}
+
+		ctx1, cancel1 := context.WithCancel(context.Background())
+		defer cancel1()
+
+		ctx2, cancel2 := context.WithCancel(context.Background())
+		defer cancel2()
+
+		rec.Observe(ctx1, discoverkit.Target{Name: "<target-1>"})
+		rec.Observe(ctx2, discoverkit.Target{Name: "<target-2>"})
+
+		Expect(rec.Targets()).To(Equal([]discoverkit.Target{
+			{Name: "<target-1>"},
+			{Name: "<target-2>"},
+		}))
+
+		cancel1()
+
+		Eventually(rec.Targets).Should(Equal([]discoverkit.Target{
+			{Name: "<target-2>"},
+		}))
+	})
+})
@@ -0,0 +1,157 @@
+// Package discoverkittest provides utilities for testing code that discovers
+// or advertises Dogma applications using discoverkit.
+package discoverkittest
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/dogmatiq/configkit"
+	"github.com/dogmatiq/discoverkit"
+	"github.com/dogmatiq/interopspec/discoverspec"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// bufferSize is the size of the buffer used by each in-memory connection.
+const bufferSize = 1024 * 1024
+
+// serverID is used to generate a unique target name for each Server.
+var serverID atomic.Uint64
+
+// Server is a discoverkit.Server that serves the DiscoverAPI in-process over
+// in-memory connections.
+type Server struct {
+	// Server is the server that implements the DiscoverAPI.
+	Server *discoverkit.Server
+
+	// Target is a gRPC target that connects to the server.
+	//
+	// Its dial options connect over an in-memory connection, so it can be
+	// dialed using grpc.DialContext() or by discoverkit.ApplicationDiscoverer
+	// without any additional configuration.
+	Target discoverkit.Target
+
+	listener *bufconn.Listener
+	grpc     *grpc.Server
+	done     chan struct{}
+	close    sync.Once
+}
+
+// NewServer starts a new in-process server.
+//
+// If s is nil, a new discoverkit.Server is used. The server is stopped by
+// calling Close().
+func NewServer(s *discoverkit.Server, options ...grpc.ServerOption) *Server {
+	if s == nil {
+		s = &discoverkit.Server{}
+	}
+
+	return serve(s, s, options)
+}
+
+// serve starts a new in-process server that serves api, which makes
+// applications available via s.
+func serve(
+	s *discoverkit.Server,
+	api discoverspec.DiscoverAPIServer,
+	options []grpc.ServerOption,
+) *Server {
+	lis := bufconn.Listen(bufferSize)
+
+	srv := &Server{
+		Server: s,
+		Target: discoverkit.Target{
+			Name: fmt.Sprintf(
+				"passthrough:///discoverkittest-%d",
+				serverID.Add(1),
+			),
+			DialOptions: []grpc.DialOption{
+				grpc.WithContextDialer(
+					func(ctx context.Context, _ string) (net.Conn, error) {
+						return lis.DialContext(ctx)
+					},
+				),
+				grpc.WithTransportCredentials(
+					insecure.NewCredentials(),
+				),
+			},
+		},
+		listener: lis,
+		grpc:     grpc.NewServer(options...),
+		done:     make(chan struct{}),
+	}
+
+	discoverspec.RegisterDiscoverAPIServer(srv.grpc, api)
+
+	go func() {
+		defer close(srv.done)
+		srv.grpc.Serve(lis)
+	}()
+
+	return srv
+}
+
+// Dial connects to the server.
+//
+// The target name is ignored, which allows the server to be dialed using
+// any name. It matches the signature of discoverkit.Dialer.
+func (s *Server) Dial(
+	ctx context.Context,
+	_ string,
+	options ...grpc.DialOption,
+) (*grpc.ClientConn, error) {
+	return grpc.DialContext(
+		ctx,
+		s.Target.Name,
+		append(options[:len(options):len(options)], s.Target.DialOptions...)...,
+	)
+}
+
+// Available marks the given applications as available.
+func (s *Server) Available(ids ...configkit.Identity) {
+	for _, id := range ids {
+		s.Server.Available(id)
+	}
+}
+
+// Unavailable marks the given applications as unavailable.
+func (s *Server) Unavailable(ids ...configkit.Identity) {
+	for _, id := range ids {
+		s.Server.Unavailable(id)
+	}
+}
+
+// Close stops the server, closing any open connections.
+func (s *Server) Close() {
+	s.close.Do(func() {
+		s.grpc.Stop()
+		s.listener.Close()
+		<-s.done
+	})
+}
+
+// Dialer returns a discoverkit.Dialer that connects to one of the given servers
+// based on the target name.
+//
+// It returns an error when dialing a target name that does not belong to one
+// of the servers.
+func Dialer(servers ...*Server) discoverkit.Dialer {
+	return func(
+		ctx context.Context,
+		name string,
+		options ...grpc.DialOption,
+	) (*grpc.ClientConn, error) {
+		for _, s := range servers {
+			if s.Target.Name == name {
+				return s.Dial(ctx, name, options...)
+			}
+		}
+
+		return nil, fmt.Errorf("%s is not the target name of any of the test servers", name)
+	}
+}
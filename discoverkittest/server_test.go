@@ -0,0 +1,179 @@
+package discoverkittest_test
+
+import (
+	"context"
+	"time"
+
+	"github.com/dogmatiq/configkit"
+	"github.com/dogmatiq/discoverkit"
+	. "github.com/dogmatiq/discoverkit/discoverkittest"
+	"github.com/dogmatiq/linger/backoff"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("type Server", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+
+		app1, app2 configkit.Identity
+		server     *Server
+		disc       *discoverkit.ApplicationDiscoverer
+		rec        *ApplicationRecorder
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 2*time.Second)
+		DeferCleanup(cancel)
+
+		app1 = configkit.MustNewIdentity("<app-1-name>", "a2b30343-b86c-485c-94e0-de84dda069a7")
+		app2 = configkit.MustNewIdentity("<app-2-name>", "e7f11e2c-791f-4083-8c71-6aa966fc3db1")
+
+		server = NewServer(nil)
+		DeferCleanup(server.Close)
+
+		disc = &discoverkit.ApplicationDiscoverer{
+			BackoffStrategy: backoff.Constant(10 * time.Millisecond),
+		}
+
+		rec = &ApplicationRecorder{}
+	})
+
+	// discover runs the discoverer against t in the background until the
+	// current spec ends.
+	discover := func(t discoverkit.Target) {
+		ctx, cancel, disc, rec := ctx, cancel, disc, rec
+		done := make(chan struct{})
+
+		go func() {
+			defer close(done)
+			disc.DiscoverApplications(ctx, t, rec.Observe)
+		}()
+
+		DeferCleanup(func() {
+			cancel()
+			<-done
+		})
+	}
+
+	Describe("func NewServer()", func() {
+		It("uses the given discoverkit.Server", func() {
+			s := &discoverkit.Server{}
+			srv := NewServer(s)
+			defer srv.Close()
+
+			Expect(srv.Server).To(BeIdenticalTo(s))
+		})
+
+		It("returns servers with distinct target names", func() {
+			srv := NewServer(nil)
+			defer srv.Close()
+
+			Expect(srv.Target.Name).NotTo(Equal(server.Target.Name))
+		})
+	})
+
+	Describe("func Available()", func() {
+		It("makes the applications available to the target's discoverers", func() {
+			discover(server.Target)
+
+			server.Available(app1, app2)
+
+			Eventually(rec).Should(HaveApplicationOnTarget(app1, server.Target.Name))
+			Eventually(rec).Should(HaveApplicationOnTarget(app2, server.Target.Name))
+		})
+	})
+
+	Describe("func Unavailable()", func() {
+		It("makes the applications unavailable to the target's discoverers", func() {
+			server.Available(app1, app2)
+			discover(server.Target)
+
+			Eventually(rec).Should(HaveApplication(app1))
+			Eventually(rec).Should(HaveApplication(app2))
+
+			server.Unavailable(app1)
+
+			Eventually(rec).ShouldNot(HaveApplication(app1))
+			Consistently(rec, 50*time.Millisecond).Should(HaveApplication(app2))
+		})
+	})
+
+	Describe("func Dial()", func() {
+		It("connects to the server regardless of the target name", func() {
+			disc.Dial = server.Dial
+			server.Available(app1)
+
+			discover(discoverkit.Target{Name: "<target>"})
+
+			Eventually(rec).Should(HaveApplicationOnTarget(app1, "<target>"))
+		})
+	})
+
+	Describe("func Close()", func() {
+		It("causes discoverers to treat the applications as unavailable", func() {
+			server.Available(app1)
+			discover(server.Target)
+
+			Eventually(rec).Should(HaveApplication(app1))
+
+			server.Close()
+
+			Eventually(rec).ShouldNot(HaveApplication(app1))
+		})
+
+		It("may be called more than once", func() {
+			server.Close()
+			server.Close()
+		})
+	})
+})
+
+var _ = Describe("func Dialer()", func() {
+	It("dials the server with the matching target name", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		app := configkit.MustNewIdentity("<app-name>", "4edad1cb-5aa6-4984-97fc-3fb7b187ffc7")
+
+		server1 := NewServer(nil)
+		defer server1.Close()
+
+		server2 := NewServer(nil)
+		defer server2.Close()
+
+		server2.Available(app)
+
+		disc := &discoverkit.ApplicationDiscoverer{
+			Dial: Dialer(server1, server2),
+		}
+
+		rec := &ApplicationRecorder{}
+		done := make(chan struct{})
+
+		go func() {
+			defer close(done)
+			disc.DiscoverApplications(
+				ctx,
+				discoverkit.Target{Name: server2.Target.Name},
+				rec.Observe,
+			)
+		}()
+
+		Eventually(rec).Should(HaveApplicationOnTarget(app, server2.Target.Name))
+
+		cancel()
+		<-done
+	})
+
+	It("returns an error if the target name does not match any of the servers", func() {
+		server := NewServer(nil)
+		defer server.Close()
+
+		dial := Dialer(server)
+
+		_, err := dial(context.Background(), "<target>")
+		Expect(err).To(MatchError("<target> is not the target name of any of the test servers"))
+	})
+})
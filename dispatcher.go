@@ -0,0 +1,188 @@
+package discoverkit
+
+import (
+	"context"
+	"strconv"
+	"sync"
+)
+
+// DefaultDispatcherQueueLength is the default maximum number of calls that a
+// Dispatcher holds at any one time.
+const DefaultDispatcherQueueLength = 1000
+
+// Dispatcher invokes observers on dedicated goroutines, so that a slow
+// observer does not block the discoverer that reports to it.
+//
+// Calls to an observer that relate to the same target, or to the same
+// application on the same target, are made one at a time in the order they
+// were dispatched. Calls that relate to different targets or applications may
+// be made concurrently.
+//
+// The dispatcher holds a limited number of calls, including those that are in
+// progress. Once the limit is reached it applies back-pressure: the wrapped
+// observer blocks the discoverer until an earlier call completes. Calls are
+// never discarded, other than those with a canceled context.
+//
+// Observers are wrapped by calling TargetObserver() or ApplicationObserver().
+// Use NewDispatcher() to create a dispatcher.
+type Dispatcher struct {
+	// LogError is an optional function that logs errors that occur when an
+	// observer panics. Each such error is an *ObserverPanicError.
+	//
+	// Observer panics are always recovered, allowing the discoverer and
+	// subsequent calls to the observer to continue.
+	LogError func(error)
+
+	// sem limits the number of calls that are in progress. It is nil if the
+	// number is unbounded.
+	sem chan struct{}
+
+	// slots limits the number of calls that are queued or in progress, and
+	// therefore also the number of worker goroutines.
+	slots chan struct{}
+
+	m         sync.Mutex
+	observers uint64
+	queues    map[string][]func()
+}
+
+// NewDispatcher returns a new Dispatcher.
+//
+// maxConcurrency is the maximum number of observer calls that may be in
+// progress at any one time. If it is non-positive, the number of concurrent
+// calls is unbounded.
+//
+// maxQueueLength is the maximum number of observer calls that may be queued or
+// in progress at any one time, across all targets and applications. If it is
+// non-positive, DefaultDispatcherQueueLength is used.
+func NewDispatcher(maxConcurrency, maxQueueLength int) *Dispatcher {
+	if maxQueueLength <= 0 {
+		maxQueueLength = DefaultDispatcherQueueLength
+	}
+
+	d := &Dispatcher{
+		slots:  make(chan struct{}, maxQueueLength),
+		queues: map[string][]func(){},
+	}
+
+	if maxConcurrency > 0 {
+		d.sem = make(chan struct{}, maxConcurrency)
+	}
+
+	return d
+}
+
+// TargetObserver returns an observer that dispatches calls to obs.
+//
+// The calls are made in order for each target name. A call is skipped if the
+// context passed to it is canceled before the call begins. The returned
+// observer blocks while the dispatcher's queue is full.
+func (d *Dispatcher) TargetObserver(obs TargetObserver) TargetObserver {
+	prefix := d.newObserver()
+
+	return func(ctx context.Context, t Target) {
+		d.dispatch(
+			prefix+t.Name,
+			func() {
+				if ctx.Err() != nil {
+					return
+				}
+
+				if err := observeTarget(ctx, RecoverPanics, obs, t); err != nil {
+					d.logError(err)
+				}
+			},
+		)
+	}
+}
+
+// ApplicationObserver returns an observer that dispatches calls to obs.
+//
+// The calls are made in order for each application on each target. A call is
+// skipped if the context passed to it is canceled before the call begins. The
+// returned observer blocks while the dispatcher's queue is full.
+func (d *Dispatcher) ApplicationObserver(obs ApplicationObserver) ApplicationObserver {
+	prefix := d.newObserver()
+
+	return func(ctx context.Context, a Application) {
+		d.dispatch(
+			prefix+a.Identity.Key+"\x00"+a.Target.Name,
+			func() {
+				if ctx.Err() != nil {
+					return
+				}
+
+				if err := observeApplication(ctx, RecoverPanics, obs, a); err != nil {
+					d.logError(err)
+				}
+			},
+		)
+	}
+}
+
+// newObserver returns a key prefix that is unique to a new wrapped observer.
+func (d *Dispatcher) newObserver() string {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	d.observers++
+	return strconv.FormatUint(d.observers, 10) + "\x00"
+}
+
+// dispatch queues fn to be called after any other function with the same key.
+//
+// It blocks until there is room in the queue.
+func (d *Dispatcher) dispatch(key string, fn func()) {
+	d.slots <- struct{}{}
+
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	queue := d.queues[key]
+	d.queues[key] = append(queue, fn)
+
+	// If the queue was empty there is no worker goroutine for this key, so we
+	// start one.
+	if len(queue) == 0 {
+		go d.work(key)
+	}
+}
+
+// work calls the functions queued for the given key, in order, until the
+// queue is empty.
+func (d *Dispatcher) work(key string) {
+	for {
+		d.m.Lock()
+		fn := d.queues[key][0]
+		d.m.Unlock()
+
+		if d.sem != nil {
+			d.sem <- struct{}{}
+		}
+
+		fn()
+
+		if d.sem != nil {
+			<-d.sem
+		}
+
+		<-d.slots
+
+		d.m.Lock()
+		queue := d.queues[key][1:]
+		if len(queue) == 0 {
+			delete(d.queues, key)
+			d.m.Unlock()
+			return
+		}
+		d.queues[key] = queue
+		d.m.Unlock()
+	}
+}
+
+// logError logs err to the LogError function, if present.
+func (d *Dispatcher) logError(err error) {
+	if d.LogError != nil {
+		d.LogError(err)
+	}
+}
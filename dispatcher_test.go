@@ -0,0 +1,312 @@
+package discoverkit_test
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/dogmatiq/configkit"
+	. "github.com/dogmatiq/discoverkit"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+)
+
+var _ = Describe("type Dispatcher", func() {
+	var (
+		ctx        context.Context
+		cancel     context.CancelFunc
+		dispatcher *Dispatcher
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 2*time.Second)
+		DeferCleanup(cancel)
+
+		dispatcher = NewDispatcher(0, 0)
+	})
+
+	Describe("func TargetObserver()", func() {
+		It("does not block while the observer is running", func() {
+			release := make(chan struct{})
+			DeferCleanup(func() { close(release) })
+
+			obs := dispatcher.TargetObserver(func(context.Context, Target) {
+				<-release
+			})
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				obs(ctx, Target{Name: "<target-1>"})
+				obs(ctx, Target{Name: "<target-1>"})
+				obs(ctx, Target{Name: "<target-2>"})
+			}()
+
+			Eventually(done).Should(BeClosed())
+		})
+
+		It("invokes the observer in order for each target", func() {
+			var (
+				m     sync.Mutex
+				order []int
+			)
+
+			obs := dispatcher.TargetObserver(func(_ context.Context, t Target) {
+				time.Sleep(time.Millisecond)
+
+				m.Lock()
+				defer m.Unlock()
+				order = append(order, len(t.DialOptions))
+			})
+
+			var expect []int
+			for i := 0; i < 10; i++ {
+				expect = append(expect, i)
+				obs(ctx, Target{
+					Name:        "<target>",
+					DialOptions: make([]grpc.DialOption, i),
+				})
+			}
+
+			Eventually(func() []int {
+				m.Lock()
+				defer m.Unlock()
+				return append([]int(nil), order...)
+			}).Should(Equal(expect))
+		})
+
+		It("invokes the observer concurrently for different targets", func() {
+			release := make(chan struct{})
+			DeferCleanup(func() { close(release) })
+
+			targets := make(chan string, 10)
+
+			obs := dispatcher.TargetObserver(func(_ context.Context, t Target) {
+				targets <- t.Name
+				<-release
+			})
+
+			obs(ctx, Target{Name: "<target-1>"})
+			obs(ctx, Target{Name: "<target-2>"})
+
+			var names []string
+			for i := 0; i < 2; i++ {
+				var name string
+				Eventually(targets).Should(Receive(&name))
+				names = append(names, name)
+			}
+
+			Expect(names).To(ConsistOf("<target-1>", "<target-2>"))
+		})
+
+		It("limits the number of concurrent calls", func() {
+			dispatcher = NewDispatcher(2, 0)
+
+			var (
+				m              sync.Mutex
+				active, max, n int
+			)
+
+			obs := dispatcher.TargetObserver(func(context.Context, Target) {
+				m.Lock()
+				active++
+				if active > max {
+					max = active
+				}
+				m.Unlock()
+
+				time.Sleep(10 * time.Millisecond)
+
+				m.Lock()
+				active--
+				n++
+				m.Unlock()
+			})
+
+			for _, name := range []string{"<a>", "<b>", "<c>", "<d>", "<e>"} {
+				obs(ctx, Target{Name: name})
+			}
+
+			Eventually(func() int {
+				m.Lock()
+				defer m.Unlock()
+				return n
+			}).Should(Equal(5))
+
+			Expect(max).To(Equal(2))
+		})
+
+		It("blocks while the queue is full", func() {
+			dispatcher = NewDispatcher(0, 2)
+
+			release := make(chan struct{})
+			targets := make(chan Target, 10)
+
+			obs := dispatcher.TargetObserver(func(_ context.Context, t Target) {
+				targets <- t
+				<-release
+			})
+
+			obs(ctx, Target{Name: "<target-1>"})
+			obs(ctx, Target{Name: "<target-2>"})
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				obs(ctx, Target{Name: "<target-3>"})
+			}()
+
+			Consistently(done, 50*time.Millisecond).ShouldNot(BeClosed())
+
+			close(release)
+
+			Eventually(done).Should(BeClosed())
+			Eventually(targets).Should(HaveLen(3))
+		})
+
+		It("skips calls with contexts that are canceled before the call begins", func() {
+			release := make(chan struct{})
+			targets := make(chan Target, 10)
+
+			obs := dispatcher.TargetObserver(func(_ context.Context, t Target) {
+				targets <- t
+				<-release
+			})
+
+			obs(ctx, Target{Name: "<target>"})
+			Eventually(targets).Should(Receive())
+
+			canceled, cancelTarget := context.WithCancel(ctx)
+			obs(canceled, Target{Name: "<target>"})
+			cancelTarget()
+
+			close(release)
+
+			Consistently(targets, 50*time.Millisecond).ShouldNot(Receive())
+		})
+
+		It("reports observer panics and continues to invoke the observer", func() {
+			errors := make(chan error, 10)
+			dispatcher.LogError = func(err error) {
+				errors <- err
+			}
+
+			targets := make(chan Target, 10)
+
+			obs := dispatcher.TargetObserver(func(_ context.Context, t Target) {
+				if t.Name == "<panic>" {
+					panic("<value>")
+				}
+				targets <- t
+			})
+
+			obs(ctx, Target{Name: "<panic>"})
+			obs(ctx, Target{Name: "<target>"})
+
+			var err error
+			Eventually(errors).Should(Receive(&err))
+			Expect(err).To(MatchError("observer for target <panic> panicked: <value>"))
+			Eventually(targets).Should(Receive(Equal(Target{Name: "<target>"})))
+		})
+	})
+
+	Describe("func ApplicationObserver()", func() {
+		var app1, app2 configkit.Identity
+
+		BeforeEach(func() {
+			app1 = configkit.MustNewIdentity("<app-1-name>", "a2b30343-b86c-485c-94e0-de84dda069a7")
+			app2 = configkit.MustNewIdentity("<app-2-name>", "e7f11e2c-791f-4083-8c71-6aa966fc3db1")
+		})
+
+		It("invokes the observer concurrently for different applications on the same target", func() {
+			release := make(chan struct{})
+			DeferCleanup(func() { close(release) })
+
+			apps := make(chan configkit.Identity, 10)
+
+			obs := dispatcher.ApplicationObserver(func(_ context.Context, a Application) {
+				apps <- a.Identity
+				<-release
+			})
+
+			obs(ctx, Application{Identity: app1, Target: Target{Name: "<target>"}})
+			obs(ctx, Application{Identity: app2, Target: Target{Name: "<target>"}})
+
+			var ids []configkit.Identity
+			for i := 0; i < 2; i++ {
+				var id configkit.Identity
+				Eventually(apps).Should(Receive(&id))
+				ids = append(ids, id)
+			}
+
+			Expect(ids).To(ConsistOf(app1, app2))
+		})
+
+		It("invokes the observer in order for the same application on the same target", func() {
+			release := make(chan struct{})
+			apps := make(chan Application, 10)
+
+			obs := dispatcher.ApplicationObserver(func(_ context.Context, a Application) {
+				apps <- a
+				<-release
+			})
+
+			obs(ctx, Application{Identity: app1, Target: Target{Name: "<target>"}})
+			Eventually(apps).Should(Receive())
+
+			obs(ctx, Application{Identity: app1, Target: Target{Name: "<target>"}})
+			Consistently(apps, 50*time.Millisecond).ShouldNot(Receive())
+
+			close(release)
+			Eventually(apps).Should(Receive())
+		})
+
+		It("reports observer panics", func() {
+			errors := make(chan error, 10)
+			dispatcher.LogError = func(err error) {
+				errors <- err
+			}
+
+			obs := dispatcher.ApplicationObserver(func(context.Context, Application) {
+				panic("<value>")
+			})
+
+			obs(ctx, Application{Identity: app1, Target: Target{Name: "<target>"}})
+
+			var err error
+			Eventually(errors).Should(Receive(&err))
+			Expect(err).To(MatchError(
+				"observer for application <app-1-name>/a2b30343-b86c-485c-94e0-de84dda069a7 on target <target> panicked: <value>",
+			))
+		})
+	})
+
+	It("can be used with a discoverer", func() {
+		disc := &MemoryTargetDiscoverer{}
+		disc.Add(Target{Name: "<target-1>"})
+		disc.Add(Target{Name: "<target-2>"})
+
+		release := make(chan struct{})
+		DeferCleanup(func() { close(release) })
+
+		targets := make(chan string, 10)
+
+		go disc.DiscoverTargets(
+			ctx,
+			dispatcher.TargetObserver(func(_ context.Context, t Target) {
+				targets <- t.Name
+				<-release // the first target must not block the second
+			}),
+		)
+
+		var names []string
+		for i := 0; i < 2; i++ {
+			var name string
+			Eventually(targets).Should(Receive(&name))
+			names = append(names, name)
+		}
+
+		Expect(names).To(ConsistOf("<target-1>", "<target-2>"))
+	})
+})
@@ -2,48 +2,172 @@ package discoverkit
 
 import (
 	"context"
+	"fmt"
 	"net"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/dogmatiq/linger"
+	"google.golang.org/grpc"
 )
 
 const (
 	// DefaultDNSQueryInterval is the default interval at which DNS queries are
 	// performed.
 	DefaultDNSQueryInterval = 10 * time.Second
+
+	// DefaultDNSMinQueryInterval is the default minimum interval between DNS
+	// queries when the interval is determined by record TTLs.
+	DefaultDNSMinQueryInterval = 1 * time.Second
+
+	// DefaultDNSMaxQueryInterval is the default maximum interval between DNS
+	// queries when the interval is determined by record TTLs.
+	DefaultDNSMaxQueryInterval = 5 * time.Minute
 )
 
 // DNSTargetDiscoverer is a TargetDiscoverer that performs a DNS query to
 // discover targets.
 //
-// It queries a single host and treats each A, AAAA or CNAME record in the
-// result as a distinct target. This is not a DNS-SD implementation.
+// It queries one or more hosts and treats each address in the results as a
+// distinct target, unless GroupByHost is true. This is not a DNS-SD
+// implementation.
 type DNSTargetDiscoverer struct {
 	// QueryHost is the hostname that is queried.
+	//
+	// If it is non-empty, it is queried before any of the hosts in
+	// QueryHosts, using the discoverer's NewTargets function and the
+	// DefaultGRPCPort constant for the port.
 	QueryHost string
 
+	// QueryHosts is a list of additional hosts that are queried, each with
+	// its own port and NewTargets function.
+	//
+	// The results of all queries are merged. An address that is returned by
+	// several hosts is only passed to the observer once, using the first of
+	// those hosts in the list, and is not considered to have gone away until
+	// none of the hosts return it.
+	QueryHosts []DNSQueryHost
+
 	// NewTargets returns the targets that are discovered based on the addition
 	// of a new network address to the DNS query result.
 	//
 	// addr is the address discovered by the DNS query. It may be a hostname or
-	// an IP address.
+	// an IP address. If GroupByHost is true, addr is the name of the host
+	// instead.
 	//
 	// If NewTargets is nil the discoverer constructs a single Target for each
 	// discovered address. The target name is built using the discovered address
 	// as the host and the DefaultGRPCPort constant for the port.
 	NewTargets func(ctx context.Context, addr string) (targets []Target, err error)
 
-	// LookupHost is the function used to query the host.
+	// AddressFamily is the policy that determines which IP address families
+	// are used. By default, both IPv4 and IPv6 addresses are used.
+	AddressFamily AddressFamilyPolicy
+
+	// GroupByHost, if true, combines the addresses of each host into a single
+	// target, rather than treating each address as a distinct target.
+	//
+	// The host is the owner name of the A or AAAA record, which is the
+	// canonical name of the host if the query followed any CNAME records. If
+	// the host is queried using LookupHost, all addresses belong to the
+	// queried host.
+	//
+	// If NewTargets is nil, the target uses a gRPC resolver that is updated
+	// with the host's addresses as they change, without canceling the
+	// target's context.
+	GroupByHost bool
+
+	// TLS is the TLS configuration used to dial the targets constructed when
+	// NewTargets is nil.
 	//
-	// If it is nil, net.DefaultResolver.LookupHost() is used.
+	// The server certificate is verified against the queried host, not the
+	// discovered address. If TLS is nil, the targets have no dial options.
+	TLS *TLSConfig
+
+	// LookupHost is the function used to query the hosts.
+	//
+	// If it is nil, net.DefaultResolver.LookupHost() is used. It is ignored if
+	// Resolver is non-nil.
 	LookupHost func(ctx context.Context, host string) (addresses []string, err error)
 
-	// QueryInterval is the interval at which DNS queries are performed.
+	// Resolver is the resolver used to query the hosts, such as a DNSClient.
+	//
+	// If it is non-nil, it is used instead of LookupHost, and the interval
+	// between queries is the lowest TTL of the records in the result, limited
+	// to the range [MinQueryInterval, MaxQueryInterval].
+	Resolver DNSResolver
+
+	// QueryInterval is the interval at which DNS queries are performed when
+	// the interval is not determined by record TTLs.
 	//
 	// If it is non-positive, the DefaultDNSQueryInterval constant is used.
 	QueryInterval time.Duration
+
+	// MinQueryInterval is the minimum interval between DNS queries when the
+	// interval is determined by record TTLs.
+	//
+	// If it is non-positive, the DefaultDNSMinQueryInterval constant is used.
+	MinQueryInterval time.Duration
+
+	// MaxQueryInterval is the maximum interval between DNS queries when the
+	// interval is determined by record TTLs.
+	//
+	// If it is non-positive, the DefaultDNSMaxQueryInterval constant is used.
+	MaxQueryInterval time.Duration
+
+	// RemoveAfterMisses is the number of consecutive query results that an
+	// address must be absent from before its targets are considered to have
+	// gone away.
+	//
+	// RemoveAfter is the amount of time that an address must be absent from
+	// the query results before its targets are considered to have gone away.
+	//
+	// These settings prevent churn when a DNS server returns a different
+	// subset of the records in each response. If both are set, both
+	// conditions must be met. If neither is set, targets are considered to
+	// have gone away as soon as their address is absent from a query result.
+	RemoveAfterMisses int
+	RemoveAfter       time.Duration
+
+	// AddAfterHits is the number of consecutive query results that an address
+	// must appear in before the observer is invoked for its targets.
+	//
+	// If it is non-positive, the observer is invoked as soon as the address
+	// appears in a query result.
+	AddAfterHits int
+
+	// LogError is an optional function that logs errors that occur while
+	// querying a host, including errors that do not stop the discoverer.
+	LogError func(host string, err error)
+
+	// KeepResultsOnFailure, if true, causes the discoverer to re-use the last
+	// successful result for a host when a query fails with a temporary error,
+	// instead of treating the host as having no addresses.
+	KeepResultsOnFailure bool
+
+	// MaxQueryFailures is the number of consecutive queries of a single host
+	// that may fail with a temporary error before the failure is returned by
+	// DiscoverTargets().
+	//
+	// If it is non-positive, temporary errors never stop the discoverer.
+	MaxQueryFailures int
+}
+
+// DNSQueryHost is a host that is queried by a DNSTargetDiscoverer.
+type DNSQueryHost struct {
+	// Host is the hostname that is queried.
+	Host string
+
+	// Port is the port used for the targets constructed when NewTargets is
+	// nil. If it is empty, the DefaultGRPCPort constant is used.
+	Port string
+
+	// NewTargets returns the targets that are discovered based on the
+	// addition of a new network address to the result of querying Host.
+	//
+	// If it is nil, the discoverer's NewTargets function is used, if present.
+	NewTargets func(ctx context.Context, addr string) (targets []Target, err error)
 }
 
 // DiscoverTargets invokes an observer for each gRPC target that is discovered.
@@ -57,65 +181,133 @@ type DNSTargetDiscoverer struct {
 // responsibility to start new goroutines to handle background tasks, as
 // appropriate.
 func (d *DNSTargetDiscoverer) DiscoverTargets(ctx context.Context, obs TargetObserver) error {
-	addresses := map[string]context.CancelFunc{}
+	entries := map[string]*dnsEntry{}
+	pending := map[string]int{}
+	hosts := map[string]*dnsHostState{}
 
 	defer func() {
-		for _, cancel := range addresses {
-			cancel()
+		for _, e := range entries {
+			e.cancel()
 		}
 	}()
 
 	for {
-		// Perform the DNS query.
-		results, err := d.query(ctx)
+		// Perform the DNS queries.
+		results, ttl, err := d.query(ctx, hosts)
 		if err != nil {
 			return err
 		}
 
 		// Invoke the observer / cancel contexts to sync the observer state with
 		// the new results.
-		if err := d.sync(ctx, addresses, results, obs); err != nil {
+		if err := d.sync(ctx, entries, pending, results, obs); err != nil {
 			return err
 		}
 
 		// Wait until it's time to perform the next DNS query.
-		if err := linger.Sleep(
-			ctx,
-			d.QueryInterval,
-			DefaultDNSQueryInterval,
-		); err != nil {
+		if err := linger.Sleep(ctx, d.interval(ttl)); err != nil {
 			return err
 		}
 	}
 }
 
+// dnsEntry is the state of the targets discovered for a single address, or a
+// single host if the discoverer groups addresses by host.
+type dnsEntry struct {
+	// cancel cancels the context passed to the observer for the entry's
+	// targets.
+	cancel context.CancelFunc
+
+	// resolver is the gRPC resolver used by the entry's target, if any. It is
+	// updated when the host's addresses change.
+	resolver *addressResolver
+
+	// port is the port used by the resolver's addresses.
+	port string
+
+	// misses is the number of consecutive query results that the entry has
+	// been absent from, and missingSince is the time at which it was first
+	// absent.
+	misses       int
+	missingSince time.Time
+}
+
+// dnsHostState is the state of the queries of a single host.
+type dnsHostState struct {
+	// failures is the number of consecutive queries that have failed with a
+	// temporary error.
+	failures int
+
+	// addrs is the result of the last successful query.
+	addrs []dnsAddress
+}
+
+// dnsResult is a single address, or a single host if the discoverer groups
+// addresses by host, within the merged results of the DNS queries.
+type dnsResult struct {
+	// addrs is the set of addresses that the result represents.
+	addrs []string
+
+	// source is the first query host that returned the result.
+	source DNSQueryHost
+}
+
 // sync synchronizes the state of running observers based on a new set of DNS
 // query results.
+//
+// results maps each address (or host) to its query result. pending maps each
+// address that has not yet been seen AddAfterHits times to the number of
+// consecutive query results it has appeared in.
 func (d *DNSTargetDiscoverer) sync(
 	ctx context.Context,
-	addresses map[string]context.CancelFunc,
-	results map[string]struct{},
+	entries map[string]*dnsEntry,
+	pending map[string]int,
+	results map[string]*dnsResult,
 	obs TargetObserver,
 ) error {
+	now := time.Now()
+
 	// First we check through the known addresses to work out which ones are
 	// still in the latest query results.
-	for addr, cancel := range addresses {
-		if _, ok := results[addr]; ok {
+	for key, e := range entries {
+		if r, ok := results[key]; ok {
 			// This address is still avaliable. Remove it from the query results
 			// so we're left only with addresses that we have not seen before.
-			delete(results, addr)
-		} else {
+			delete(results, key)
+			e.misses = 0
+			e.missingSince = time.Time{}
+
+			if e.resolver != nil {
+				e.resolver.Update(resolverAddresses(r.addrs, e.port))
+			}
+		} else if d.gone(e, now) {
 			// This address is no longer in the results. Cancel the associated
 			// context to stop the observer goroutines.
-			delete(addresses, addr)
-			cancel()
+			delete(entries, key)
+			e.cancel()
+		}
+	}
+
+	// Forget about any pending addresses that are not in the latest results,
+	// their hits must be consecutive.
+	for key := range pending {
+		if _, ok := results[key]; !ok {
+			delete(pending, key)
 		}
 	}
 
 	// Then we can look at the query results, which at this point contains only
 	// those addresses we didn't already know about.
-	for addr := range results {
-		targets, err := d.newTargets(ctx, addr)
+	for key, r := range results {
+		if d.AddAfterHits > 1 {
+			pending[key]++
+			if pending[key] < d.AddAfterHits {
+				continue
+			}
+			delete(pending, key)
+		}
+
+		targets, res, err := d.newTargets(ctx, key, r)
 		if err != nil {
 			return err
 		}
@@ -123,7 +315,11 @@ func (d *DNSTargetDiscoverer) sync(
 		// Create a new context specifically for this address. It will be
 		// canceled if the address dissappears from the query results.
 		addrCtx, cancel := context.WithCancel(ctx)
-		addresses[addr] = cancel
+		entries[key] = &dnsEntry{
+			cancel:   cancel,
+			resolver: res,
+			port:     dnsPort(r.source),
+		}
 
 		// Invoke the observer for each target.
 		for _, t := range targets {
@@ -134,48 +330,265 @@ func (d *DNSTargetDiscoverer) sync(
 	return nil
 }
 
-// query performs a DNS query to A, AAAA and CNAME records associated with
-// d.QueryHost.
+// gone records that an entry is absent from a query result, and returns true
+// if its targets are now considered to have gone away.
+func (d *DNSTargetDiscoverer) gone(e *dnsEntry, now time.Time) bool {
+	e.misses++
+	if e.missingSince.IsZero() {
+		e.missingSince = now
+	}
+
+	return e.misses >= d.RemoveAfterMisses &&
+		now.Sub(e.missingSince) >= d.RemoveAfter
+}
+
+// query performs DNS queries for the A, AAAA and CNAME records associated with
+// each of the query hosts, and merges the results.
 //
-// It returns the resulting addresses as a set with names transformed to
-// lowercase. Individual addresses may be hostnames or IP addresses.
-func (d *DNSTargetDiscoverer) query(ctx context.Context) (map[string]struct{}, error) {
-	lookupHost := d.LookupHost
-	if lookupHost == nil {
-		lookupHost = net.DefaultResolver.LookupHost
+// It returns a map of each address to its result, or if d.GroupByHost is true,
+// a map of each host to its result. Names are transformed to lowercase.
+// Individual addresses may be hostnames or IP addresses.
+//
+// It also returns the lowest TTL of the records in the results, or a negative
+// duration if it is unknown.
+//
+// hosts is the state of each query host, which is updated with the outcome of
+// each query.
+func (d *DNSTargetDiscoverer) query(
+	ctx context.Context,
+	hosts map[string]*dnsHostState,
+) (map[string]*dnsResult, time.Duration, error) {
+	results := map[string]*dnsResult{}
+	ttl := time.Duration(-1)
+
+	for _, h := range d.queryHosts() {
+		hs, ok := hosts[h.Host]
+		if !ok {
+			hs = &dnsHostState{}
+			hosts[h.Host] = hs
+		}
+
+		addrs, t, err := d.lookup(ctx, h.Host)
+		if err != nil {
+			// If the context has been canceled we don't really care what
+			// happened to the query.
+			if ctx.Err() != nil {
+				return nil, -1, ctx.Err()
+			}
+
+			x, ok := err.(*net.DNSError)
+			if !ok || !(x.IsTemporary || x.IsNotFound) {
+				return nil, -1, err
+			}
+
+			d.logError(h.Host, err)
+
+			if x.IsNotFound {
+				// The fact that host doesn't exist *right now* is not an error
+				// that should stop the discoverer, it simply has no addresses.
+				hs.failures = 0
+				hs.addrs = nil
+				continue
+			}
+
+			// Temporary network problems are not errors that should stop the
+			// discoverer, unless they persist.
+			hs.failures++
+
+			if d.MaxQueryFailures > 0 && hs.failures >= d.MaxQueryFailures {
+				return nil, -1, &WatchError{
+					Phase:  WatchPhaseQuery,
+					Target: Target{Name: h.Host},
+					Cause: fmt.Errorf(
+						"%d consecutive queries have failed: %w",
+						hs.failures,
+						err,
+					),
+				}
+			}
+
+			if !d.KeepResultsOnFailure {
+				continue
+			}
+
+			addrs = hs.addrs
+		} else {
+			hs.failures = 0
+			hs.addrs = addrs
+		}
+
+		if t >= 0 && (ttl < 0 || t < ttl) {
+			ttl = t
+		}
+
+		for _, a := range d.AddressFamily.apply(addrs) {
+			key := a.addr
+			if d.GroupByHost {
+				key = a.host
+			}
+
+			r, ok := results[key]
+			if !ok {
+				r = &dnsResult{source: h}
+				results[key] = r
+			}
+
+			if !slices.Contains(r.addrs, a.addr) {
+				r.addrs = append(r.addrs, a.addr)
+			}
+		}
 	}
 
-	addrs, err := lookupHost(ctx, d.QueryHost)
+	return results, ttl, nil
+}
+
+// logError logs err to the LogError function, if present.
+func (d *DNSTargetDiscoverer) logError(host string, err error) {
+	if d.LogError != nil {
+		d.LogError(host, err)
+	}
+}
+
+// queryHosts returns the hosts to query, in order of precedence.
+func (d *DNSTargetDiscoverer) queryHosts() []DNSQueryHost {
+	if d.QueryHost == "" {
+		return d.QueryHosts
+	}
+
+	return append(
+		[]DNSQueryHost{{Host: d.QueryHost}},
+		d.QueryHosts...,
+	)
+}
+
+// lookup returns the addresses of host and their lowest TTL using either
+// d.Resolver or d.LookupHost.
+func (d *DNSTargetDiscoverer) lookup(ctx context.Context, host string) ([]dnsAddress, time.Duration, error) {
+	if d.Resolver == nil {
+		lookupHost := d.LookupHost
+		if lookupHost == nil {
+			lookupHost = net.DefaultResolver.LookupHost
+		}
+
+		addrs, err := lookupHost(ctx, host)
+		if err != nil {
+			return nil, -1, err
+		}
+
+		owner := strings.ToLower(strings.TrimSuffix(host, "."))
+		results := make([]dnsAddress, len(addrs))
+		for i, addr := range addrs {
+			results[i] = dnsAddress{owner, strings.ToLower(addr)}
+		}
+
+		return results, -1, nil
+	}
+
+	records, err := d.Resolver.Resolve(ctx, host)
 	if err != nil {
-		if x, ok := err.(*net.DNSError); ok {
-			// Temporary network problems, or the fact that host doesn't exist
-			// *right now* are not errors that should stop the discoverer.
-			if x.IsTemporary || x.IsNotFound {
-				return nil, nil
-			}
+		return nil, -1, err
+	}
+
+	var (
+		results []dnsAddress
+		ttl     time.Duration = -1
+	)
+
+	for _, r := range records {
+		if ttl < 0 || r.TTL < ttl {
+			ttl = r.TTL
 		}
 
-		return nil, err
+		if r.Type != DNSRecordTypeCNAME {
+			results = append(
+				results,
+				dnsAddress{
+					strings.ToLower(r.Name),
+					strings.ToLower(r.Value),
+				},
+			)
+		}
 	}
 
-	// Convert the slice of addresses to a set of lowercase strings.
-	results := make(map[string]struct{}, len(addrs))
-	for _, addr := range addrs {
-		results[strings.ToLower(addr)] = struct{}{}
+	return results, ttl, nil
+}
+
+// interval returns the interval to wait before the next DNS query, given the
+// lowest TTL of the records in the last result.
+func (d *DNSTargetDiscoverer) interval(ttl time.Duration) time.Duration {
+	if ttl < 0 {
+		return linger.MustCoalesce(d.QueryInterval, DefaultDNSQueryInterval)
 	}
 
-	return results, err
+	return linger.Limit(
+		ttl,
+		linger.MustCoalesce(d.MinQueryInterval, DefaultDNSMinQueryInterval),
+		linger.MustCoalesce(d.MaxQueryInterval, DefaultDNSMaxQueryInterval),
+	)
 }
 
-// newTarget returns the targets at the given address.
-func (d *DNSTargetDiscoverer) newTargets(ctx context.Context, addr string) ([]Target, error) {
+// newTargets returns the targets for a new key in the query results.
+//
+// key is either a single address, or a host name if d.GroupByHost is true. It
+// returns the gRPC resolver used by the target, if any.
+func (d *DNSTargetDiscoverer) newTargets(
+	ctx context.Context,
+	key string,
+	r *dnsResult,
+) ([]Target, *addressResolver, error) {
+	if r.source.NewTargets != nil {
+		targets, err := r.source.NewTargets(ctx, key)
+		return targets, nil, err
+	}
+
 	if d.NewTargets != nil {
-		return d.NewTargets(ctx, addr)
+		targets, err := d.NewTargets(ctx, key)
+		return targets, nil, err
 	}
 
-	return []Target{
-		{
-			Name: net.JoinHostPort(addr, DefaultGRPCPort),
-		},
-	}, nil
+	var (
+		t   Target
+		res *addressResolver
+	)
+
+	port := dnsPort(r.source)
+
+	if d.GroupByHost {
+		res = newAddressResolver()
+		res.Update(resolverAddresses(r.addrs, port))
+
+		t = Target{
+			Name:        res.Scheme() + ":///" + net.JoinHostPort(key, port),
+			DialOptions: []grpc.DialOption{grpc.WithResolvers(res)},
+		}
+	} else {
+		t = Target{
+			Name: net.JoinHostPort(key, port),
+		}
+	}
+
+	if d.TLS != nil {
+		t.DialOptions = append(t.DialOptions, d.TLS.DialOptions(r.source.Host)...)
+	}
+
+	return []Target{t}, res, nil
+}
+
+// dnsPort returns the port used for targets discovered by querying h.
+func dnsPort(h DNSQueryHost) string {
+	if h.Port != "" {
+		return h.Port
+	}
+
+	return DefaultGRPCPort
+}
+
+// resolverAddresses returns the addresses used by a gRPC resolver for the
+// given host addresses.
+func resolverAddresses(addrs []string, port string) []string {
+	result := make([]string, len(addrs))
+	for i, addr := range addrs {
+		result[i] = net.JoinHostPort(addr, port)
+	}
+	return result
 }
@@ -10,6 +10,8 @@ import (
 	. "github.com/dogmatiq/discoverkit"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gstruct"
+	"golang.org/x/net/dns/dnsmessage"
 )
 
 var _ = Describe("type DNSTargetDiscoverer", func() {
@@ -162,6 +164,642 @@ var _ = Describe("type DNSTargetDiscoverer", func() {
 			Expect(err).To(Equal(context.Canceled))
 		})
 
+		It("uses the TLS configuration to dial the targets", func() {
+			disc.TLS = &TLSConfig{}
+			disc.LookupHost = func(context.Context, string) ([]string, error) {
+				cancel()
+				return []string{"<addr>"}, nil
+			}
+
+			var targets []Target
+
+			err := disc.DiscoverTargets(
+				ctx,
+				func(
+					_ context.Context,
+					t Target,
+				) {
+					targets = append(targets, t)
+				},
+			)
+
+			Expect(err).To(Equal(context.Canceled))
+			Expect(targets).To(ConsistOf(
+				MatchFields(
+					IgnoreExtras,
+					Fields{
+						"Name":        Equal("<addr>:50555"),
+						"DialOptions": HaveLen(2), // credentials and authority
+					},
+				),
+			))
+		})
+
+		When("there is a resolver", func() {
+			var (
+				queries chan struct{}
+				ttl     time.Duration
+			)
+
+			BeforeEach(func() {
+				q := make(chan struct{}, 100)
+				queries = q
+				ttl = time.Hour
+
+				disc.LookupHost = func(context.Context, string) ([]string, error) {
+					return nil, errors.New("unexpected call to LookupHost()")
+				}
+
+				disc.Resolver = &dnsResolverStub{
+					ResolveFunc: func(_ context.Context, host string) ([]DNSRecord, error) {
+						q <- struct{}{}
+
+						return []DNSRecord{
+							{Name: host, Type: DNSRecordTypeCNAME, Value: "<canonical>", TTL: time.Hour},
+							{Name: "<canonical>", Type: DNSRecordTypeA, Value: "<addr-1>", TTL: ttl},
+							{Name: "<canonical>", Type: DNSRecordTypeAAAA, Value: "<addr-2>", TTL: time.Hour},
+						}, nil
+					},
+				}
+			})
+
+			// run starts the discoverer in the background.
+			run := func() {
+				done := make(chan struct{})
+				go func() {
+					defer close(done)
+					disc.DiscoverTargets(ctx, func(context.Context, Target) {})
+				}()
+
+				DeferCleanup(func() {
+					cancel()
+					<-done
+				})
+			}
+
+			It("invokes the observer for each A and AAAA record", func() {
+				var targets []Target
+
+				err := disc.DiscoverTargets(
+					ctx,
+					func(
+						_ context.Context,
+						t Target,
+					) {
+						targets = append(targets, t)
+						if len(targets) == 2 {
+							cancel()
+						}
+					},
+				)
+
+				Expect(err).To(Equal(context.Canceled))
+				Expect(targets).To(ConsistOf(
+					Target{Name: "<addr-1>:50555"},
+					Target{Name: "<addr-2>:50555"},
+				))
+			})
+
+			It("queries again when the lowest TTL expires", func() {
+				ttl = 10 * time.Millisecond
+				disc.MinQueryInterval = time.Millisecond
+
+				run()
+
+				for range 3 {
+					Eventually(queries, 500*time.Millisecond).Should(Receive())
+				}
+			})
+
+			It("does not query more often than the minimum interval", func() {
+				ttl = 0
+				disc.MinQueryInterval = time.Hour
+
+				run()
+
+				Eventually(queries).Should(Receive())
+				Consistently(queries, 100*time.Millisecond).ShouldNot(Receive())
+			})
+
+			It("does not query less often than the maximum interval", func() {
+				disc.MinQueryInterval = time.Millisecond
+				disc.MaxQueryInterval = 10 * time.Millisecond
+
+				run()
+
+				for range 3 {
+					Eventually(queries, 500*time.Millisecond).Should(Receive())
+				}
+			})
+
+			It("ignores not-found errors", func() {
+				disc.Resolver = &dnsResolverStub{
+					ResolveFunc: func(context.Context, string) ([]DNSRecord, error) {
+						cancel()
+						return nil, &net.DNSError{
+							IsNotFound: true,
+						}
+					},
+				}
+
+				err := disc.DiscoverTargets(ctx, nil)
+				Expect(err).To(Equal(context.Canceled))
+			})
+
+			It("can use a DNSClient", func() {
+				server := &dnsServerStub{
+					Records: map[string][]dnsmessage.Resource{
+						"engines.example.org.": {
+							aRecord("engines.example.org.", "192.0.2.1", 60),
+						},
+					},
+				}
+				server.Start()
+
+				disc.QueryHost = "engines.example.org"
+				disc.Resolver = &DNSClient{
+					Nameserver: server.Addr,
+				}
+
+				var targets []Target
+
+				err := disc.DiscoverTargets(
+					ctx,
+					func(
+						_ context.Context,
+						t Target,
+					) {
+						cancel()
+						targets = append(targets, t)
+					},
+				)
+
+				Expect(err).To(Equal(context.Canceled))
+				Expect(targets).To(ConsistOf(
+					Target{Name: "192.0.2.1:50555"},
+				))
+			})
+		})
+
+		When("there is an address family policy", func() {
+			BeforeEach(func() {
+				disc.Resolver = &dnsResolverStub{
+					ResolveFunc: func(context.Context, string) ([]DNSRecord, error) {
+						cancel()
+
+						return []DNSRecord{
+							{Name: "dual.example.org", Type: DNSRecordTypeA, Value: "192.0.2.1"},
+							{Name: "dual.example.org", Type: DNSRecordTypeAAAA, Value: "2001:db8::1"},
+							{Name: "v4.example.org", Type: DNSRecordTypeA, Value: "192.0.2.2"},
+							{Name: "v6.example.org", Type: DNSRecordTypeAAAA, Value: "2001:db8::2"},
+						}, nil
+					},
+				}
+			})
+
+			// discover returns the names of the targets discovered by a single
+			// query.
+			discover := func() []string {
+				var names []string
+
+				err := disc.DiscoverTargets(
+					ctx,
+					func(_ context.Context, t Target) {
+						names = append(names, t.Name)
+					},
+				)
+				Expect(err).To(Equal(context.Canceled))
+
+				return names
+			}
+
+			It("uses both families by default", func() {
+				Expect(discover()).To(ConsistOf(
+					"192.0.2.1:50555",
+					"[2001:db8::1]:50555",
+					"192.0.2.2:50555",
+					"[2001:db8::2]:50555",
+				))
+			})
+
+			It("uses only IPv4 addresses under the IPv4Only policy", func() {
+				disc.AddressFamily = IPv4Only
+				Expect(discover()).To(ConsistOf(
+					"192.0.2.1:50555",
+					"192.0.2.2:50555",
+				))
+			})
+
+			It("uses only IPv6 addresses under the IPv6Only policy", func() {
+				disc.AddressFamily = IPv6Only
+				Expect(discover()).To(ConsistOf(
+					"[2001:db8::1]:50555",
+					"[2001:db8::2]:50555",
+				))
+			})
+
+			It("uses IPv4 addresses of hosts that have them under the PreferIPv4 policy", func() {
+				disc.AddressFamily = PreferIPv4
+				Expect(discover()).To(ConsistOf(
+					"192.0.2.1:50555",
+					"192.0.2.2:50555",
+					"[2001:db8::2]:50555",
+				))
+			})
+
+			It("uses IPv6 addresses of hosts that have them under the PreferIPv6 policy", func() {
+				disc.AddressFamily = PreferIPv6
+				Expect(discover()).To(ConsistOf(
+					"[2001:db8::1]:50555",
+					"192.0.2.2:50555",
+					"[2001:db8::2]:50555",
+				))
+			})
+
+			It("always uses addresses that are not IP addresses", func() {
+				disc.AddressFamily = IPv6Only
+				disc.Resolver = nil
+				disc.LookupHost = func(context.Context, string) ([]string, error) {
+					cancel()
+					return []string{"192.0.2.1", "<addr>"}, nil
+				}
+
+				Expect(discover()).To(ConsistOf("<addr>:50555"))
+			})
+		})
+
+		When("addresses are grouped by host", func() {
+			var (
+				results chan []DNSRecord
+			)
+
+			BeforeEach(func() {
+				r := make(chan []DNSRecord, 10)
+				results = r
+
+				disc.GroupByHost = true
+				disc.QueryInterval = 10 * time.Millisecond
+				disc.MinQueryInterval = 10 * time.Millisecond
+				disc.Resolver = &dnsResolverStub{
+					ResolveFunc: func(ctx context.Context, _ string) ([]DNSRecord, error) {
+						select {
+						case <-ctx.Done():
+							return nil, ctx.Err()
+						case records := <-r:
+							return records, nil
+						}
+					},
+				}
+			})
+
+			It("invokes the observer once for each host", func() {
+				results <- []DNSRecord{
+					{Name: "engine-1.example.org", Type: DNSRecordTypeA, Value: "192.0.2.1"},
+					{Name: "engine-1.example.org", Type: DNSRecordTypeAAAA, Value: "2001:db8::1"},
+					{Name: "engine-2.example.org", Type: DNSRecordTypeA, Value: "192.0.2.2"},
+				}
+
+				var targets []Target
+
+				disc.DiscoverTargets(
+					ctx,
+					func(_ context.Context, t Target) {
+						targets = append(targets, t)
+						if len(targets) == 2 {
+							cancel()
+						}
+					},
+				)
+
+				Expect(targets).To(ConsistOf(
+					MatchFields(
+						IgnoreExtras,
+						Fields{
+							"Name":        HaveSuffix(":///engine-1.example.org:50555"),
+							"DialOptions": HaveLen(1), // resolver
+						},
+					),
+					MatchFields(
+						IgnoreExtras,
+						Fields{
+							"Name":        HaveSuffix(":///engine-2.example.org:50555"),
+							"DialOptions": HaveLen(1), // resolver
+						},
+					),
+				))
+			})
+
+			It("does not cancel the observer context when the host's addresses change", func() {
+				results <- []DNSRecord{
+					{Name: "engine.example.org", Type: DNSRecordTypeA, Value: "192.0.2.1"},
+				}
+				results <- []DNSRecord{
+					{Name: "engine.example.org", Type: DNSRecordTypeA, Value: "192.0.2.2"},
+				}
+
+				targetCtx := make(chan context.Context, 10)
+				done := make(chan struct{})
+
+				go func() {
+					defer close(done)
+					disc.DiscoverTargets(
+						ctx,
+						func(ctx context.Context, _ Target) {
+							targetCtx <- ctx
+						},
+					)
+				}()
+
+				DeferCleanup(func() {
+					cancel()
+					<-done
+				})
+
+				var c context.Context
+				Eventually(targetCtx).Should(Receive(&c))
+				Eventually(results).Should(BeEmpty())
+				Consistently(c.Done(), 50*time.Millisecond).ShouldNot(BeClosed())
+				Expect(targetCtx).NotTo(Receive())
+			})
+
+			It("passes the host name to NewTargets()", func() {
+				results <- []DNSRecord{
+					{Name: "engine.example.org", Type: DNSRecordTypeA, Value: "192.0.2.1"},
+					{Name: "engine.example.org", Type: DNSRecordTypeAAAA, Value: "2001:db8::1"},
+				}
+
+				disc.NewTargets = func(_ context.Context, host string) ([]Target, error) {
+					cancel()
+					return []Target{{Name: host}}, nil
+				}
+
+				var targets []Target
+
+				disc.DiscoverTargets(
+					ctx,
+					func(_ context.Context, t Target) {
+						targets = append(targets, t)
+					},
+				)
+
+				Expect(targets).To(ConsistOf(Target{Name: "engine.example.org"}))
+			})
+		})
+
+		When("there are multiple query hosts", func() {
+			var answers chan map[string][]string
+
+			BeforeEach(func() {
+				a := make(chan map[string][]string, 10)
+				answers = a
+
+				disc.QueryHost = ""
+				disc.QueryInterval = 10 * time.Millisecond
+				disc.QueryHosts = []DNSQueryHost{
+					{Host: "engines.eu"},
+					{Host: "engines.us", Port: "<port>"},
+				}
+
+				// The stub returns the next answer for the first query of each
+				// round of queries, and re-uses it for the remaining hosts.
+				var current map[string][]string
+				disc.LookupHost = func(ctx context.Context, host string) ([]string, error) {
+					if host == "engines.eu" {
+						select {
+						case <-ctx.Done():
+							return nil, ctx.Err()
+						case current = <-a:
+						}
+					}
+
+					if addrs, ok := current[host]; ok {
+						return addrs, nil
+					}
+
+					return nil, &net.DNSError{IsNotFound: true}
+				}
+			})
+
+			It("invokes the observer for the addresses of each host", func() {
+				answers <- map[string][]string{
+					"engines.eu": {"<addr-1>"},
+					"engines.us": {"<addr-2>"},
+				}
+
+				var targets []Target
+
+				disc.DiscoverTargets(
+					ctx,
+					func(_ context.Context, t Target) {
+						targets = append(targets, t)
+						if len(targets) == 2 {
+							cancel()
+						}
+					},
+				)
+
+				Expect(targets).To(ConsistOf(
+					Target{Name: "<addr-1>:50555"},
+					Target{Name: "<addr-2>:<port>"},
+				))
+			})
+
+			It("uses the NewTargets() function of each host", func() {
+				disc.QueryHosts[1].NewTargets = func(_ context.Context, addr string) ([]Target, error) {
+					return []Target{{Name: addr + "-us"}}, nil
+				}
+
+				answers <- map[string][]string{
+					"engines.eu": {"<addr-1>"},
+					"engines.us": {"<addr-2>"},
+				}
+
+				var targets []Target
+
+				disc.DiscoverTargets(
+					ctx,
+					func(_ context.Context, t Target) {
+						targets = append(targets, t)
+						if len(targets) == 2 {
+							cancel()
+						}
+					},
+				)
+
+				Expect(targets).To(ConsistOf(
+					Target{Name: "<addr-1>:50555"},
+					Target{Name: "<addr-2>-us"},
+				))
+			})
+
+			It("reports an address returned by several hosts once, and removes it when no host returns it", func() {
+				answers <- map[string][]string{
+					"engines.eu": {"<addr>"},
+					"engines.us": {"<addr>"},
+				}
+				answers <- map[string][]string{
+					"engines.us": {"<addr>"},
+				}
+
+				targets := make(chan Target, 10)
+				canceled := make(chan struct{})
+				done := make(chan struct{})
+
+				go func() {
+					defer close(done)
+					disc.DiscoverTargets(
+						ctx,
+						func(targetCtx context.Context, t Target) {
+							targets <- t
+
+							go func() {
+								<-targetCtx.Done()
+								close(canceled)
+							}()
+						},
+					)
+				}()
+
+				DeferCleanup(func() {
+					cancel()
+					<-done
+				})
+
+				Eventually(targets).Should(Receive(Equal(Target{Name: "<addr>:50555"})))
+				Eventually(answers).Should(BeEmpty())
+				Consistently(canceled, 50*time.Millisecond).ShouldNot(BeClosed())
+				Expect(targets).NotTo(Receive())
+
+				answers <- map[string][]string{}
+				Eventually(canceled).Should(BeClosed())
+			})
+
+			It("queries QueryHost before the hosts in QueryHosts", func() {
+				disc.QueryHost = "<query-host>"
+
+				var hosts []string
+				disc.LookupHost = func(_ context.Context, host string) ([]string, error) {
+					hosts = append(hosts, host)
+					if len(hosts) == 3 {
+						cancel()
+					}
+					return nil, nil
+				}
+
+				err := disc.DiscoverTargets(ctx, nil)
+				Expect(err).To(Equal(context.Canceled))
+				Expect(hosts).To(Equal([]string{"<query-host>", "engines.eu", "engines.us"}))
+			})
+		})
+
+		When("there is hysteresis", func() {
+			var (
+				answers  chan []string
+				targets  chan Target
+				canceled chan struct{}
+			)
+
+			BeforeEach(func() {
+				a := make(chan []string, 10)
+				answers = a
+				targets = make(chan Target, 10)
+				canceled = make(chan struct{})
+
+				disc.QueryInterval = 10 * time.Millisecond
+				disc.LookupHost = func(ctx context.Context, _ string) ([]string, error) {
+					select {
+					case <-ctx.Done():
+						return nil, ctx.Err()
+					case addrs := <-a:
+						return addrs, nil
+					}
+				}
+			})
+
+			// run starts the discoverer in the background.
+			run := func() {
+				targets := targets
+				canceled := canceled
+				done := make(chan struct{})
+
+				go func() {
+					defer close(done)
+					disc.DiscoverTargets(
+						ctx,
+						func(targetCtx context.Context, t Target) {
+							targets <- t
+
+							go func() {
+								<-targetCtx.Done()
+								close(canceled)
+							}()
+						},
+					)
+				}()
+
+				DeferCleanup(func() {
+					cancel()
+					<-done
+				})
+			}
+
+			It("removes an address after it is absent from RemoveAfterMisses consecutive results", func() {
+				disc.RemoveAfterMisses = 2
+				run()
+
+				answers <- []string{"<addr>"}
+				answers <- []string{}
+				answers <- []string{"<addr>"}
+				answers <- []string{}
+
+				Eventually(targets).Should(Receive())
+				Eventually(answers).Should(BeEmpty())
+				Consistently(canceled, 50*time.Millisecond).ShouldNot(BeClosed())
+
+				answers <- []string{}
+				Eventually(canceled).Should(BeClosed())
+				Expect(targets).NotTo(Receive())
+			})
+
+			It("removes an address after it is absent for the RemoveAfter duration", func() {
+				disc.RemoveAfter = 200 * time.Millisecond
+				run()
+
+				answers <- []string{"<addr>"}
+				Eventually(targets).Should(Receive())
+
+				ctx, answers := ctx, answers
+				go func() {
+					for {
+						select {
+						case <-ctx.Done():
+							return
+						case answers <- []string{}:
+						}
+					}
+				}()
+
+				start := time.Now()
+				Eventually(canceled).Should(BeClosed())
+				Expect(time.Since(start)).To(BeNumerically(">=", 200*time.Millisecond))
+			})
+
+			It("adds an address after it appears in AddAfterHits consecutive results", func() {
+				disc.AddAfterHits = 2
+				run()
+
+				answers <- []string{"<addr>"}
+				answers <- []string{}
+				answers <- []string{"<addr>"}
+
+				Eventually(answers).Should(BeEmpty())
+				Consistently(targets, 50*time.Millisecond).ShouldNot(Receive())
+
+				answers <- []string{"<addr>"}
+				Eventually(targets).Should(Receive(Equal(Target{Name: "<addr>:50555"})))
+			})
+		})
+
 		When("there is a NewTargets() function", func() {
 			It("passes the targets returned by NewTargets() to the observer", func() {
 				disc.NewTargets = func(_ context.Context, addr string) ([]Target, error) {
@@ -290,6 +928,142 @@ var _ = Describe("type DNSTargetDiscoverer", func() {
 				err := disc.DiscoverTargets(ctx, nil)
 				Expect(err).To(MatchError("<error>"))
 			})
+
+			It("logs temporary and not-found errors", func() {
+				var errs []error
+				disc.LogError = func(host string, err error) {
+					Expect(host).To(Equal("<query-host>"))
+					errs = append(errs, err)
+				}
+
+				disc.QueryInterval = time.Millisecond
+				disc.LookupHost = func(context.Context, string) ([]string, error) {
+					disc.LookupHost = func(context.Context, string) ([]string, error) {
+						cancel()
+						return nil, &net.DNSError{Err: "<not-found>", IsNotFound: true}
+					}
+
+					return nil, &net.DNSError{Err: "<temporary>", IsTemporary: true}
+				}
+
+				disc.DiscoverTargets(ctx, nil)
+				Expect(errs).To(HaveLen(1)) // the not-found error occurs after cancelation
+				Expect(errs[0]).To(MatchError(ContainSubstring("<temporary>")))
+			})
+
+			When("a query fails with a temporary error", func() {
+				var (
+					answers  chan error
+					canceled chan struct{}
+				)
+
+				BeforeEach(func() {
+					a := make(chan error, 10)
+					answers = a
+					canceled = make(chan struct{}, 10)
+
+					disc.QueryInterval = time.Millisecond
+					disc.LookupHost = func(ctx context.Context, _ string) ([]string, error) {
+						select {
+						case <-ctx.Done():
+							return nil, ctx.Err()
+						case err := <-a:
+							if err != nil {
+								return nil, err
+							}
+							return []string{"<addr>"}, nil
+						}
+					}
+				})
+
+				// run starts the discoverer in the background and returns a
+				// channel that receives its result.
+				run := func() <-chan error {
+					canceled := canceled
+					result := make(chan error, 1)
+					done := make(chan struct{})
+
+					go func() {
+						defer close(done)
+						result <- disc.DiscoverTargets(
+							ctx,
+							func(targetCtx context.Context, _ Target) {
+								go func() {
+									<-targetCtx.Done()
+									canceled <- struct{}{}
+								}()
+							},
+						)
+					}()
+
+					DeferCleanup(func() {
+						cancel()
+						<-done
+					})
+
+					return result
+				}
+
+				temporary := &net.DNSError{Err: "<temporary>", IsTemporary: true}
+
+				It("treats the host as having no addresses by default", func() {
+					run()
+
+					answers <- nil
+					answers <- temporary
+					Eventually(canceled).Should(Receive())
+				})
+
+				It("keeps the last successful result if KeepResultsOnFailure is true", func() {
+					disc.KeepResultsOnFailure = true
+					run()
+
+					answers <- nil
+					answers <- temporary
+					answers <- temporary
+					Eventually(answers).Should(BeEmpty())
+					Consistently(canceled, 50*time.Millisecond).ShouldNot(Receive())
+				})
+
+				It("returns an error after MaxQueryFailures consecutive failures", func() {
+					disc.KeepResultsOnFailure = true
+					disc.MaxQueryFailures = 2
+					result := run()
+
+					answers <- temporary
+					answers <- nil
+					answers <- temporary
+					answers <- nil
+					answers <- temporary
+					Eventually(answers).Should(BeEmpty())
+					Consistently(result, 50*time.Millisecond).ShouldNot(Receive())
+
+					answers <- temporary
+
+					var err error
+					Eventually(result).Should(Receive(&err))
+					Expect(err).To(MatchError(
+						"unable to query <query-host>: 2 consecutive queries have failed: lookup : <temporary>",
+					))
+
+					var watchErr *WatchError
+					Expect(errors.As(err, &watchErr)).To(BeTrue())
+					Expect(watchErr.Phase).To(Equal(WatchPhaseQuery))
+					Expect(watchErr.Target).To(Equal(Target{Name: "<query-host>"}))
+				})
+			})
 		})
 	})
 })
+
+type dnsResolverStub struct {
+	ResolveFunc func(context.Context, string) ([]DNSRecord, error)
+}
+
+func (s *dnsResolverStub) Resolve(ctx context.Context, host string) ([]DNSRecord, error) {
+	if s.ResolveFunc != nil {
+		return s.ResolveFunc(ctx, host)
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,202 @@
+package discoverkit
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"google.golang.org/grpc/resolver"
+)
+
+// AddressFamilyPolicy is a policy that determines which IP address families
+// are used by a DNSTargetDiscoverer.
+type AddressFamilyPolicy int
+
+const (
+	// BothAddressFamilies uses both IPv4 and IPv6 addresses.
+	BothAddressFamilies AddressFamilyPolicy = iota
+
+	// IPv4Only uses only IPv4 addresses.
+	IPv4Only
+
+	// IPv6Only uses only IPv6 addresses.
+	IPv6Only
+
+	// PreferIPv4 uses the IPv4 addresses of each host that has any, and the
+	// IPv6 addresses of any other host.
+	PreferIPv4
+
+	// PreferIPv6 uses the IPv6 addresses of each host that has any, and the
+	// IPv4 addresses of any other host.
+	PreferIPv6
+)
+
+// String returns a human-readable representation of the policy.
+func (p AddressFamilyPolicy) String() string {
+	switch p {
+	case BothAddressFamilies:
+		return "both"
+	case IPv4Only:
+		return "ipv4-only"
+	case IPv6Only:
+		return "ipv6-only"
+	case PreferIPv4:
+		return "prefer-ipv4"
+	case PreferIPv6:
+		return "prefer-ipv6"
+	default:
+		return "unknown"
+	}
+}
+
+// dnsAddress is an address discovered by a DNS query.
+type dnsAddress struct {
+	// host is the owner name of the record that contained the address.
+	host string
+
+	// addr is the address itself, in lowercase. It may be a hostname or an IP
+	// address.
+	addr string
+}
+
+// addressFamily returns 4 if addr is an IPv4 address, 6 if it is an IPv6
+// address, and 0 otherwise.
+func addressFamily(addr string) int {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return 0
+	}
+
+	if ip.To4() != nil {
+		return 4
+	}
+
+	return 6
+}
+
+// apply returns the addresses that are used under the policy.
+//
+// Addresses that are not IP addresses are always used.
+func (p AddressFamilyPolicy) apply(addrs []dnsAddress) []dnsAddress {
+	if p == BothAddressFamilies {
+		return addrs
+	}
+
+	preferred, other := 4, 6
+
+	switch p {
+	case IPv6Only, PreferIPv6:
+		preferred, other = 6, 4
+	}
+
+	// Find the hosts that have any addresses in the preferred family.
+	hasPreferred := map[string]bool{}
+	for _, a := range addrs {
+		if addressFamily(a.addr) == preferred {
+			hasPreferred[a.host] = true
+		}
+	}
+
+	var result []dnsAddress
+
+	for _, a := range addrs {
+		switch addressFamily(a.addr) {
+		case preferred:
+		case other:
+			if p == IPv4Only || p == IPv6Only || hasPreferred[a.host] {
+				continue
+			}
+		}
+
+		result = append(result, a)
+	}
+
+	return result
+}
+
+// addressResolverSeq is used to generate unique gRPC resolver schemes.
+var addressResolverSeq atomic.Uint64
+
+// addressResolver is a gRPC resolver.Builder that resolves a target to a set
+// of addresses that is updated by a DNSTargetDiscoverer.
+//
+// Unlike the resolver in the google.golang.org/grpc/resolver/manual package,
+// it may be used by any number of client connections, which allows the same
+// Target to be dialed repeatedly.
+type addressResolver struct {
+	scheme string
+
+	m         sync.Mutex
+	addresses []resolver.Address
+	conns     map[*addressResolverConn]struct{}
+}
+
+// newAddressResolver returns a new resolver with a unique scheme.
+func newAddressResolver() *addressResolver {
+	return &addressResolver{
+		scheme: "discoverkit-dns-" + strconv.FormatUint(addressResolverSeq.Add(1), 10),
+		conns:  map[*addressResolverConn]struct{}{},
+	}
+}
+
+// Scheme returns the resolver's scheme.
+func (r *addressResolver) Scheme() string {
+	return r.scheme
+}
+
+// Build returns a resolver for a new client connection.
+func (r *addressResolver) Build(
+	_ resolver.Target,
+	cc resolver.ClientConn,
+	_ resolver.BuildOptions,
+) (resolver.Resolver, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	c := &addressResolverConn{r, cc}
+	r.conns[c] = struct{}{}
+	cc.UpdateState(resolver.State{Addresses: r.addresses})
+
+	return c, nil
+}
+
+// Update sets the addresses that the target resolves to. Each address is a
+// host and port pair.
+func (r *addressResolver) Update(addrs []string) {
+	sort.Strings(addrs)
+
+	addresses := make([]resolver.Address, len(addrs))
+	for i, addr := range addrs {
+		addresses[i] = resolver.Address{Addr: addr}
+	}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	r.addresses = addresses
+
+	for c := range r.conns {
+		c.cc.UpdateState(resolver.State{Addresses: addresses})
+	}
+}
+
+// addressResolverConn is the resolver.Resolver used by a single client
+// connection.
+type addressResolverConn struct {
+	r  *addressResolver
+	cc resolver.ClientConn
+}
+
+// ResolveNow is a no-op, the addresses are updated as each DNS query is
+// performed.
+func (c *addressResolverConn) ResolveNow(resolver.ResolveNowOptions) {}
+
+// Close stops sending address updates to the client connection.
+func (c *addressResolverConn) Close() {
+	c.r.m.Lock()
+	defer c.r.m.Unlock()
+
+	delete(c.r.conns, c)
+}
@@ -0,0 +1,90 @@
+package discoverkit_test
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/dogmatiq/configkit"
+	. "github.com/dogmatiq/discoverkit"
+	"github.com/dogmatiq/interopspec/discoverspec"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+var _ = Describe("type AddressFamilyPolicy", func() {
+	Describe("func String()", func() {
+		It("returns a human-readable representation of the policy", func() {
+			Expect(BothAddressFamilies.String()).To(Equal("both"))
+			Expect(IPv4Only.String()).To(Equal("ipv4-only"))
+			Expect(IPv6Only.String()).To(Equal("ipv6-only"))
+			Expect(PreferIPv4.String()).To(Equal("prefer-ipv4"))
+			Expect(PreferIPv6.String()).To(Equal("prefer-ipv6"))
+			Expect(AddressFamilyPolicy(-1).String()).To(Equal("unknown"))
+		})
+	})
+})
+
+var _ = Describe("type DNSTargetDiscoverer (grouped by host)", func() {
+	It("produces targets that can be dialed repeatedly", func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:"+DefaultGRPCPort)
+		if err != nil {
+			Skip("the default gRPC port is not available: " + err.Error())
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		server := &Server{}
+		server.Available(configkit.MustNewIdentity("<app-name>", appKey))
+
+		gserver := grpc.NewServer()
+		discoverspec.RegisterDiscoverAPIServer(gserver, server)
+		go gserver.Serve(listener)
+		defer gserver.Stop()
+
+		disc := &DNSTargetDiscoverer{
+			QueryHost:   "engine.example.org",
+			GroupByHost: true,
+			LookupHost: func(context.Context, string) ([]string, error) {
+				return []string{"127.0.0.1"}, nil
+			},
+		}
+
+		targets := make(chan Target, 1)
+		go disc.DiscoverTargets(
+			ctx,
+			func(_ context.Context, t Target) {
+				targets <- t
+			},
+		)
+
+		var t Target
+		Eventually(targets).Should(Receive(&t))
+
+		for range 2 {
+			conn, err := grpc.NewClient(
+				t.Name,
+				append(
+					t.DialOptions,
+					grpc.WithTransportCredentials(insecure.NewCredentials()),
+				)...,
+			)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			stream, err := discoverspec.NewDiscoverAPIClient(conn).WatchApplications(
+				ctx,
+				&discoverspec.WatchApplicationsRequest{},
+			)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			res, err := stream.Recv()
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(res.GetIdentity().GetName()).To(Equal("<app-name>"))
+
+			conn.Close()
+		}
+	})
+})
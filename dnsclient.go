@@ -0,0 +1,336 @@
+package discoverkit
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/dogmatiq/linger"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// DefaultDNSClientTimeout is the default timeout for each query performed
+	// by a DNSClient.
+	DefaultDNSClientTimeout = 5 * time.Second
+)
+
+// DNSRecordType is the type of a DNS resource record.
+type DNSRecordType string
+
+const (
+	// DNSRecordTypeA is the type of a DNS record that maps a name to an IPv4
+	// address.
+	DNSRecordTypeA DNSRecordType = "A"
+
+	// DNSRecordTypeAAAA is the type of a DNS record that maps a name to an
+	// IPv6 address.
+	DNSRecordTypeAAAA DNSRecordType = "AAAA"
+
+	// DNSRecordTypeCNAME is the type of a DNS record that maps a name to its
+	// canonical name.
+	DNSRecordTypeCNAME DNSRecordType = "CNAME"
+)
+
+// DNSRecord is a DNS resource record returned by a DNSResolver.
+type DNSRecord struct {
+	// Name is the owner name of the record, in lowercase and without a
+	// trailing dot.
+	Name string
+
+	// Type is the type of the record.
+	Type DNSRecordType
+
+	// Value is the IP address of an A or AAAA record, or the canonical name of
+	// a CNAME record.
+	Value string
+
+	// TTL is the record's time-to-live.
+	TTL time.Duration
+}
+
+// DNSResolver is an interface for resolving a hostname to its A, AAAA and
+// CNAME records.
+type DNSResolver interface {
+	// Resolve returns the A, AAAA and CNAME records that are found when
+	// querying host, including the CNAME records of any aliases that are
+	// followed.
+	//
+	// It returns a *net.DNSError with IsNotFound set to true if host does not
+	// exist, and with IsTemporary set to true if the failure may be resolved
+	// by retrying.
+	Resolve(ctx context.Context, host string) ([]DNSRecord, error)
+}
+
+// DNSClient is a DNSResolver that queries a specific nameserver directly.
+//
+// Unlike the resolver in the net package, it reports the TTL of each record,
+// which allows a DNSTargetDiscoverer to schedule its queries accordingly.
+type DNSClient struct {
+	// Nameserver is the address of the nameserver to query, such as
+	// "10.0.0.2:53". If the port is omitted, port 53 is used.
+	Nameserver string
+
+	// Timeout is the maximum duration of each query.
+	//
+	// If it is non-positive, the DefaultDNSClientTimeout constant is used.
+	Timeout time.Duration
+}
+
+var _ DNSResolver = (*DNSClient)(nil)
+
+// Resolve returns the A, AAAA and CNAME records that are found when querying
+// host.
+//
+// Queries are sent over UDP, and are retried over TCP if the response is
+// truncated.
+func (c *DNSClient) Resolve(ctx context.Context, host string) ([]DNSRecord, error) {
+	if c.Nameserver == "" {
+		return nil, errors.New("DNS client nameserver must not be empty")
+	}
+
+	server := c.Nameserver
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+
+	fqdn := host
+	if !strings.HasSuffix(fqdn, ".") {
+		fqdn += "."
+	}
+
+	name, err := dnsmessage.NewName(fqdn)
+	if err != nil {
+		return nil, &net.DNSError{
+			Err:    err.Error(),
+			Name:   host,
+			Server: server,
+		}
+	}
+
+	var (
+		records []DNSRecord
+		aliases = map[string]struct{}{}
+	)
+
+	for _, t := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+		answers, err := c.query(ctx, server, host, name, t)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, r := range answers {
+			if r.Type == DNSRecordTypeCNAME {
+				// CNAME records are returned in the answers for both types
+				// of query, only report them once.
+				k := r.Name + " " + r.Value
+				if _, ok := aliases[k]; ok {
+					continue
+				}
+				aliases[k] = struct{}{}
+			}
+
+			records = append(records, r)
+		}
+	}
+
+	return records, nil
+}
+
+// query sends a single query to the nameserver and returns the records in its
+// answer section.
+func (c *DNSClient) query(
+	ctx context.Context,
+	server, host string,
+	name dnsmessage.Name,
+	t dnsmessage.Type,
+) ([]DNSRecord, error) {
+	ctx, cancel := context.WithTimeout(
+		ctx,
+		linger.MustCoalesce(c.Timeout, DefaultDNSClientTimeout),
+	)
+	defer cancel()
+
+	id := uint16(rand.Uint32())
+
+	req, err := (&dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID:               id,
+			RecursionDesired: true,
+		},
+		Questions: []dnsmessage.Question{
+			{Name: name, Type: t, Class: dnsmessage.ClassINET},
+		},
+	}).Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := exchangeDNS(ctx, "udp", server, id, req)
+	if err == nil && res.Header.Truncated {
+		res, err = exchangeDNS(ctx, "tcp", server, id, req)
+	}
+	if err != nil {
+		return nil, &net.DNSError{
+			Err:         err.Error(),
+			Name:        host,
+			Server:      server,
+			IsTimeout:   errors.Is(ctx.Err(), context.DeadlineExceeded) || isTimeout(err),
+			IsTemporary: true,
+		}
+	}
+
+	switch res.Header.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return nil, &net.DNSError{
+			Err:        "no such host",
+			Name:       host,
+			Server:     server,
+			IsNotFound: true,
+		}
+	case dnsmessage.RCodeServerFailure:
+		return nil, &net.DNSError{
+			Err:         "server misbehaving",
+			Name:        host,
+			Server:      server,
+			IsTemporary: true,
+		}
+	default:
+		return nil, &net.DNSError{
+			Err:    fmt.Sprintf("unexpected response code: %s", res.Header.RCode),
+			Name:   host,
+			Server: server,
+		}
+	}
+
+	var records []DNSRecord
+
+	for _, a := range res.Answers {
+		r := DNSRecord{
+			Name: dnsName(a.Header.Name),
+			TTL:  time.Duration(a.Header.TTL) * time.Second,
+		}
+
+		switch b := a.Body.(type) {
+		case *dnsmessage.AResource:
+			r.Type = DNSRecordTypeA
+			r.Value = net.IP(b.A[:]).String()
+		case *dnsmessage.AAAAResource:
+			r.Type = DNSRecordTypeAAAA
+			r.Value = net.IP(b.AAAA[:]).String()
+		case *dnsmessage.CNAMEResource:
+			r.Type = DNSRecordTypeCNAME
+			r.Value = dnsName(b.CNAME)
+		default:
+			continue
+		}
+
+		records = append(records, r)
+	}
+
+	return records, nil
+}
+
+// exchangeDNS sends a DNS request to a server using the given network and
+// returns the response with the matching ID.
+func exchangeDNS(
+	ctx context.Context,
+	network, server string,
+	id uint16,
+	req []byte,
+) (*dnsmessage.Message, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	// Close the connection if ctx is canceled to abort any blocking reads or
+	// writes.
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+	defer stop()
+
+	if network == "tcp" {
+		return exchangeDNSStream(conn, id, req)
+	}
+
+	if _, err := conn.Write(req); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 65535)
+
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+
+		var res dnsmessage.Message
+		if err := res.Unpack(buf[:n]); err != nil || res.Header.ID != id {
+			// Ignore malformed or unrelated packets, as per RFC 5452.
+			continue
+		}
+
+		return &res, nil
+	}
+}
+
+// exchangeDNSStream sends a DNS request over a stream-oriented connection, on
+// which each message is prefixed by its length.
+func exchangeDNSStream(conn net.Conn, id uint16, req []byte) (*dnsmessage.Message, error) {
+	data := make([]byte, 2+len(req))
+	binary.BigEndian.PutUint16(data, uint16(len(req)))
+	copy(data[2:], req)
+
+	if _, err := conn.Write(data); err != nil {
+		return nil, err
+	}
+
+	var size [2]byte
+	if _, err := io.ReadFull(conn, size[:]); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, binary.BigEndian.Uint16(size[:]))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return nil, err
+	}
+
+	var res dnsmessage.Message
+	if err := res.Unpack(buf); err != nil {
+		return nil, err
+	}
+
+	if res.Header.ID != id {
+		return nil, errors.New("DNS response ID does not match the request")
+	}
+
+	return &res, nil
+}
+
+// dnsName returns the string representation of n, in lowercase and without
+// the trailing dot.
+func dnsName(n dnsmessage.Name) string {
+	return strings.ToLower(strings.TrimSuffix(n.String(), "."))
+}
+
+// isTimeout returns true if err is a network timeout.
+func isTimeout(err error) bool {
+	var x net.Error
+	return errors.As(err, &x) && x.Timeout()
+}
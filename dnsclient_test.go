@@ -0,0 +1,347 @@
+package discoverkit_test
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	. "github.com/dogmatiq/discoverkit"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+var _ = Describe("type DNSClient", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+		server *dnsServerStub
+		client *DNSClient
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 2*time.Second)
+		DeferCleanup(cancel)
+
+		server = &dnsServerStub{
+			Records: map[string][]dnsmessage.Resource{
+				"alias.example.org.": {
+					cnameRecord("alias.example.org.", "host.example.org.", 300),
+				},
+				"host.example.org.": {
+					aRecord("host.example.org.", "192.0.2.1", 60),
+					aaaaRecord("host.example.org.", "2001:db8::1", 30),
+				},
+			},
+		}
+	})
+
+	JustBeforeEach(func() {
+		server.Start()
+
+		client = &DNSClient{
+			Nameserver: server.Addr,
+		}
+	})
+
+	Describe("func Resolve()", func() {
+		It("returns the A and AAAA records for the host", func() {
+			records, err := client.Resolve(ctx, "host.example.org")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(records).To(ConsistOf(
+				DNSRecord{
+					Name:  "host.example.org",
+					Type:  DNSRecordTypeA,
+					Value: "192.0.2.1",
+					TTL:   60 * time.Second,
+				},
+				DNSRecord{
+					Name:  "host.example.org",
+					Type:  DNSRecordTypeAAAA,
+					Value: "2001:db8::1",
+					TTL:   30 * time.Second,
+				},
+			))
+		})
+
+		It("returns the CNAME records of aliases exactly once", func() {
+			records, err := client.Resolve(ctx, "ALIAS.example.org.")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(records).To(ConsistOf(
+				DNSRecord{
+					Name:  "alias.example.org",
+					Type:  DNSRecordTypeCNAME,
+					Value: "host.example.org",
+					TTL:   300 * time.Second,
+				},
+				DNSRecord{
+					Name:  "host.example.org",
+					Type:  DNSRecordTypeA,
+					Value: "192.0.2.1",
+					TTL:   60 * time.Second,
+				},
+				DNSRecord{
+					Name:  "host.example.org",
+					Type:  DNSRecordTypeAAAA,
+					Value: "2001:db8::1",
+					TTL:   30 * time.Second,
+				},
+			))
+		})
+
+		When("the UDP response is truncated", func() {
+			BeforeEach(func() {
+				server.Truncate = true
+			})
+
+			It("retries the query over TCP", func() {
+				records, err := client.Resolve(ctx, "host.example.org")
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(records).To(HaveLen(2))
+			})
+		})
+
+		It("uses port 53 if the nameserver address has no port", func() {
+			client.Nameserver = "192.0.2.53"
+			client.Timeout = 10 * time.Millisecond
+
+			_, err := client.Resolve(ctx, "host.example.org")
+			Expect(err).To(MatchError(ContainSubstring("192.0.2.53:53")))
+		})
+
+		It("returns a not-found error if the host does not exist", func() {
+			_, err := client.Resolve(ctx, "missing.example.org")
+
+			var x *net.DNSError
+			Expect(err).To(BeAssignableToTypeOf(x))
+			x = err.(*net.DNSError)
+			Expect(x.IsNotFound).To(BeTrue())
+			Expect(x.Name).To(Equal("missing.example.org"))
+		})
+
+		When("the server fails", func() {
+			BeforeEach(func() {
+				server.RCode = dnsmessage.RCodeServerFailure
+			})
+
+			It("returns a temporary error", func() {
+				_, err := client.Resolve(ctx, "host.example.org")
+
+				var x *net.DNSError
+				Expect(err).To(BeAssignableToTypeOf(x))
+				x = err.(*net.DNSError)
+				Expect(x.IsTemporary).To(BeTrue())
+				Expect(x.IsNotFound).To(BeFalse())
+			})
+		})
+
+		It("returns a timeout error if the server does not respond", func() {
+			conn, err := net.ListenPacket("udp", "127.0.0.1:")
+			Expect(err).ShouldNot(HaveOccurred())
+			defer conn.Close()
+
+			client.Nameserver = conn.LocalAddr().String()
+			client.Timeout = 20 * time.Millisecond
+
+			_, err = client.Resolve(ctx, "host.example.org")
+
+			var x *net.DNSError
+			Expect(err).To(BeAssignableToTypeOf(x))
+			x = err.(*net.DNSError)
+			Expect(x.IsTimeout).To(BeTrue())
+			Expect(x.IsTemporary).To(BeTrue())
+		})
+
+		It("returns an error if the nameserver is empty", func() {
+			client.Nameserver = ""
+
+			_, err := client.Resolve(ctx, "host.example.org")
+			Expect(err).To(MatchError("DNS client nameserver must not be empty"))
+		})
+	})
+})
+
+// dnsServerStub is an in-process DNS server that answers queries over UDP and
+// TCP from a static set of records.
+//
+// Its fields must not be modified after it is started.
+type dnsServerStub struct {
+	// Records maps each fully-qualified lowercase name to its records.
+	Records map[string][]dnsmessage.Resource
+
+	// RCode, if non-zero, is returned in place of any records.
+	RCode dnsmessage.RCode
+
+	// Truncate causes all UDP responses to be truncated.
+	Truncate bool
+
+	// Addr is the address of the server, set by Start().
+	Addr string
+}
+
+// Start starts the server. It is stopped when the current spec ends.
+func (s *dnsServerStub) Start() {
+	var (
+		pc  net.PacketConn
+		l   net.Listener
+		err error
+	)
+
+	// The UDP and TCP listeners must share a port. The port chosen for UDP
+	// may already be in use for TCP, in which case we try another.
+	for range 10 {
+		pc, err = net.ListenPacket("udp", "127.0.0.1:")
+		Expect(err).ShouldNot(HaveOccurred())
+
+		l, err = net.Listen("tcp", pc.LocalAddr().String())
+		if err == nil {
+			break
+		}
+
+		pc.Close()
+	}
+	Expect(err).ShouldNot(HaveOccurred())
+	DeferCleanup(pc.Close)
+	DeferCleanup(l.Close)
+
+	s.Addr = pc.LocalAddr().String()
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+
+			if res := s.respond(buf[:n], s.Truncate); res != nil {
+				pc.WriteTo(res, addr)
+			}
+		}
+	}()
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+
+				var size [2]byte
+				if _, err := io.ReadFull(conn, size[:]); err != nil {
+					return
+				}
+
+				req := make([]byte, binary.BigEndian.Uint16(size[:]))
+				if _, err := io.ReadFull(conn, req); err != nil {
+					return
+				}
+
+				res := s.respond(req, false)
+				binary.BigEndian.PutUint16(size[:], uint16(len(res)))
+				conn.Write(append(size[:], res...))
+			}()
+		}
+	}()
+}
+
+// respond returns the response to a DNS request.
+func (s *dnsServerStub) respond(data []byte, truncate bool) []byte {
+	var req dnsmessage.Message
+	if err := req.Unpack(data); err != nil || len(req.Questions) != 1 {
+		return nil
+	}
+
+	q := req.Questions[0]
+	res := dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID:                 req.Header.ID,
+			Response:           true,
+			RecursionDesired:   req.Header.RecursionDesired,
+			RecursionAvailable: true,
+			RCode:              s.RCode,
+			Truncated:          truncate,
+		},
+		Questions: req.Questions,
+	}
+
+	if s.RCode == dnsmessage.RCodeSuccess && !truncate {
+		name := strings.ToLower(q.Name.String())
+
+		if _, ok := s.Records[name]; !ok {
+			res.Header.RCode = dnsmessage.RCodeNameError
+		}
+
+		// Follow CNAME records, as a recursive resolver would.
+		for name != "" {
+			next := ""
+
+			for _, r := range s.Records[name] {
+				switch b := r.Body.(type) {
+				case *dnsmessage.CNAMEResource:
+					res.Answers = append(res.Answers, r)
+					next = strings.ToLower(b.CNAME.String())
+				default:
+					if r.Header.Type == q.Type {
+						res.Answers = append(res.Answers, r)
+					}
+				}
+			}
+
+			name = next
+		}
+	}
+
+	data, err := res.Pack()
+	if err != nil {
+		panic(err)
+	}
+
+	return data
+}
+
+// aRecord returns an A record.
+func aRecord(name, ip string, ttl uint32) dnsmessage.Resource {
+	var a [4]byte
+	copy(a[:], net.ParseIP(ip).To4())
+
+	return dnsmessage.Resource{
+		Header: dnsResourceHeader(name, dnsmessage.TypeA, ttl),
+		Body:   &dnsmessage.AResource{A: a},
+	}
+}
+
+// aaaaRecord returns an AAAA record.
+func aaaaRecord(name, ip string, ttl uint32) dnsmessage.Resource {
+	var a [16]byte
+	copy(a[:], net.ParseIP(ip).To16())
+
+	return dnsmessage.Resource{
+		Header: dnsResourceHeader(name, dnsmessage.TypeAAAA, ttl),
+		Body:   &dnsmessage.AAAAResource{AAAA: a},
+	}
+}
+
+// cnameRecord returns a CNAME record.
+func cnameRecord(name, target string, ttl uint32) dnsmessage.Resource {
+	return dnsmessage.Resource{
+		Header: dnsResourceHeader(name, dnsmessage.TypeCNAME, ttl),
+		Body:   &dnsmessage.CNAMEResource{CNAME: dnsmessage.MustNewName(target)},
+	}
+}
+
+// dnsResourceHeader returns the header of a DNS record.
+func dnsResourceHeader(name string, t dnsmessage.Type, ttl uint32) dnsmessage.ResourceHeader {
+	return dnsmessage.ResourceHeader{
+		Name:  dnsmessage.MustNewName(name),
+		Type:  t,
+		Class: dnsmessage.ClassINET,
+		TTL:   ttl,
+	}
+}
@@ -0,0 +1,462 @@
+package discoverkit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/dogmatiq/linger"
+	"github.com/dogmatiq/linger/backoff"
+	"google.golang.org/grpc"
+)
+
+const (
+	// DefaultDockerSocket is the default path of the unix socket used to
+	// communicate with the Docker Engine API.
+	DefaultDockerSocket = "/var/run/docker.sock"
+
+	// DefaultDockerLabel is the default label used to identify Docker
+	// containers that are valid gRPC targets.
+	DefaultDockerLabel = "dogma.discover=true"
+
+	// DefaultDockerPortLabel is the default name of the container label that
+	// contains the gRPC port.
+	DefaultDockerPortLabel = "dogma.port"
+)
+
+// DockerTargetDiscoverer discovers gRPC targets by querying the Docker Engine
+// API for running containers with a specific label.
+type DockerTargetDiscoverer struct {
+	// Socket is the path of the unix socket used to communicate with the
+	// Docker Engine API.
+	//
+	// If it is empty, DefaultDockerSocket is used.
+	Socket string
+
+	// Label is the label used to identify containers that are gRPC targets. It
+	// is either a label name, or a name and value of the form "name=value".
+	//
+	// If it is empty, DefaultDockerLabel is used.
+	Label string
+
+	// PortLabel is the name of the container label that contains the gRPC
+	// port.
+	//
+	// If it is empty, DefaultDockerPortLabel is used. If a container does not
+	// have this label, the DefaultGRPCPort constant is used.
+	PortLabel string
+
+	// Network is the name of the Docker network that provides the IP address
+	// of each container.
+	//
+	// If it is empty, the first network (by name) that provides an IP address
+	// is used.
+	Network string
+
+	// DialOptions returns the dial options used to dial the given address.
+	DialOptions func(addr string) []grpc.DialOption
+
+	// TLS is the TLS configuration used to dial the discovered targets.
+	//
+	// The server certificate is verified against the name of the container,
+	// not its IP address. If TLS is nil, only the options returned by
+	// DialOptions are used.
+	TLS *TLSConfig
+
+	// BackoffStrategy is the strategy that determines when to retry after an
+	// error occurs while querying the Docker Engine API.
+	BackoffStrategy backoff.Strategy
+
+	// LogError is an optional function that logs errors that occur while
+	// querying the Docker Engine API.
+	//
+	// The discoverer retries after an error, and continues to report the
+	// containers that were last known to be running in the meantime.
+	LogError func(error)
+}
+
+// DiscoverTargets invokes an observer for each gRPC target that is discovered.
+//
+// It runs until ctx is canceled or an error occurs.
+//
+// The context passed to the observer is canceled when the target becomes
+// unavailable or the discover is stopped.
+//
+// The discoverer MAY block on calls to the observer. It is the observer's
+// responsibility to start new goroutines to handle background tasks, as
+// appropriate.
+func (d *DockerTargetDiscoverer) DiscoverTargets(ctx context.Context, obs TargetObserver) error {
+	cli := d.client()
+
+	ctr := &backoff.Counter{
+		Strategy: d.BackoffStrategy,
+	}
+
+	set := targetSet{}
+	defer set.cancelAll()
+
+	for {
+		err := d.watch(ctx, cli, ctr, set, obs)
+
+		// If the parent context has been canceled we don't really care what
+		// happens. Bail here before we log it.
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if d.LogError != nil {
+			d.LogError(err)
+		}
+
+		if err := linger.Sleep(ctx, ctr.Fail(err)); err != nil {
+			return err
+		}
+	}
+}
+
+// watch lists the running containers, then follows the events stream for
+// changes until ctx is canceled or an error occurs.
+func (d *DockerTargetDiscoverer) watch(
+	ctx context.Context,
+	cli *http.Client,
+	ctr *backoff.Counter,
+	set targetSet,
+	obs TargetObserver,
+) error {
+	// Start following the events stream before listing the containers so that
+	// no containers that start in the meantime are missed.
+	events, err := d.events(ctx, cli)
+	if err != nil {
+		return err
+	}
+	defer events.Close()
+
+	containers, err := d.list(ctx, cli)
+	if err != nil {
+		return err
+	}
+
+	targets := map[string]Target{}
+	for _, c := range containers {
+		d.add(targets, c)
+	}
+
+	// Reset the backoff counter now that we've received a response from the
+	// API.
+	ctr.Reset()
+	set.sync(ctx, targets, obs)
+
+	dec := json.NewDecoder(events)
+
+	for {
+		var ev dockerEvent
+		if err := dec.Decode(&ev); err != nil {
+			return fmt.Errorf("unable to read docker events: %w", err)
+		}
+
+		switch ev.Action {
+		case "start":
+			c, ok, err := d.inspect(ctx, cli, ev.Actor.ID)
+			if err != nil {
+				return err
+			}
+
+			if ok {
+				d.add(targets, c)
+			}
+
+		case "die":
+			delete(targets, ev.Actor.ID)
+		}
+
+		set.sync(ctx, targets, obs)
+	}
+}
+
+// add adds the target for a container to targets, keyed by the container ID,
+// unless it has no IP address.
+func (d *DockerTargetDiscoverer) add(targets map[string]Target, c dockerContainer) {
+	t, ok := d.target(c)
+	if !ok {
+		// The container is not attached to a network that provides it with an
+		// IP address, such as a container that uses the host's network.
+		return
+	}
+
+	targets[c.ID] = t
+}
+
+// target returns the target for a container.
+func (d *DockerTargetDiscoverer) target(c dockerContainer) (Target, bool) {
+	ip := d.address(c)
+	if ip == "" {
+		return Target{}, false
+	}
+
+	label := d.PortLabel
+	if label == "" {
+		label = DefaultDockerPortLabel
+	}
+
+	port := c.Labels[label]
+	if port == "" {
+		port = DefaultGRPCPort
+	}
+
+	t := Target{
+		Name: net.JoinHostPort(ip, port),
+	}
+
+	if d.DialOptions != nil {
+		t.DialOptions = d.DialOptions(t.Name)
+	}
+
+	if d.TLS != nil {
+		t.DialOptions = append(
+			t.DialOptions,
+			d.TLS.DialOptions(c.Name)...,
+		)
+	}
+
+	return t, true
+}
+
+// address returns the IP address of a container, or an empty string if it
+// has none.
+func (d *DockerTargetDiscoverer) address(c dockerContainer) string {
+	if d.Network != "" {
+		return c.Networks[d.Network].IPAddress
+	}
+
+	names := make([]string, 0, len(c.Networks))
+	for n := range c.Networks {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	for _, n := range names {
+		if ip := c.Networks[n].IPAddress; ip != "" {
+			return ip
+		}
+	}
+
+	return ""
+}
+
+// client returns the HTTP client used to communicate with the Docker Engine
+// API.
+func (d *DockerTargetDiscoverer) client() *http.Client {
+	socket := d.Socket
+	if socket == "" {
+		socket = DefaultDockerSocket
+	}
+
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+}
+
+// filters returns the value of the "filters" query parameter used to select
+// the containers (or their events) with the discoverer's label.
+func (d *DockerTargetDiscoverer) filters(extra map[string][]string) string {
+	label := d.Label
+	if label == "" {
+		label = DefaultDockerLabel
+	}
+
+	f := map[string][]string{
+		"label": {label},
+	}
+
+	for k, v := range extra {
+		f[k] = v
+	}
+
+	data, err := json.Marshal(f)
+	if err != nil {
+		panic(err)
+	}
+
+	return string(data)
+}
+
+// events starts following the Docker events stream for containers with the
+// discoverer's label.
+func (d *DockerTargetDiscoverer) events(ctx context.Context, cli *http.Client) (io.ReadCloser, error) {
+	filters := d.filters(map[string][]string{
+		"type":  {"container"},
+		"event": {"start", "die"},
+	})
+
+	res, err := dockerGet(ctx, cli, "/events", filters)
+	if err != nil {
+		return nil, fmt.Errorf("unable to follow docker events: %w", err)
+	}
+
+	return res.Body, nil
+}
+
+// list returns the running containers with the discoverer's label.
+func (d *DockerTargetDiscoverer) list(ctx context.Context, cli *http.Client) ([]dockerContainer, error) {
+	res, err := dockerGet(ctx, cli, "/containers/json", d.filters(nil))
+	if err != nil {
+		return nil, fmt.Errorf("unable to list docker containers: %w", err)
+	}
+	defer res.Body.Close()
+
+	var summaries []struct {
+		ID              string            `json:"Id"`
+		Names           []string          `json:"Names"`
+		Labels          map[string]string `json:"Labels"`
+		NetworkSettings struct {
+			Networks map[string]dockerNetwork `json:"Networks"`
+		} `json:"NetworkSettings"`
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&summaries); err != nil {
+		return nil, fmt.Errorf("unable to list docker containers: %w", err)
+	}
+
+	containers := make([]dockerContainer, len(summaries))
+	for i, s := range summaries {
+		containers[i] = dockerContainer{
+			ID:       s.ID,
+			Labels:   s.Labels,
+			Networks: s.NetworkSettings.Networks,
+		}
+
+		if len(s.Names) != 0 {
+			containers[i].Name = strings.TrimPrefix(s.Names[0], "/")
+		}
+	}
+
+	return containers, nil
+}
+
+// inspect returns the details of a single container.
+//
+// ok is false if the container no longer exists or has already stopped.
+func (d *DockerTargetDiscoverer) inspect(
+	ctx context.Context,
+	cli *http.Client,
+	id string,
+) (_ dockerContainer, ok bool, _ error) {
+	res, err := dockerGet(ctx, cli, "/containers/"+url.PathEscape(id)+"/json", "")
+	if err != nil {
+		if res != nil && res.StatusCode == http.StatusNotFound {
+			return dockerContainer{}, false, nil
+		}
+
+		return dockerContainer{}, false, fmt.Errorf("unable to inspect docker container: %w", err)
+	}
+	defer res.Body.Close()
+
+	var details struct {
+		ID     string `json:"Id"`
+		Name   string `json:"Name"`
+		Config struct {
+			Labels map[string]string `json:"Labels"`
+		} `json:"Config"`
+		State struct {
+			Running bool `json:"Running"`
+		} `json:"State"`
+		NetworkSettings struct {
+			Networks map[string]dockerNetwork `json:"Networks"`
+		} `json:"NetworkSettings"`
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&details); err != nil {
+		return dockerContainer{}, false, fmt.Errorf("unable to inspect docker container: %w", err)
+	}
+
+	if !details.State.Running {
+		return dockerContainer{}, false, nil
+	}
+
+	return dockerContainer{
+		ID:       details.ID,
+		Name:     strings.TrimPrefix(details.Name, "/"),
+		Labels:   details.Config.Labels,
+		Networks: details.NetworkSettings.Networks,
+	}, true, nil
+}
+
+// dockerGet performs a GET request against the Docker Engine API.
+//
+// If the API responds with an error status, the response is returned along
+// with a non-nil error, and its body is closed.
+func dockerGet(
+	ctx context.Context,
+	cli *http.Client,
+	path, filters string,
+) (*http.Response, error) {
+	u := url.URL{
+		Scheme: "http",
+		Host:   "docker",
+		Path:   path,
+	}
+
+	if filters != "" {
+		u.RawQuery = url.Values{"filters": {filters}}.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := cli.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode == http.StatusOK {
+		return res, nil
+	}
+
+	defer res.Body.Close()
+
+	var body struct {
+		Message string `json:"message"`
+	}
+	json.NewDecoder(res.Body).Decode(&body)
+
+	if body.Message == "" {
+		return res, fmt.Errorf("docker API responded with %s", res.Status)
+	}
+
+	return res, fmt.Errorf("docker API responded with %s: %s", res.Status, body.Message)
+}
+
+// dockerContainer is a running Docker container.
+type dockerContainer struct {
+	ID       string
+	Name     string
+	Labels   map[string]string
+	Networks map[string]dockerNetwork
+}
+
+// dockerNetwork is the configuration of a container within a single Docker
+// network.
+type dockerNetwork struct {
+	IPAddress string `json:"IPAddress"`
+}
+
+// dockerEvent is an event from the Docker events stream.
+type dockerEvent struct {
+	Action string `json:"Action"`
+	Actor  struct {
+		ID string `json:"ID"`
+	} `json:"Actor"`
+}
@@ -0,0 +1,616 @@
+package discoverkit_test
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/dogmatiq/discoverkit"
+	"github.com/dogmatiq/linger/backoff"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+)
+
+var _ = Describe("type DockerTargetDiscoverer", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+		engine *dockerEngineStub
+		disc   *DockerTargetDiscoverer
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 2*time.Second)
+		DeferCleanup(cancel)
+
+		engine = &dockerEngineStub{}
+		engine.Run(dockerContainerStub{
+			ID:   "c1",
+			Name: "engine1",
+			Labels: map[string]string{
+				"dogma.discover": "true",
+			},
+			Networks: map[string]string{
+				"bridge": "172.17.0.2",
+			},
+		})
+		engine.Run(dockerContainerStub{
+			ID:   "c2",
+			Name: "engine2",
+			Labels: map[string]string{
+				"dogma.discover": "true",
+				"dogma.port":     "9000",
+			},
+			Networks: map[string]string{
+				"bridge":   "172.17.0.3",
+				"backend":  "10.0.0.3",
+				"frontend": "10.1.0.3",
+			},
+		})
+		engine.Run(dockerContainerStub{
+			ID:   "c3",
+			Name: "database",
+			Networks: map[string]string{
+				"bridge": "172.17.0.4",
+			},
+		})
+		engine.Run(dockerContainerStub{
+			ID:   "c4",
+			Name: "host-network",
+			Labels: map[string]string{
+				"dogma.discover": "true",
+			},
+			Networks: map[string]string{
+				"host": "",
+			},
+		})
+
+		disc = &DockerTargetDiscoverer{}
+	})
+
+	JustBeforeEach(func() {
+		engine.Start()
+		disc.Socket = engine.Socket
+	})
+
+	Describe("func DiscoverTargets()", func() {
+		It("invokes the observer for each running container with the label", func() {
+			var actual []Target
+
+			err := disc.DiscoverTargets(
+				ctx,
+				func(
+					_ context.Context,
+					t Target,
+				) {
+					actual = append(actual, t)
+
+					if len(actual) == 2 {
+						cancel()
+					}
+				},
+			)
+
+			Expect(err).To(Equal(context.Canceled))
+			Expect(actual).To(ConsistOf(
+				Target{Name: "172.17.0.2:50555"},
+				Target{Name: "10.0.0.3:9000"},
+			))
+		})
+
+		It("uses the IP address from the configured network", func() {
+			disc.Network = "frontend"
+
+			var actual []Target
+
+			err := disc.DiscoverTargets(
+				ctx,
+				func(
+					_ context.Context,
+					t Target,
+				) {
+					actual = append(actual, t)
+					cancel()
+				},
+			)
+
+			Expect(err).To(Equal(context.Canceled))
+			Expect(actual).To(ConsistOf(
+				Target{Name: "10.1.0.3:9000"},
+			))
+		})
+
+		It("allows use of a custom label and port label", func() {
+			disc.Label = "role"
+			disc.PortLabel = "grpc-port"
+
+			engine.Run(dockerContainerStub{
+				ID:   "c5",
+				Name: "custom",
+				Labels: map[string]string{
+					"role":      "engine",
+					"grpc-port": "7000",
+				},
+				Networks: map[string]string{
+					"bridge": "172.17.0.5",
+				},
+			})
+
+			var actual []Target
+
+			err := disc.DiscoverTargets(
+				ctx,
+				func(
+					_ context.Context,
+					t Target,
+				) {
+					actual = append(actual, t)
+					cancel()
+				},
+			)
+
+			Expect(err).To(Equal(context.Canceled))
+			Expect(actual).To(ConsistOf(
+				Target{Name: "172.17.0.5:7000"},
+			))
+		})
+
+		It("uses the dial options and TLS configuration to dial the targets", func() {
+			options := []grpc.DialOption{grpc.WithAuthority("engine")}
+			disc.DialOptions = func(string) []grpc.DialOption {
+				return options
+			}
+			disc.TLS = &TLSConfig{}
+
+			var actual []Target
+
+			err := disc.DiscoverTargets(
+				ctx,
+				func(
+					_ context.Context,
+					t Target,
+				) {
+					actual = append(actual, t)
+
+					if len(actual) == 2 {
+						cancel()
+					}
+				},
+			)
+
+			Expect(err).To(Equal(context.Canceled))
+			Expect(actual).To(HaveEach(
+				HaveField("DialOptions", HaveLen(3)), // custom, credentials and authority
+			))
+		})
+
+		It("invokes the observer when a container with the label starts", func() {
+			targets := make(chan Target, 10)
+
+			runTargetDiscoverer(ctx, cancel, disc, func(_ context.Context, t Target) {
+				targets <- t
+			})
+
+			Eventually(targets).Should(Receive())
+			Eventually(targets).Should(Receive())
+			Eventually(engine.Subscribers).Should(Equal(1))
+
+			engine.Run(dockerContainerStub{
+				ID:   "c5",
+				Name: "engine3",
+				Labels: map[string]string{
+					"dogma.discover": "true",
+				},
+				Networks: map[string]string{
+					"bridge": "172.17.0.5",
+				},
+			})
+
+			Eventually(targets).Should(Receive(Equal(Target{Name: "172.17.0.5:50555"})))
+		})
+
+		It("does not invoke the observer when a container without the label starts", func() {
+			targets := make(chan Target, 10)
+
+			runTargetDiscoverer(ctx, cancel, disc, func(_ context.Context, t Target) {
+				targets <- t
+			})
+
+			Eventually(targets).Should(Receive())
+			Eventually(targets).Should(Receive())
+			Eventually(engine.Subscribers).Should(Equal(1))
+
+			engine.Run(dockerContainerStub{
+				ID:   "c5",
+				Name: "cache",
+				Networks: map[string]string{
+					"bridge": "172.17.0.5",
+				},
+			})
+
+			Consistently(targets, 100*time.Millisecond).ShouldNot(Receive())
+		})
+
+		It("cancels the context when the container stops", func() {
+			contexts := make(chan context.Context, 10)
+
+			runTargetDiscoverer(ctx, cancel, disc, func(c context.Context, _ Target) {
+				contexts <- c
+			})
+
+			var ctx1, ctx2 context.Context
+			Eventually(contexts).Should(Receive(&ctx1))
+			Eventually(contexts).Should(Receive(&ctx2))
+			Eventually(engine.Subscribers).Should(Equal(1))
+
+			engine.Stop("c1")
+
+			Eventually(ctx1.Done()).Should(BeClosed())
+			Consistently(ctx2.Done(), 50*time.Millisecond).ShouldNot(BeClosed())
+		})
+
+		It("cancels the context of all targets when it returns", func() {
+			contexts := make(chan context.Context, 10)
+			done := make(chan struct{})
+
+			go func() {
+				defer close(done)
+				disc.DiscoverTargets(ctx, func(c context.Context, _ Target) {
+					contexts <- c
+				})
+			}()
+
+			var targetCtx context.Context
+			Eventually(contexts).Should(Receive(&targetCtx))
+
+			cancel()
+			Eventually(done).Should(BeClosed())
+			Expect(targetCtx.Done()).To(BeClosed())
+		})
+
+		It("logs errors and retries if the events stream ends", func() {
+			errs := make(chan error, 10)
+			disc.LogError = func(err error) {
+				errs <- err
+			}
+			disc.BackoffStrategy = backoff.Constant(10 * time.Millisecond)
+
+			contexts := make(chan context.Context, 10)
+			targets := make(chan Target, 10)
+
+			runTargetDiscoverer(ctx, cancel, disc, func(c context.Context, t Target) {
+				contexts <- c
+				targets <- t
+			})
+
+			var ctx1 context.Context
+			Eventually(contexts).Should(Receive(&ctx1))
+			Eventually(contexts).Should(Receive())
+			Eventually(engine.Subscribers).Should(Equal(1))
+
+			engine.CloseEvents()
+
+			Eventually(errs).Should(Receive(MatchError(ContainSubstring("unable to read docker events"))))
+			Expect(ctx1.Err()).ShouldNot(HaveOccurred())
+
+			Eventually(engine.Subscribers).Should(Equal(1))
+			engine.Run(dockerContainerStub{
+				ID:   "c5",
+				Name: "engine5",
+				Labels: map[string]string{
+					"dogma.discover": "true",
+				},
+				Networks: map[string]string{
+					"bridge": "172.17.0.5",
+				},
+			})
+
+			Eventually(targets).Should(Receive(Equal(Target{Name: "172.17.0.5:50555"})))
+			Expect(ctx1.Err()).ShouldNot(HaveOccurred())
+		})
+
+		It("logs errors and retries if the API responds with an error", func() {
+			errs := make(chan error, 10)
+			disc.LogError = func(err error) {
+				errs <- err
+			}
+			disc.BackoffStrategy = backoff.Constant(10 * time.Millisecond)
+
+			contexts := make(chan context.Context, 10)
+
+			runTargetDiscoverer(ctx, cancel, disc, func(c context.Context, _ Target) {
+				contexts <- c
+			})
+
+			var ctx1, ctx2 context.Context
+			Eventually(contexts).Should(Receive(&ctx1))
+			Eventually(contexts).Should(Receive(&ctx2))
+
+			engine.FailList("permission denied")
+			engine.CloseEvents()
+
+			Eventually(errs).Should(Receive(MatchError(
+				"unable to list docker containers: docker API responded with 500 Internal Server Error: permission denied",
+			)))
+			Expect(ctx1.Err()).ShouldNot(HaveOccurred())
+
+			// Stop a container while the discoverer is unable to list the
+			// containers. It is removed once the discoverer recovers.
+			engine.Stop("c1")
+			engine.FailList("")
+
+			Eventually(ctx1.Done()).Should(BeClosed())
+			Consistently(ctx2.Done(), 50*time.Millisecond).ShouldNot(BeClosed())
+		})
+
+		It("logs errors and retries if the socket can not be dialed", func() {
+			disc.Socket = filepath.Join(GinkgoT().TempDir(), "missing.sock")
+
+			errs := make(chan error, 10)
+			disc.LogError = func(err error) {
+				errs <- err
+			}
+			disc.BackoffStrategy = backoff.Constant(10 * time.Millisecond)
+
+			runTargetDiscoverer(ctx, cancel, disc, func(context.Context, Target) {
+				Fail("unexpected call")
+			})
+
+			Eventually(errs).Should(Receive(MatchError(ContainSubstring("unable to follow docker events"))))
+			Eventually(errs).Should(Receive(MatchError(ContainSubstring("unable to follow docker events"))))
+		})
+	})
+})
+
+// dockerContainerStub is a container managed by a dockerEngineStub.
+type dockerContainerStub struct {
+	ID       string
+	Name     string
+	Labels   map[string]string
+	Networks map[string]string // network name to IP address
+	Running  bool
+}
+
+// dockerEngineStub is a stub implementation of the Docker Engine API that is
+// served on a unix socket.
+type dockerEngineStub struct {
+	// Socket is the path of the unix socket, set by Start().
+	Socket string
+
+	m           sync.Mutex
+	containers  []*dockerContainerStub
+	subscribers map[chan dockerEventStub]map[string][]string
+	listError   string
+	closed      chan struct{}
+}
+
+// dockerEventStub is an event sent by a dockerEngineStub.
+type dockerEventStub struct {
+	container *dockerContainerStub
+	action    string
+}
+
+// Start starts the server. It is stopped when the current spec ends.
+func (s *dockerEngineStub) Start() {
+	s.Socket = filepath.Join(GinkgoT().TempDir(), "docker.sock")
+
+	l, err := net.Listen("unix", s.Socket)
+	Expect(err).ShouldNot(HaveOccurred())
+
+	s.m.Lock()
+	s.closed = make(chan struct{})
+	s.m.Unlock()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /containers/json", s.list)
+	mux.HandleFunc("GET /containers/{id}/json", s.inspect)
+	mux.HandleFunc("GET /events", s.events)
+
+	server := &http.Server{Handler: mux}
+	go server.Serve(l)
+
+	DeferCleanup(func() {
+		s.CloseEvents()
+		server.Close()
+	})
+}
+
+// Run starts a container.
+func (s *dockerEngineStub) Run(c dockerContainerStub) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	c.Running = true
+	s.containers = append(s.containers, &c)
+	s.publish(&c, "start")
+}
+
+// Stop stops a container.
+func (s *dockerEngineStub) Stop(id string) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	for _, c := range s.containers {
+		if c.ID == id {
+			c.Running = false
+			s.publish(c, "die")
+		}
+	}
+}
+
+// FailList causes requests to list containers to fail with the given message.
+func (s *dockerEngineStub) FailList(message string) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.listError = message
+}
+
+// Subscribers returns the number of clients that are following the events
+// stream.
+func (s *dockerEngineStub) Subscribers() int {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return len(s.subscribers)
+}
+
+// CloseEvents ends all current events streams.
+func (s *dockerEngineStub) CloseEvents() {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	close(s.closed)
+	s.closed = make(chan struct{})
+}
+
+// publish sends an event to each subscriber with matching filters. s.m must
+// be locked.
+func (s *dockerEngineStub) publish(c *dockerContainerStub, action string) {
+	for ch, filters := range s.subscribers {
+		if dockerFiltersMatch(filters, c) {
+			ch <- dockerEventStub{c, action}
+		}
+	}
+}
+
+func (s *dockerEngineStub) list(w http.ResponseWriter, r *http.Request) {
+	filters := dockerFilters(r)
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.listError != "" {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"message": s.listError})
+		return
+	}
+
+	summaries := []any{}
+
+	for _, c := range s.containers {
+		if c.Running && dockerFiltersMatch(filters, c) {
+			summaries = append(summaries, map[string]any{
+				"Id":     c.ID,
+				"Names":  []string{"/" + c.Name},
+				"Labels": c.Labels,
+				"NetworkSettings": map[string]any{
+					"Networks": dockerNetworksJSON(c),
+				},
+			})
+		}
+	}
+
+	json.NewEncoder(w).Encode(summaries)
+}
+
+func (s *dockerEngineStub) inspect(w http.ResponseWriter, r *http.Request) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	for _, c := range s.containers {
+		if c.ID == r.PathValue("id") {
+			json.NewEncoder(w).Encode(map[string]any{
+				"Id":   c.ID,
+				"Name": "/" + c.Name,
+				"Config": map[string]any{
+					"Labels": c.Labels,
+				},
+				"State": map[string]any{
+					"Running": c.Running,
+				},
+				"NetworkSettings": map[string]any{
+					"Networks": dockerNetworksJSON(c),
+				},
+			})
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNotFound)
+	json.NewEncoder(w).Encode(map[string]string{"message": "no such container"})
+}
+
+func (s *dockerEngineStub) events(w http.ResponseWriter, r *http.Request) {
+	ch := make(chan dockerEventStub, 10)
+
+	s.m.Lock()
+	if s.subscribers == nil {
+		s.subscribers = map[chan dockerEventStub]map[string][]string{}
+	}
+	s.subscribers[ch] = dockerFilters(r)
+	closed := s.closed
+	s.m.Unlock()
+
+	defer func() {
+		s.m.Lock()
+		delete(s.subscribers, ch)
+		s.m.Unlock()
+	}()
+
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+
+	enc := json.NewEncoder(w)
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-closed:
+			return
+		case ev := <-ch:
+			enc.Encode(map[string]any{
+				"Type":   "container",
+				"Action": ev.action,
+				"Actor": map[string]any{
+					"ID":         ev.container.ID,
+					"Attributes": ev.container.Labels,
+				},
+			})
+			w.(http.Flusher).Flush()
+		}
+	}
+}
+
+// dockerFilters returns the filters in a request to the Docker Engine API.
+func dockerFilters(r *http.Request) map[string][]string {
+	var filters map[string][]string
+
+	if f := r.URL.Query().Get("filters"); f != "" {
+		err := json.Unmarshal([]byte(f), &filters)
+		Expect(err).ShouldNot(HaveOccurred())
+	}
+
+	return filters
+}
+
+// dockerFiltersMatch returns true if c matches the label filters.
+func dockerFiltersMatch(filters map[string][]string, c *dockerContainerStub) bool {
+	for _, f := range filters["label"] {
+		k, v, hasValue := strings.Cut(f, "=")
+
+		actual, ok := c.Labels[k]
+		if !ok || (hasValue && actual != v) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// dockerNetworksJSON returns the JSON representation of a container's
+// networks.
+func dockerNetworksJSON(c *dockerContainerStub) map[string]any {
+	networks := map[string]any{}
+	for n, ip := range c.Networks {
+		networks[n] = map[string]any{"IPAddress": ip}
+	}
+	return networks
+}
@@ -0,0 +1,86 @@
+package discoverkit
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+// DockerLinkTargetDiscoverer discovers gRPC targets by inspecting the
+// environment for the variables that Docker defines for linked containers,
+// such as "<ALIAS>_PORT_<number>_TCP_ADDR".
+type DockerLinkTargetDiscoverer struct {
+	// Ports is the set of ports used to identify gRPC targets, each with its
+	// own dial options. The name of each port is the port number within the
+	// linked container, such as "50555".
+	//
+	// The server certificate of each target is verified against the alias of
+	// the linked container.
+	Ports []EnvironmentPort
+
+	// Environment is the source of the environment variables.
+	//
+	// If it is nil, the environment variables of the current process are used.
+	Environment EnvironmentSource
+
+	// RefreshInterval is the interval at which the environment is read again
+	// to discover changes.
+	//
+	// If it is non-positive, the environment is read only once and the context
+	// passed to the observer is the context passed to DiscoverTargets().
+	RefreshInterval time.Duration
+}
+
+// DiscoverTargets invokes an observer for each gRPC target that is discovered.
+//
+// It runs until ctx is canceled or an error occurs.
+//
+// The context passed to the observer is canceled when the target becomes
+// unavailable or the discover is stopped.
+//
+// The discoverer MAY block on calls to the observer. It is the observer's
+// responsibility to start new goroutines to handle background tasks, as
+// appropriate.
+func (d *DockerLinkTargetDiscoverer) DiscoverTargets(
+	ctx context.Context,
+	obs TargetObserver,
+) error {
+	if len(d.Ports) == 0 {
+		return errors.New("docker link discoverer must have at least one port")
+	}
+
+	return discoverEnvironmentTargets(
+		ctx,
+		d.Environment,
+		d.RefreshInterval,
+		d.parse,
+		obs,
+	)
+}
+
+// parse returns the targets described by Docker link environment variables,
+// keyed by the environment variable that contains the address.
+func (d *DockerLinkTargetDiscoverer) parse(env map[string]string) map[string]Target {
+	targets := map[string]Target{}
+
+	for _, p := range d.Ports {
+		suffix := "_PORT_" + nameToEnv(p.Name) + "_TCP_ADDR"
+
+		for k, host := range env {
+			prefix, ok := strings.CutSuffix(k, suffix)
+			if !ok || prefix == "" || host == "" {
+				continue
+			}
+
+			port := env[strings.TrimSuffix(k, "_ADDR")+"_PORT"]
+			if port == "" {
+				continue
+			}
+
+			targets[k] = p.target(envToName(prefix), host, port)
+		}
+	}
+
+	return targets
+}
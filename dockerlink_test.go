@@ -0,0 +1,127 @@
+package discoverkit_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/dogmatiq/discoverkit"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("type DockerLinkTargetDiscoverer", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+		disc   *DockerLinkTargetDiscoverer
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 1*time.Second)
+		DeferCleanup(cancel)
+
+		disc = &DockerLinkTargetDiscoverer{
+			Ports: []EnvironmentPort{
+				{Name: "50555"},
+			},
+			Environment: EnvironmentMap{
+				"API_NAME":                  "/web/api",
+				"API_PORT":                  "tcp://172.17.0.2:50555",
+				"API_PORT_50555_TCP":        "tcp://172.17.0.2:50555",
+				"API_PORT_50555_TCP_ADDR":   "172.17.0.2",
+				"API_PORT_50555_TCP_PORT":   "50555",
+				"API_PORT_50555_TCP_PROTO":  "tcp",
+				"API_PORT_8080_TCP_ADDR":    "172.17.0.2",
+				"API_PORT_8080_TCP_PORT":    "8080",
+				"DB_PORT_50555_TCP_ADDR":    "172.17.0.3",
+				"DB_PORT_50555_TCP_PORT":    "", // empty ports should be ignored
+				"CACHE_PORT_50555_TCP_ADDR": "", // empty addresses should be ignored
+				"CACHE_PORT_50555_TCP_PORT": "50555",
+			},
+		}
+	})
+
+	Describe("func DiscoverTargets()", func() {
+		It("invokes the observer for each linked container with the port", func() {
+			var actual []Target
+
+			err := disc.DiscoverTargets(
+				ctx,
+				func(
+					c context.Context,
+					t Target,
+				) {
+					Expect(c).To(BeIdenticalTo(ctx))
+					actual = append(actual, t)
+					cancel()
+				},
+			)
+
+			Expect(err).To(Equal(context.Canceled))
+			Expect(actual).To(ConsistOf(
+				Target{Name: "172.17.0.2:50555"},
+			))
+		})
+
+		It("discovers a separate target for each port", func() {
+			disc.Ports = append(disc.Ports, EnvironmentPort{Name: "8080"})
+
+			var actual []Target
+
+			err := disc.DiscoverTargets(
+				ctx,
+				func(
+					_ context.Context,
+					t Target,
+				) {
+					actual = append(actual, t)
+
+					if len(actual) == 2 {
+						cancel()
+					}
+				},
+			)
+
+			Expect(err).To(Equal(context.Canceled))
+			Expect(actual).To(ConsistOf(
+				Target{Name: "172.17.0.2:50555"},
+				Target{Name: "172.17.0.2:8080"},
+			))
+		})
+
+		It("uses the TLS configuration to dial the targets", func() {
+			disc.Ports[0].TLS = &TLSConfig{}
+
+			var actual []Target
+
+			err := disc.DiscoverTargets(
+				ctx,
+				func(
+					_ context.Context,
+					t Target,
+				) {
+					actual = append(actual, t)
+					cancel()
+				},
+			)
+
+			Expect(err).To(Equal(context.Canceled))
+			Expect(actual).To(HaveEach(
+				HaveField("DialOptions", HaveLen(2)), // credentials and authority
+			))
+		})
+
+		It("returns an error if there are no ports", func() {
+			disc.Ports = nil
+
+			err := disc.DiscoverTargets(
+				ctx,
+				func(context.Context, Target) {
+					Fail("unexpected call")
+				},
+			)
+
+			Expect(err).To(MatchError("docker link discoverer must have at least one port"))
+		})
+	})
+})
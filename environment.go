@@ -0,0 +1,241 @@
+package discoverkit
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dogmatiq/linger"
+	"google.golang.org/grpc"
+)
+
+// EnvironmentSource is an interface for sources of environment variables.
+type EnvironmentSource interface {
+	// Environment returns the environment variables, keyed by name.
+	Environment() (map[string]string, error)
+}
+
+// ProcessEnvironment is an EnvironmentSource that returns the environment
+// variables of the current process.
+type ProcessEnvironment struct{}
+
+// Environment returns the environment variables of the current process.
+func (ProcessEnvironment) Environment() (map[string]string, error) {
+	env := map[string]string{}
+
+	for _, line := range os.Environ() {
+		if k, v, ok := strings.Cut(line, "="); ok {
+			env[k] = v
+		}
+	}
+
+	return env, nil
+}
+
+// EnvironmentMap is an EnvironmentSource that returns a fixed set of
+// environment variables.
+type EnvironmentMap map[string]string
+
+// Environment returns a copy of the map.
+func (m EnvironmentMap) Environment() (map[string]string, error) {
+	env := make(map[string]string, len(m))
+	for k, v := range m {
+		env[k] = v
+	}
+	return env, nil
+}
+
+// EnvironmentFile is an EnvironmentSource that reads environment variables
+// from a file, such as a Kubernetes ConfigMap mounted as a volume or a Docker
+// "env file".
+//
+// Each line of the file is of the form NAME=VALUE, optionally preceded by
+// "export". Values may be enclosed in single or double quotes. Blank lines and
+// lines that begin with # are ignored.
+type EnvironmentFile string
+
+// Environment reads the environment variables from the file.
+func (f EnvironmentFile) Environment() (map[string]string, error) {
+	file, err := os.Open(string(f))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	env := map[string]string{}
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+
+	for scanner.Scan() {
+		lineNumber++
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		line = strings.TrimPrefix(line, "export ")
+
+		k, v, ok := strings.Cut(line, "=")
+		k = strings.TrimSpace(k)
+		if !ok || k == "" {
+			return nil, fmt.Errorf("%s:%d: expected NAME=VALUE", f, lineNumber)
+		}
+
+		v, err := unquoteEnvironmentValue(strings.TrimSpace(v))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", f, lineNumber, err)
+		}
+
+		env[k] = v
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return env, nil
+}
+
+// unquoteEnvironmentValue removes the quotes from a value in an environment
+// file, if it has any.
+func unquoteEnvironmentValue(v string) (string, error) {
+	if len(v) < 2 {
+		return v, nil
+	}
+
+	switch v[0] {
+	case '"':
+		return strconv.Unquote(v)
+	case '\'':
+		if v[len(v)-1] != '\'' {
+			return "", fmt.Errorf("unterminated quoted value: %s", v)
+		}
+		return v[1 : len(v)-1], nil
+	default:
+		return v, nil
+	}
+}
+
+// EnvironmentPort is a named port that identifies gRPC targets in the
+// environment.
+type EnvironmentPort struct {
+	// Name is the name of the port.
+	Name string
+
+	// DialOptions returns the dial options used to dial the given address.
+	DialOptions func(addr string) []grpc.DialOption
+
+	// TLS is the TLS configuration used to dial the targets discovered via
+	// this port.
+	//
+	// The server certificate is verified against the name of the service, not
+	// the IP address in the environment. If TLS is nil, only the options
+	// returned by DialOptions are used.
+	TLS *TLSConfig
+}
+
+// target returns the target for a service that is discovered via this port.
+func (p EnvironmentPort) target(service, host, port string) Target {
+	t := Target{
+		Name: net.JoinHostPort(host, port),
+	}
+
+	if p.DialOptions != nil {
+		t.DialOptions = p.DialOptions(t.Name)
+	}
+
+	if p.TLS != nil {
+		t.DialOptions = append(
+			t.DialOptions,
+			p.TLS.DialOptions(service)...,
+		)
+	}
+
+	return t
+}
+
+// discoverEnvironmentTargets invokes an observer for each target that parse
+// finds in the environment.
+//
+// parse returns the targets keyed by a value that identifies the service and
+// port that each target was found by.
+//
+// If interval is positive the environment is read again at that interval.
+// Targets that are no longer present, or whose name has changed, have their
+// context canceled. Otherwise, the environment is read once and ctx is passed
+// to the observer directly.
+func discoverEnvironmentTargets(
+	ctx context.Context,
+	src EnvironmentSource,
+	interval time.Duration,
+	parse func(env map[string]string) map[string]Target,
+	obs TargetObserver,
+) error {
+	if src == nil {
+		src = ProcessEnvironment{}
+	}
+
+	read := func() (map[string]Target, error) {
+		env, err := src.Environment()
+		if err != nil {
+			return nil, fmt.Errorf("unable to read environment: %w", err)
+		}
+		return parse(env), nil
+	}
+
+	targets, err := read()
+	if err != nil {
+		return err
+	}
+
+	if interval <= 0 {
+		for _, k := range sortedTargetKeys(targets) {
+			obs(ctx, targets[k])
+		}
+
+		<-ctx.Done()
+
+		return ctx.Err()
+	}
+
+	set := targetSet{}
+	defer set.cancelAll()
+
+	for {
+		set.sync(ctx, targets, obs)
+
+		if err := linger.Sleep(ctx, interval); err != nil {
+			return err
+		}
+
+		targets, err = read()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// envToName converts the prefix of a Kubernetes service or Docker link
+// environment variable to the name of the service.
+//
+// This is not an exact inverse of nameToEnv(), as service names can not
+// contain underscores or uppercase letters.
+func envToName(s string) string {
+	return strings.ToLower(
+		strings.ReplaceAll(s, "_", "-"),
+	)
+}
+
+// nameToEnv converts a service or port name to the form used in environment
+// variable names, as per the behavior of Kubernetes and Docker.
+func nameToEnv(s string) string {
+	return strings.ToUpper(
+		strings.ReplaceAll(s, "-", "_"),
+	)
+}
@@ -0,0 +1,101 @@
+package discoverkit_test
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/dogmatiq/discoverkit"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("type ProcessEnvironment", func() {
+	Describe("func Environment()", func() {
+		It("returns the environment variables of the current process", func() {
+			os.Setenv("DISCOVERKIT_TEST", "a=b")
+			DeferCleanup(os.Unsetenv, "DISCOVERKIT_TEST")
+
+			env, err := ProcessEnvironment{}.Environment()
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(env).To(HaveKeyWithValue("DISCOVERKIT_TEST", "a=b"))
+		})
+	})
+})
+
+var _ = Describe("type EnvironmentMap", func() {
+	Describe("func Environment()", func() {
+		It("returns a copy of the map", func() {
+			m := EnvironmentMap{"A": "1"}
+
+			env, err := m.Environment()
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(env).To(Equal(map[string]string{"A": "1"}))
+
+			env["B"] = "2"
+			Expect(m).NotTo(HaveKey("B"))
+		})
+	})
+})
+
+var _ = Describe("type EnvironmentFile", func() {
+	var file string
+
+	BeforeEach(func() {
+		file = filepath.Join(GinkgoT().TempDir(), "test.env")
+	})
+
+	Describe("func Environment()", func() {
+		It("returns the environment variables in the file", func() {
+			writeEnvironmentFile(
+				file,
+				"# comment",
+				"",
+				"PLAIN=value",
+				"  SPACED = value with spaces  ",
+				"export EXPORTED=1",
+				`DOUBLE="quoted \"value\""`,
+				"SINGLE='quoted $value'",
+				"EMPTY=",
+				"CONTAINS_EQUALS=a=b",
+			)
+
+			env, err := EnvironmentFile(file).Environment()
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(env).To(Equal(map[string]string{
+				"PLAIN":           "value",
+				"SPACED":          "value with spaces",
+				"EXPORTED":        "1",
+				"DOUBLE":          `quoted "value"`,
+				"SINGLE":          "quoted $value",
+				"EMPTY":           "",
+				"CONTAINS_EQUALS": "a=b",
+			}))
+		})
+
+		It("returns an error if the file does not exist", func() {
+			_, err := EnvironmentFile(file).Environment()
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+
+		It("returns an error if a line is malformed", func() {
+			writeEnvironmentFile(
+				file,
+				"VALID=1",
+				"INVALID",
+			)
+
+			_, err := EnvironmentFile(file).Environment()
+			Expect(err).To(MatchError(file + ":2: expected NAME=VALUE"))
+		})
+
+		It("returns an error if a quoted value is unterminated", func() {
+			writeEnvironmentFile(
+				file,
+				"VALUE='unterminated",
+			)
+
+			_, err := EnvironmentFile(file).Environment()
+			Expect(err).To(MatchError(file + ":1: unterminated quoted value: 'unterminated"))
+		})
+	})
+})
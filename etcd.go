@@ -0,0 +1,510 @@
+package discoverkit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dogmatiq/linger"
+	"github.com/dogmatiq/linger/backoff"
+	"google.golang.org/grpc"
+)
+
+const (
+	// DefaultEtcdEndpoint is the default URL of the etcd v3 HTTP API.
+	DefaultEtcdEndpoint = "http://127.0.0.1:2379"
+
+	// DefaultEtcdPrefix is the default key prefix under which targets are
+	// registered in etcd.
+	DefaultEtcdPrefix = "/dogma/targets/"
+
+	// DefaultEtcdLeaseTTL is the default TTL of the lease that is attached to
+	// each registration in etcd.
+	DefaultEtcdLeaseTTL = 10 * time.Second
+)
+
+// EtcdRegistrar registers gRPC targets in etcd so that they can be discovered
+// by an EtcdTargetDiscoverer.
+//
+// It uses the JSON gateway of the etcd v3 API. Each target is stored under a
+// key that consists of the prefix followed by the target name. The key is
+// attached to a lease that is kept alive for as long as the registration
+// lasts.
+type EtcdRegistrar struct {
+	// Endpoint is the URL of the etcd v3 HTTP API, such as
+	// "http://127.0.0.1:2379".
+	//
+	// If it is empty, DefaultEtcdEndpoint is used.
+	Endpoint string
+
+	// Prefix is the key prefix under which targets are registered.
+	//
+	// If it is empty, DefaultEtcdPrefix is used.
+	Prefix string
+
+	// LeaseTTL is the TTL of the lease attached to the registration. If the
+	// registrar stops without revoking the lease, such as when the process
+	// crashes, the target is removed after this duration.
+	//
+	// If it is non-positive, DefaultEtcdLeaseTTL is used. It is rounded up to
+	// a whole number of seconds.
+	LeaseTTL time.Duration
+
+	// HTTPClient is the client used to communicate with etcd.
+	//
+	// If it is nil, http.DefaultClient is used.
+	HTTPClient *http.Client
+
+	// BackoffStrategy is the strategy that determines when to retry the
+	// registration after an error.
+	BackoffStrategy backoff.Strategy
+
+	// LogError is an optional function that logs errors that occur while
+	// registering the target.
+	LogError func(error)
+}
+
+// Register registers t in etcd until ctx is canceled.
+//
+// The registration is removed when ctx is canceled. Errors that occur while
+// communicating with etcd are logged to the LogError function, if present,
+// before retrying. It always returns a non-nil error.
+func (r *EtcdRegistrar) Register(ctx context.Context, t Target) error {
+	cli := etcdClient{r.Endpoint, r.HTTPClient}
+	key := etcdPrefix(r.Prefix) + t.Name
+
+	ttl := linger.MustCoalesce(r.LeaseTTL, DefaultEtcdLeaseTTL)
+	seconds := int64((ttl + time.Second - 1) / time.Second)
+
+	ctr := &backoff.Counter{
+		Strategy: r.BackoffStrategy,
+	}
+
+	for {
+		err := r.register(ctx, cli, key, t.Name, seconds, ctr)
+
+		// If the parent context has been canceled we don't really care what
+		// happens. Bail here before we log it.
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if r.LogError != nil {
+			r.LogError(err)
+		}
+
+		if err := linger.Sleep(ctx, ctr.Fail(err)); err != nil {
+			return err
+		}
+	}
+}
+
+// register grants a lease, writes the key and keeps the lease alive until ctx
+// is canceled or an error occurs.
+func (r *EtcdRegistrar) register(
+	ctx context.Context,
+	cli etcdClient,
+	key, value string,
+	seconds int64,
+	ctr *backoff.Counter,
+) error {
+	var grant struct {
+		ID  etcdInt64 `json:"ID"`
+		TTL etcdInt64 `json:"TTL"`
+	}
+
+	if err := cli.call(
+		ctx,
+		"/v3/lease/grant",
+		map[string]any{"TTL": etcdInt64(seconds)},
+		&grant,
+	); err != nil {
+		return fmt.Errorf("unable to grant etcd lease: %w", err)
+	}
+
+	// Revoke the lease when we're done, which deletes the key immediately
+	// rather than leaving it until the lease expires.
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+		defer cancel()
+
+		cli.call(ctx, "/v3/lease/revoke", map[string]any{"ID": grant.ID}, nil)
+	}()
+
+	if err := cli.call(
+		ctx,
+		"/v3/kv/put",
+		map[string]any{
+			"key":   []byte(key),
+			"value": []byte(value),
+			"lease": grant.ID,
+		},
+		nil,
+	); err != nil {
+		return fmt.Errorf("unable to register target in etcd: %w", err)
+	}
+
+	// Reset the backoff counter now that the target has been registered.
+	ctr.Reset()
+
+	// Keep the lease alive well before it expires, allowing for a keep-alive
+	// request to fail without the registration being lost.
+	interval := time.Duration(max(grant.TTL, 1)) * time.Second / 3
+
+	for {
+		if err := linger.Sleep(ctx, interval); err != nil {
+			return err
+		}
+
+		var res struct {
+			Result struct {
+				TTL etcdInt64 `json:"TTL"`
+			} `json:"result"`
+		}
+
+		if err := cli.call(
+			ctx,
+			"/v3/lease/keepalive",
+			map[string]any{"ID": grant.ID},
+			&res,
+		); err != nil {
+			return fmt.Errorf("unable to keep etcd lease alive: %w", err)
+		}
+
+		if res.Result.TTL <= 0 {
+			return errors.New("unable to keep etcd lease alive: lease has expired")
+		}
+	}
+}
+
+// EtcdTargetDiscoverer discovers gRPC targets that are registered in etcd by
+// an EtcdRegistrar.
+//
+// It uses the JSON gateway of the etcd v3 API to watch the keys under a
+// prefix. The value of each key is the name of a target.
+type EtcdTargetDiscoverer struct {
+	// Endpoint is the URL of the etcd v3 HTTP API, such as
+	// "http://127.0.0.1:2379".
+	//
+	// If it is empty, DefaultEtcdEndpoint is used.
+	Endpoint string
+
+	// Prefix is the key prefix under which targets are registered.
+	//
+	// If it is empty, DefaultEtcdPrefix is used.
+	Prefix string
+
+	// HTTPClient is the client used to communicate with etcd.
+	//
+	// If it is nil, http.DefaultClient is used.
+	HTTPClient *http.Client
+
+	// DialOptions returns the dial options used to dial the given address.
+	DialOptions func(addr string) []grpc.DialOption
+
+	// TLS is the TLS configuration used to dial the discovered targets.
+	//
+	// The server certificate is verified against the host part of the target
+	// name. If TLS is nil, only the options returned by DialOptions are used.
+	TLS *TLSConfig
+
+	// BackoffStrategy is the strategy that determines when to retry watching
+	// etcd after an error.
+	BackoffStrategy backoff.Strategy
+
+	// LogError is an optional function that logs errors that occur while
+	// watching etcd.
+	//
+	// The discoverer retries after an error, and continues to report the
+	// targets that were last known to be registered in the meantime.
+	LogError func(error)
+}
+
+// DiscoverTargets invokes an observer for each gRPC target that is discovered.
+//
+// It runs until ctx is canceled or an error occurs.
+//
+// The context passed to the observer is canceled when the target becomes
+// unavailable or the discover is stopped.
+//
+// The discoverer MAY block on calls to the observer. It is the observer's
+// responsibility to start new goroutines to handle background tasks, as
+// appropriate.
+func (d *EtcdTargetDiscoverer) DiscoverTargets(ctx context.Context, obs TargetObserver) error {
+	cli := etcdClient{d.Endpoint, d.HTTPClient}
+
+	ctr := &backoff.Counter{
+		Strategy: d.BackoffStrategy,
+	}
+
+	set := targetSet{}
+	defer set.cancelAll()
+
+	for {
+		err := d.watch(ctx, cli, ctr, set, obs)
+
+		// If the parent context has been canceled we don't really care what
+		// happens. Bail here before we log it.
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if d.LogError != nil {
+			d.LogError(err)
+		}
+
+		if err := linger.Sleep(ctx, ctr.Fail(err)); err != nil {
+			return err
+		}
+	}
+}
+
+// etcdKeyValue is a key/value pair in a response from the etcd v3 API.
+type etcdKeyValue struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// watch reads the current keys under the prefix, then watches for changes
+// until ctx is canceled or an error occurs.
+func (d *EtcdTargetDiscoverer) watch(
+	ctx context.Context,
+	cli etcdClient,
+	ctr *backoff.Counter,
+	set targetSet,
+	obs TargetObserver,
+) error {
+	prefix := []byte(etcdPrefix(d.Prefix))
+	rangeEnd := etcdPrefixEnd(prefix)
+
+	var snapshot struct {
+		Header struct {
+			Revision etcdInt64 `json:"revision"`
+		} `json:"header"`
+		KVs []etcdKeyValue `json:"kvs"`
+	}
+
+	if err := cli.call(
+		ctx,
+		"/v3/kv/range",
+		map[string]any{
+			"key":       prefix,
+			"range_end": rangeEnd,
+		},
+		&snapshot,
+	); err != nil {
+		return fmt.Errorf("unable to read targets from etcd: %w", err)
+	}
+
+	targets := map[string]Target{}
+	for _, kv := range snapshot.KVs {
+		targets[string(kv.Key)] = registeredTarget(string(kv.Value), d.DialOptions, d.TLS)
+	}
+
+	set.sync(ctx, targets, obs)
+
+	// Create a cancellable context specifically to abort the watch stream when
+	// this function returns.
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	body, err := cli.stream(
+		streamCtx,
+		"/v3/watch",
+		map[string]any{
+			"create_request": map[string]any{
+				"key":            prefix,
+				"range_end":      rangeEnd,
+				"start_revision": snapshot.Header.Revision + 1,
+			},
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("unable to watch targets in etcd: %w", err)
+	}
+	defer body.Close()
+
+	dec := json.NewDecoder(body)
+
+	for {
+		var res struct {
+			Result struct {
+				Canceled     bool   `json:"canceled"`
+				CancelReason string `json:"cancel_reason"`
+				Events       []struct {
+					Type string       `json:"type"`
+					KV   etcdKeyValue `json:"kv"`
+				} `json:"events"`
+			} `json:"result"`
+			Error *etcdError `json:"error"`
+		}
+
+		if err := dec.Decode(&res); err != nil {
+			return fmt.Errorf("unable to watch targets in etcd: %w", err)
+		}
+
+		if res.Error != nil {
+			return fmt.Errorf("unable to watch targets in etcd: %w", res.Error)
+		}
+
+		if res.Result.Canceled {
+			// The watch is canceled by the server if the revision we asked for
+			// has been compacted, among other reasons. Start again with a new
+			// snapshot.
+			return fmt.Errorf(
+				"unable to watch targets in etcd: watch canceled by server: %s",
+				res.Result.CancelReason,
+			)
+		}
+
+		// Reset the backoff counter now that we've received a response from
+		// the server.
+		ctr.Reset()
+
+		for _, ev := range res.Result.Events {
+			if ev.Type == "DELETE" {
+				delete(targets, string(ev.KV.Key))
+			} else {
+				targets[string(ev.KV.Key)] = registeredTarget(string(ev.KV.Value), d.DialOptions, d.TLS)
+			}
+		}
+
+		set.sync(ctx, targets, obs)
+	}
+}
+
+// etcdPrefix returns the key prefix to use, given the configured prefix.
+func etcdPrefix(p string) string {
+	if p == "" {
+		return DefaultEtcdPrefix
+	}
+	return p
+}
+
+// etcdPrefixEnd returns the end of the key range that contains all keys with
+// the given prefix.
+func etcdPrefixEnd(prefix []byte) []byte {
+	end := bytes.Clone(prefix)
+
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+
+	// The prefix consists entirely of 0xff bytes, so there is no upper bound.
+	return []byte{0}
+}
+
+// etcdClient is a client for the JSON gateway of the etcd v3 API.
+type etcdClient struct {
+	endpoint string
+	http     *http.Client
+}
+
+// call performs a unary RPC and decodes the response into res, if it is
+// non-nil.
+func (c etcdClient) call(ctx context.Context, path string, req, res any) error {
+	body, err := c.stream(ctx, path, req)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	if res == nil {
+		return nil
+	}
+
+	return json.NewDecoder(body).Decode(res)
+}
+
+// stream performs an RPC and returns the response body.
+func (c etcdClient) stream(ctx context.Context, path string, req any) (io.ReadCloser, error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := c.endpoint
+	if endpoint == "" {
+		endpoint = DefaultEtcdEndpoint
+	}
+
+	r, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost,
+		strings.TrimSuffix(endpoint, "/")+path,
+		bytes.NewReader(data),
+	)
+	if err != nil {
+		return nil, err
+	}
+	r.Header.Set("Content-Type", "application/json")
+
+	cli := c.http
+	if cli == nil {
+		cli = http.DefaultClient
+	}
+
+	res, err := cli.Do(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		defer res.Body.Close()
+
+		e := &etcdError{}
+		if err := json.NewDecoder(res.Body).Decode(e); err != nil || e.Message == "" {
+			e.Message = res.Status
+		}
+
+		return nil, e
+	}
+
+	return res.Body, nil
+}
+
+// etcdError is an error returned by the etcd v3 API.
+type etcdError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *etcdError) Error() string {
+	return e.Message
+}
+
+// etcdInt64 is an int64 that is encoded as a JSON string, as per the JSON
+// mapping of protocol buffers, but may be decoded from a string or a number.
+type etcdInt64 int64
+
+// MarshalJSON returns the JSON representation of i.
+func (i etcdInt64) MarshalJSON() ([]byte, error) {
+	return json.Marshal(strconv.FormatInt(int64(i), 10))
+}
+
+// UnmarshalJSON sets i to the value in data.
+func (i *etcdInt64) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	if s == "" || s == "null" {
+		*i = 0
+		return nil
+	}
+
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	*i = etcdInt64(v)
+	return nil
+}
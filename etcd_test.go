@@ -0,0 +1,719 @@
+package discoverkit_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/dogmatiq/discoverkit"
+	"github.com/dogmatiq/linger/backoff"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("type EtcdRegistrar", func() {
+	var (
+		ctx  context.Context
+		etcd *etcdServerStub
+		reg  *EtcdRegistrar
+	)
+
+	BeforeEach(func() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+		DeferCleanup(cancel)
+
+		etcd = &etcdServerStub{}
+		etcd.Start()
+
+		reg = &EtcdRegistrar{
+			Endpoint:        etcd.URL,
+			LeaseTTL:        1 * time.Second,
+			BackoffStrategy: backoff.Constant(10 * time.Millisecond),
+		}
+	})
+
+	// start runs the registrar in the background, returning a function that
+	// stops it and returns its error.
+	start := func(t Target) func() error {
+		ctx, cancel := context.WithCancel(ctx)
+		reg := reg
+		done := make(chan struct{})
+		var err error
+
+		go func() {
+			defer close(done)
+			err = reg.Register(ctx, t)
+		}()
+
+		stop := func() error {
+			cancel()
+			<-done
+			return err
+		}
+
+		DeferCleanup(func() {
+			stop()
+		})
+
+		return stop
+	}
+
+	Describe("func Register()", func() {
+		It("registers the target under the prefix with a lease", func() {
+			start(Target{Name: "engine.example.org:50555"})
+
+			Eventually(etcd.Values).Should(Equal(map[string]string{
+				"/dogma/targets/engine.example.org:50555": "engine.example.org:50555",
+			}))
+			Expect(etcd.LeasedKeys()).To(ConsistOf("/dogma/targets/engine.example.org:50555"))
+		})
+
+		It("allows use of a custom prefix", func() {
+			reg.Prefix = "/custom/"
+			start(Target{Name: "engine.example.org:50555"})
+
+			Eventually(etcd.Values).Should(HaveKey("/custom/engine.example.org:50555"))
+		})
+
+		It("keeps the lease alive", func() {
+			start(Target{Name: "engine.example.org:50555"})
+
+			Eventually(etcd.KeepAlives, 2*time.Second).Should(BeNumerically(">=", 2))
+			Expect(etcd.Values()).To(HaveLen(1))
+		})
+
+		It("revokes the lease when the context is canceled", func() {
+			stop := start(Target{Name: "engine.example.org:50555"})
+
+			Eventually(etcd.Values).Should(HaveLen(1))
+
+			err := stop()
+			Expect(err).To(Equal(context.Canceled))
+			Expect(etcd.Values()).To(BeEmpty())
+		})
+
+		It("registers the target again if the lease expires", func() {
+			var (
+				m    sync.Mutex
+				errs []error
+			)
+
+			reg.LogError = func(err error) {
+				m.Lock()
+				defer m.Unlock()
+				errs = append(errs, err)
+			}
+
+			start(Target{Name: "engine.example.org:50555"})
+
+			Eventually(etcd.Values).Should(HaveLen(1))
+			etcd.ExpireLeases()
+			Expect(etcd.Values()).To(BeEmpty())
+
+			Eventually(etcd.Values, 2*time.Second).Should(HaveLen(1))
+
+			m.Lock()
+			defer m.Unlock()
+			Expect(errs).To(ContainElement(
+				MatchError("unable to keep etcd lease alive: lease has expired"),
+			))
+		})
+
+		It("retries if etcd responds with an error", func() {
+			errs := make(chan error, 10)
+			reg.LogError = func(err error) {
+				errs <- err
+			}
+
+			etcd.Fail("etcdserver: no leader")
+			start(Target{Name: "engine.example.org:50555"})
+
+			var err error
+			Eventually(errs).Should(Receive(&err))
+			Expect(err).To(MatchError("unable to grant etcd lease: etcdserver: no leader"))
+
+			etcd.Fail("")
+			Eventually(etcd.Values).Should(HaveLen(1))
+		})
+	})
+})
+
+var _ = Describe("type EtcdTargetDiscoverer", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+		etcd   *etcdServerStub
+		disc   *EtcdTargetDiscoverer
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 2*time.Second)
+		DeferCleanup(cancel)
+
+		etcd = &etcdServerStub{}
+		etcd.Start()
+		etcd.Put("/dogma/targets/a", "a.example.org:50555")
+		etcd.Put("/dogma/targets/b", "b.example.org:50555")
+		etcd.Put("/dogma/other", "other.example.org:50555")
+
+		disc = &EtcdTargetDiscoverer{
+			Endpoint:        etcd.URL,
+			BackoffStrategy: backoff.Constant(10 * time.Millisecond),
+		}
+	})
+
+	Describe("func DiscoverTargets()", func() {
+		It("invokes the observer for each key under the prefix", func() {
+			var actual []Target
+
+			err := disc.DiscoverTargets(
+				ctx,
+				func(
+					_ context.Context,
+					t Target,
+				) {
+					actual = append(actual, t)
+
+					if len(actual) == 2 {
+						cancel()
+					}
+				},
+			)
+
+			Expect(err).To(Equal(context.Canceled))
+			Expect(actual).To(ConsistOf(
+				Target{Name: "a.example.org:50555"},
+				Target{Name: "b.example.org:50555"},
+			))
+		})
+
+		It("invokes the observer when a key is added under the prefix", func() {
+			targets := make(chan Target, 10)
+
+			runTargetDiscoverer(ctx, cancel, disc, func(_ context.Context, t Target) {
+				targets <- t
+			})
+
+			Eventually(targets).Should(Receive())
+			Eventually(targets).Should(Receive())
+			Eventually(etcd.Watchers).Should(Equal(1))
+
+			etcd.Put("/dogma/other2", "other2.example.org:50555")
+			etcd.Put("/dogma/targets/c", "c.example.org:50555")
+
+			Eventually(targets).Should(Receive(Equal(Target{Name: "c.example.org:50555"})))
+			Consistently(targets, 50*time.Millisecond).ShouldNot(Receive())
+		})
+
+		It("cancels the context when a key is deleted", func() {
+			contexts := make(chan context.Context, 10)
+
+			runTargetDiscoverer(ctx, cancel, disc, func(c context.Context, _ Target) {
+				contexts <- c
+			})
+
+			var ctxA, ctxB context.Context
+			Eventually(contexts).Should(Receive(&ctxA))
+			Eventually(contexts).Should(Receive(&ctxB))
+			Eventually(etcd.Watchers).Should(Equal(1))
+
+			etcd.Delete("/dogma/targets/a")
+
+			Eventually(ctxA.Done()).Should(BeClosed())
+			Consistently(ctxB.Done(), 50*time.Millisecond).ShouldNot(BeClosed())
+		})
+
+		It("replaces the target when the value of a key changes", func() {
+			contexts := make(chan context.Context, 10)
+			targets := make(chan Target, 10)
+
+			runTargetDiscoverer(ctx, cancel, disc, func(c context.Context, t Target) {
+				contexts <- c
+				targets <- t
+			})
+
+			var ctxA context.Context
+			Eventually(contexts).Should(Receive(&ctxA))
+			Eventually(targets).Should(Receive())
+			Eventually(targets).Should(Receive())
+			Eventually(etcd.Watchers).Should(Equal(1))
+
+			etcd.Put("/dogma/targets/a", "a2.example.org:50555")
+
+			Eventually(targets).Should(Receive(Equal(Target{Name: "a2.example.org:50555"})))
+			Expect(ctxA.Done()).To(BeClosed())
+		})
+
+		It("watches again if the watch stream ends", func() {
+			errs := make(chan error, 10)
+			disc.LogError = func(err error) {
+				errs <- err
+			}
+
+			contexts := make(chan context.Context, 10)
+			targets := make(chan Target, 10)
+
+			runTargetDiscoverer(ctx, cancel, disc, func(c context.Context, t Target) {
+				contexts <- c
+				targets <- t
+			})
+
+			var ctxA context.Context
+			Eventually(contexts).Should(Receive(&ctxA))
+			Eventually(targets).Should(Receive())
+			Eventually(targets).Should(Receive())
+			Eventually(etcd.Watchers).Should(Equal(1))
+
+			etcd.Fail("etcdserver: no leader")
+			etcd.CloseWatches()
+			Eventually(errs).Should(Receive())
+
+			etcd.Delete("/dogma/targets/b")
+			etcd.Put("/dogma/targets/c", "c.example.org:50555")
+			etcd.Fail("")
+
+			Eventually(targets).Should(Receive(Equal(Target{Name: "c.example.org:50555"})))
+			Expect(ctxA.Err()).ShouldNot(HaveOccurred())
+		})
+
+		It("discovers targets registered by an EtcdRegistrar", func() {
+			reg := &EtcdRegistrar{
+				Endpoint: etcd.URL,
+				LeaseTTL: 1 * time.Second,
+			}
+
+			regCtx, stop := context.WithCancel(ctx)
+			registered := make(chan struct{})
+
+			go func() {
+				defer close(registered)
+				reg.Register(regCtx, Target{Name: "engine.example.org:50555"})
+			}()
+
+			type discovered struct {
+				Ctx    context.Context
+				Target Target
+			}
+
+			observed := make(chan discovered, 10)
+
+			runTargetDiscoverer(ctx, cancel, disc, func(c context.Context, t Target) {
+				observed <- discovered{c, t}
+			})
+
+			var d discovered
+			Eventually(observed).Should(Receive(&d))
+			Eventually(observed).Should(Receive(&d))
+			Eventually(observed).Should(Receive(&d))
+			Expect(d.Target).To(Equal(Target{Name: "engine.example.org:50555"}))
+
+			stop()
+			Eventually(registered).Should(BeClosed())
+			Eventually(d.Ctx.Done()).Should(BeClosed())
+		})
+	})
+})
+
+// etcdServerStub is a fake implementation of the JSON gateway of the etcd v3
+// API, supporting leases, prefix ranges and watches.
+type etcdServerStub struct {
+	// URL is the base URL of the server, set by Start().
+	URL string
+
+	m          sync.Mutex
+	revision   int64
+	leaseID    int64
+	leases     map[int64]struct{}
+	kvs        map[string]etcdStubKeyValue
+	history    []etcdStubEvent
+	changed    chan struct{}
+	closed     chan struct{}
+	watchers   int
+	keepAlives int
+	failure    string
+}
+
+type etcdStubKeyValue struct {
+	value string
+	lease int64
+}
+
+type etcdStubEvent struct {
+	revision int64
+	deleted  bool
+	key      string
+	value    string
+}
+
+// Start starts the server. It is stopped when the current spec ends.
+func (s *etcdServerStub) Start() {
+	s.leases = map[int64]struct{}{}
+	s.kvs = map[string]etcdStubKeyValue{}
+	s.changed = make(chan struct{})
+	s.closed = make(chan struct{})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v3/lease/grant", s.grant)
+	mux.HandleFunc("POST /v3/lease/keepalive", s.keepAlive)
+	mux.HandleFunc("POST /v3/lease/revoke", s.revoke)
+	mux.HandleFunc("POST /v3/kv/put", s.put)
+	mux.HandleFunc("POST /v3/kv/range", s.rangeKeys)
+	mux.HandleFunc("POST /v3/watch", s.watch)
+
+	server := httptest.NewServer(s.failable(mux))
+	DeferCleanup(func() {
+		s.CloseWatches()
+		server.Close()
+	})
+
+	s.URL = server.URL
+}
+
+// Put sets the value of a key without a lease.
+func (s *etcdServerStub) Put(key, value string) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.set(key, value, 0)
+}
+
+// Delete removes a key.
+func (s *etcdServerStub) Delete(key string) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.remove(key)
+}
+
+// Values returns the value of each key.
+func (s *etcdServerStub) Values() map[string]string {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	values := map[string]string{}
+	for k, kv := range s.kvs {
+		values[k] = kv.value
+	}
+	return values
+}
+
+// LeasedKeys returns the keys that are attached to a lease.
+func (s *etcdServerStub) LeasedKeys() []string {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	var keys []string
+	for k, kv := range s.kvs {
+		if kv.lease != 0 {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// ExpireLeases expires all leases, deleting their keys.
+func (s *etcdServerStub) ExpireLeases() {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	for id := range s.leases {
+		s.revokeLease(id)
+	}
+}
+
+// KeepAlives returns the number of keep-alive requests received.
+func (s *etcdServerStub) KeepAlives() int {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return s.keepAlives
+}
+
+// Watchers returns the number of open watch streams.
+func (s *etcdServerStub) Watchers() int {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return s.watchers
+}
+
+// Fail causes all requests to fail with the given message. If message is
+// empty requests succeed again.
+func (s *etcdServerStub) Fail(message string) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.failure = message
+}
+
+// CloseWatches ends all open watch streams.
+func (s *etcdServerStub) CloseWatches() {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	close(s.closed)
+	s.closed = make(chan struct{})
+}
+
+// set sets the value of a key. s.m must be locked.
+func (s *etcdServerStub) set(key, value string, lease int64) {
+	s.kvs[key] = etcdStubKeyValue{value, lease}
+	s.record(etcdStubEvent{key: key, value: value})
+}
+
+// remove deletes a key. s.m must be locked.
+func (s *etcdServerStub) remove(key string) {
+	if _, ok := s.kvs[key]; ok {
+		delete(s.kvs, key)
+		s.record(etcdStubEvent{key: key, deleted: true})
+	}
+}
+
+// revokeLease revokes a lease and deletes its keys. s.m must be locked.
+func (s *etcdServerStub) revokeLease(id int64) {
+	delete(s.leases, id)
+
+	for k, kv := range s.kvs {
+		if kv.lease == id {
+			s.remove(k)
+		}
+	}
+}
+
+// record adds an event to the history. s.m must be locked.
+func (s *etcdServerStub) record(ev etcdStubEvent) {
+	s.revision++
+	ev.revision = s.revision
+	s.history = append(s.history, ev)
+
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func (s *etcdServerStub) failable(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.m.Lock()
+		failure := s.failure
+		s.m.Unlock()
+
+		if failure != "" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(map[string]any{
+				"code":    14,
+				"message": failure,
+			})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// etcdStubRequest is the union of the fields in the requests handled by
+// etcdServerStub.
+type etcdStubRequest struct {
+	ID            string `json:"ID"`
+	TTL           string `json:"TTL"`
+	Key           []byte `json:"key"`
+	RangeEnd      []byte `json:"range_end"`
+	Value         []byte `json:"value"`
+	Lease         string `json:"lease"`
+	CreateRequest *struct {
+		Key           []byte `json:"key"`
+		RangeEnd      []byte `json:"range_end"`
+		StartRevision string `json:"start_revision"`
+	} `json:"create_request"`
+}
+
+func decodeEtcdStubRequest(r *http.Request) etcdStubRequest {
+	var req etcdStubRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	Expect(err).ShouldNot(HaveOccurred())
+	return req
+}
+
+func parseEtcdStubInt(s string) int64 {
+	if s == "" {
+		return 0
+	}
+
+	v, err := strconv.ParseInt(s, 10, 64)
+	Expect(err).ShouldNot(HaveOccurred())
+	return v
+}
+
+func (s *etcdServerStub) grant(w http.ResponseWriter, r *http.Request) {
+	req := decodeEtcdStubRequest(r)
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.leaseID++
+	s.leases[s.leaseID] = struct{}{}
+
+	json.NewEncoder(w).Encode(map[string]any{
+		"ID":  strconv.FormatInt(s.leaseID, 10),
+		"TTL": req.TTL,
+	})
+}
+
+func (s *etcdServerStub) keepAlive(w http.ResponseWriter, r *http.Request) {
+	req := decodeEtcdStubRequest(r)
+	id := parseEtcdStubInt(req.ID)
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.keepAlives++
+
+	ttl := "0"
+	if _, ok := s.leases[id]; ok {
+		ttl = "1"
+	}
+
+	json.NewEncoder(w).Encode(map[string]any{
+		"result": map[string]any{
+			"ID":  req.ID,
+			"TTL": ttl,
+		},
+	})
+}
+
+func (s *etcdServerStub) revoke(w http.ResponseWriter, r *http.Request) {
+	req := decodeEtcdStubRequest(r)
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.revokeLease(parseEtcdStubInt(req.ID))
+	w.Write([]byte("{}"))
+}
+
+func (s *etcdServerStub) put(w http.ResponseWriter, r *http.Request) {
+	req := decodeEtcdStubRequest(r)
+	lease := parseEtcdStubInt(req.Lease)
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if _, ok := s.leases[lease]; lease != 0 && !ok {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]any{
+			"code":    5,
+			"message": "etcdserver: requested lease not found",
+		})
+		return
+	}
+
+	s.set(string(req.Key), string(req.Value), lease)
+	w.Write([]byte("{}"))
+}
+
+func (s *etcdServerStub) rangeKeys(w http.ResponseWriter, r *http.Request) {
+	req := decodeEtcdStubRequest(r)
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	kvs := []any{}
+	for k, kv := range s.kvs {
+		if inEtcdStubRange(k, req.Key, req.RangeEnd) {
+			kvs = append(kvs, map[string]any{
+				"key":   []byte(k),
+				"value": []byte(kv.value),
+			})
+		}
+	}
+
+	json.NewEncoder(w).Encode(map[string]any{
+		"header": map[string]any{
+			"revision": strconv.FormatInt(s.revision, 10),
+		},
+		"kvs": kvs,
+	})
+}
+
+func (s *etcdServerStub) watch(w http.ResponseWriter, r *http.Request) {
+	req := decodeEtcdStubRequest(r)
+	Expect(req.CreateRequest).NotTo(BeNil())
+
+	next := parseEtcdStubInt(req.CreateRequest.StartRevision)
+
+	s.m.Lock()
+	s.watchers++
+	closed := s.closed
+	s.m.Unlock()
+
+	defer func() {
+		s.m.Lock()
+		s.watchers--
+		s.m.Unlock()
+	}()
+
+	enc := json.NewEncoder(w)
+	enc.Encode(map[string]any{
+		"result": map[string]any{"created": true},
+	})
+	w.(http.Flusher).Flush()
+
+	for {
+		s.m.Lock()
+		changed := s.changed
+
+		var events []any
+		for _, ev := range s.history {
+			if ev.revision < next {
+				continue
+			}
+
+			next = ev.revision + 1
+
+			if !inEtcdStubRange(ev.key, req.CreateRequest.Key, req.CreateRequest.RangeEnd) {
+				continue
+			}
+
+			e := map[string]any{
+				"kv": map[string]any{
+					"key":   []byte(ev.key),
+					"value": []byte(ev.value),
+				},
+			}
+			if ev.deleted {
+				e["type"] = "DELETE"
+			}
+			events = append(events, e)
+		}
+		s.m.Unlock()
+
+		if len(events) != 0 {
+			enc.Encode(map[string]any{
+				"result": map[string]any{"events": events},
+			})
+			w.(http.Flusher).Flush()
+		}
+
+		select {
+		case <-changed:
+		case <-closed:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// inEtcdStubRange returns true if key is within the range [start, end).
+func inEtcdStubRange(key string, start, end []byte) bool {
+	if len(end) == 0 {
+		return key == string(start)
+	}
+
+	return strings.Compare(key, string(start)) >= 0 &&
+		(string(end) == "\x00" || strings.Compare(key, string(end)) < 0)
+}
@@ -0,0 +1,196 @@
+package discoverkit
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sort"
+
+	"github.com/dogmatiq/configkit"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// OriginMetadataKey is the gRPC header metadata key that a Server uses to
+	// advertise its federation origins, which are the IDs of the
+	// FederationBridge instances that re-advertise applications on the server.
+	OriginMetadataKey = "dogma-discover-origin"
+
+	// BridgeMetadataKey is the gRPC request metadata key that a
+	// FederationBridge uses to identify itself when it watches an upstream
+	// target.
+	BridgeMetadataKey = "dogma-discover-bridge"
+)
+
+// FederationBridge re-advertises applications that are discovered on
+// upstream gRPC targets via a Server.
+//
+// It allows a gateway to make applications from one network available to
+// clients on another network that can only reach the gateway.
+//
+// An application is advertised on the server for as long as it is available
+// on at least one upstream target.
+//
+// Each bridge has a unique ID, which the server advertises as a federation
+// origin. The server records the origins of each upstream target alongside
+// each application that the bridge discovers on that target, and never sends
+// an application to a bridge that it was re-advertised through. This prevents
+// applications from being re-advertised in a loop when gateways discover each
+// other.
+//
+// Loops are only detected between servers whose bridges discover each other
+// directly. Bridges must not be arranged in a longer cycle.
+type FederationBridge struct {
+	// ID uniquely identifies this bridge among all bridges that may discover
+	// each other.
+	ID string
+
+	// Server is the server on which discovered applications are
+	// re-advertised.
+	Server *Server
+
+	// Targets is the discoverer used to discover upstream targets.
+	Targets TargetDiscoverer
+
+	// Discoverer is used to discover applications on each upstream target.
+	//
+	// If it is nil, a zero-value ApplicationDiscoverer is used.
+	Discoverer *ApplicationDiscoverer
+}
+
+// Run discovers applications on the upstream targets and re-advertises them
+// via b.Server until ctx is canceled or an error occurs.
+func (b *FederationBridge) Run(ctx context.Context) error {
+	if b.ID == "" {
+		return errors.New("federation bridge ID must not be empty")
+	}
+
+	d := b.Discoverer
+	if d == nil {
+		d = &ApplicationDiscoverer{}
+	}
+
+	b.Server.addOrigin(b.ID)
+	defer b.Server.removeOrigin(b.ID)
+
+	// Identify this bridge to the upstream targets so that they do not send
+	// applications that were re-advertised through it.
+	ctx = metadata.AppendToOutgoingContext(ctx, BridgeMetadataKey, b.ID)
+
+	return d.DiscoverApplicationsOnTargets(ctx, b.Targets, b.observe)
+}
+
+// observe is the ApplicationObserver that re-advertises each discovered
+// application.
+func (b *FederationBridge) observe(ctx context.Context, a Application) {
+	// The application has passed through this bridge, and through the bridges
+	// that feed the target on which it was discovered.
+	origins := []string{b.ID}
+	for _, o := range a.Metadata.Get(OriginMetadataKey) {
+		if !slices.Contains(origins, o) {
+			origins = append(origins, o)
+		}
+	}
+	sort.Strings(origins)
+
+	r := b.Server.addRoute(a.Identity, origins)
+
+	go func() {
+		<-ctx.Done()
+		b.Server.removeRoute(r)
+	}()
+}
+
+// addRoute makes an application available on the server via a federation
+// bridge.
+//
+// The application remains available until removeRoute() is called with the
+// returned route, and there are no other routes to the application and it is
+// not available locally.
+func (s *Server) addRoute(id configkit.Identity, origins []string) *applicationRoute {
+	if err := id.Validate(); err != nil {
+		panic(err)
+	}
+
+	r := &applicationRoute{
+		identity: id,
+		origins:  origins,
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.sourcesOf(id.Key).routes[r] = struct{}{}
+	s.advertise(id.Key)
+
+	return r
+}
+
+// removeRoute removes a route that was added by addRoute().
+func (s *Server) removeRoute(r *applicationRoute) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if src, ok := s.sources[r.identity.Key]; ok {
+		delete(src.routes, r)
+		s.advertise(r.identity.Key)
+	}
+}
+
+// addOrigin adds a federation origin to the server.
+//
+// If the origin is new, watchers that are themselves federation bridges are
+// disconnected so that they reconnect and see the new origin.
+func (s *Server) addOrigin(origin string) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.origins == nil {
+		s.origins = map[string]int{}
+	}
+
+	s.origins[origin]++
+
+	if s.origins[origin] == 1 {
+		s.originsVersion++
+		s.notify()
+	}
+}
+
+// removeOrigin removes a federation origin that was added by addOrigin().
+//
+// Existing watchers are not disconnected, a stale origin can only cause the
+// server to withhold applications from a bridge that it would otherwise send.
+func (s *Server) removeOrigin(origin string) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.origins[origin]--
+
+	if s.origins[origin] == 0 {
+		delete(s.origins, origin)
+	}
+}
+
+// originList returns the sorted list of federation origins, and the origins
+// version.
+func (s *Server) originList() ([]string, uint64) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	origins := make([]string, 0, len(s.origins))
+	for o := range s.origins {
+		origins = append(origins, o)
+	}
+
+	sort.Strings(origins)
+
+	return origins, s.originsVersion
+}
+
+// watcherBridges returns the IDs of the federation bridges that identified
+// themselves in the request metadata of a WatchApplications() call.
+func watcherBridges(ctx context.Context) []string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	return md.Get(BridgeMetadataKey)
+}
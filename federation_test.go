@@ -0,0 +1,242 @@
+package discoverkit_test
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/dogmatiq/configkit"
+	. "github.com/dogmatiq/discoverkit"
+	"github.com/dogmatiq/interopspec/discoverspec"
+	"github.com/dogmatiq/linger/backoff"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+var _ = Describe("type FederationBridge", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+		app    configkit.Identity
+		errors atomic.Int64
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		DeferCleanup(cancel)
+
+		app = configkit.MustNewIdentity("<app-name>", appKey)
+		errors.Store(0)
+	})
+
+	// serve starts a gRPC server that serves s, and returns a target that
+	// refers to it.
+	serve := func(s *Server) Target {
+		listener, err := net.Listen("tcp", "127.0.0.1:")
+		Expect(err).ShouldNot(HaveOccurred())
+
+		gserver := grpc.NewServer()
+		discoverspec.RegisterDiscoverAPIServer(gserver, s)
+		go gserver.Serve(listener)
+		DeferCleanup(gserver.Stop)
+
+		return Target{
+			Name: listener.Addr().String(),
+			DialOptions: []grpc.DialOption{
+				grpc.WithTransportCredentials(insecure.NewCredentials()),
+			},
+		}
+	}
+
+	// run starts a bridge in the background. Any errors that occur while
+	// watching the upstream targets are counted in "errors".
+	run := func(b *FederationBridge) {
+		b.Discoverer = &ApplicationDiscoverer{
+			BackoffStrategy: backoff.Constant(10 * time.Millisecond),
+			LogError: func(Target, error) {
+				errors.Add(1)
+			},
+		}
+
+		runInBackground(cancel, func() {
+			b.Run(ctx)
+		})
+	}
+
+	Describe("func Run()", func() {
+		It("re-advertises applications that are available on any upstream target", func() {
+			upstream1 := &Server{}
+			upstream2 := &Server{}
+			downstream := &Server{}
+
+			run(&FederationBridge{
+				ID:     "<bridge>",
+				Server: downstream,
+				Targets: StaticTargetDiscoverer{
+					serve(upstream1),
+					serve(upstream2),
+				},
+			})
+
+			upstream1.Available(app)
+			upstream2.Available(app)
+			Eventually(downstream.Applications).Should(ConsistOf(app))
+
+			upstream1.Unavailable(app)
+			Consistently(downstream.Applications, 100*time.Millisecond).Should(ConsistOf(app))
+
+			upstream2.Unavailable(app)
+			Eventually(downstream.Applications).Should(BeEmpty())
+		})
+
+		It("does not re-advertise applications in a loop when bridges discover each other", func() {
+			upstream := &Server{}
+			serverA := &Server{}
+			serverB := &Server{}
+			targetA := serve(serverA)
+			targetB := serve(serverB)
+
+			run(&FederationBridge{
+				ID:     "<bridge-a>",
+				Server: serverA,
+				Targets: StaticTargetDiscoverer{
+					serve(upstream),
+					targetB,
+				},
+			})
+
+			run(&FederationBridge{
+				ID:      "<bridge-b>",
+				Server:  serverB,
+				Targets: StaticTargetDiscoverer{targetA},
+			})
+
+			upstream.Available(app)
+			Eventually(serverA.Applications).Should(ConsistOf(app))
+			Eventually(serverB.Applications).Should(ConsistOf(app))
+
+			upstream.Unavailable(app)
+			Eventually(serverA.Applications).Should(BeEmpty())
+			Eventually(serverB.Applications).Should(BeEmpty())
+			Consistently(serverA.Applications, 100*time.Millisecond).Should(BeEmpty())
+		})
+
+		It("re-advertises applications in both directions when bridges discover each other", func() {
+			appA := configkit.MustNewIdentity("<app-a>", "b1f7e3a2-4d0c-4f6e-9a8b-1c2d3e4f5a6b")
+			appB := configkit.MustNewIdentity("<app-b>", "c2a8f4b3-5e1d-4a7f-8b9c-2d3e4f5a6b7c")
+
+			serverA := &Server{}
+			serverB := &Server{}
+			targetA := serve(serverA)
+			targetB := serve(serverB)
+
+			serverA.Available(appA)
+			serverB.Available(appB)
+
+			run(&FederationBridge{
+				ID:      "<bridge-a>",
+				Server:  serverA,
+				Targets: StaticTargetDiscoverer{targetB},
+			})
+
+			run(&FederationBridge{
+				ID:      "<bridge-b>",
+				Server:  serverB,
+				Targets: StaticTargetDiscoverer{targetA},
+			})
+
+			Eventually(serverA.Applications).Should(ConsistOf(appA, appB))
+			Eventually(serverB.Applications).Should(ConsistOf(appA, appB))
+
+			// Streams that were opened before the other bridge started are
+			// disconnected once, so only count errors after the servers have
+			// converged.
+			errors.Store(0)
+
+			Consistently(serverA.Applications, 200*time.Millisecond).Should(ConsistOf(appA, appB))
+			Consistently(serverB.Applications, 200*time.Millisecond).Should(ConsistOf(appA, appB))
+			Expect(errors.Load()).To(BeZero(), "watch streams were interrupted")
+
+			serverB.Unavailable(appB)
+			Eventually(serverA.Applications).Should(ConsistOf(appA))
+			Eventually(serverB.Applications).Should(ConsistOf(appA))
+		})
+
+		It("advertises the bridge ID as a federation origin of the server", func() {
+			upstream := &Server{}
+			downstream := &Server{}
+
+			run(&FederationBridge{
+				ID:      "<bridge>",
+				Server:  downstream,
+				Targets: StaticTargetDiscoverer{serve(upstream)},
+			})
+
+			// Wait until the bridge has re-advertised the application, so
+			// that its ID has been added to the server's origins.
+			upstream.Available(app)
+			Eventually(downstream.Applications).Should(ConsistOf(app))
+
+			apps := make(chan Application, 10)
+			d := &ApplicationDiscoverer{
+				BackoffStrategy: backoff.Constant(10 * time.Millisecond),
+			}
+			runInBackground(cancel, func() {
+				d.DiscoverApplications(
+					ctx,
+					serve(downstream),
+					func(_ context.Context, a Application) {
+						apps <- a
+					},
+				)
+			})
+
+			Eventually(apps).Should(Receive(HaveField(
+				"Metadata",
+				HaveKeyWithValue(OriginMetadataKey, []string{"<bridge>"}),
+			)))
+		})
+
+		It("keeps an application available while any upstream target advertises its key", func() {
+			upstream1 := &Server{}
+			upstream2 := &Server{}
+			downstream := &Server{}
+
+			run(&FederationBridge{
+				ID:     "<bridge>",
+				Server: downstream,
+				Targets: StaticTargetDiscoverer{
+					serve(upstream1),
+					serve(upstream2),
+				},
+			})
+
+			renamed := configkit.MustNewIdentity("<renamed-app>", appKey)
+
+			upstream1.Available(app)
+			Eventually(downstream.Applications).Should(ConsistOf(app))
+
+			upstream2.Available(renamed)
+			Consistently(downstream.Applications, 100*time.Millisecond).Should(HaveLen(1))
+
+			upstream1.Unavailable(app)
+			Eventually(downstream.Applications).Should(ConsistOf(renamed))
+
+			upstream2.Unavailable(renamed)
+			Eventually(downstream.Applications).Should(BeEmpty())
+		})
+
+		It("returns an error if the ID is empty", func() {
+			b := &FederationBridge{
+				Server:  &Server{},
+				Targets: StaticTargetDiscoverer{},
+			}
+
+			err := b.Run(ctx)
+			Expect(err).To(MatchError("federation bridge ID must not be empty"))
+		})
+	})
+})
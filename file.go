@@ -0,0 +1,304 @@
+package discoverkit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/dogmatiq/linger"
+	"github.com/dogmatiq/linger/backoff"
+	"google.golang.org/grpc"
+)
+
+const (
+	// DefaultFileRegistrationTTL is the default duration for which a target
+	// registered by a FileRegistrar remains in the file without being
+	// refreshed.
+	DefaultFileRegistrationTTL = 30 * time.Second
+
+	// DefaultFilePollInterval is the default interval at which a
+	// FileTargetDiscoverer reads the file.
+	DefaultFilePollInterval = 1 * time.Second
+
+	// fileLockStaleAfter is the age after which a lock file is assumed to have
+	// been abandoned by a process that crashed while holding it.
+	fileLockStaleAfter = 10 * time.Second
+)
+
+// FileRegistrar registers gRPC targets in a file that is shared with other
+// registrars, such as a file on a volume that is mounted by several
+// containers. The targets are discovered by a FileTargetDiscoverer.
+//
+// Each registration expires unless it is refreshed, so that targets
+// registered by a process that crashes are eventually removed. The file is
+// locked while it is modified, using a lock file with the same name and a
+// ".lock" suffix.
+type FileRegistrar struct {
+	// Path is the path of the shared file.
+	Path string
+
+	// TTL is the duration for which the registration remains in the file
+	// without being refreshed. The registration is refreshed well before it
+	// expires.
+	//
+	// If it is non-positive, DefaultFileRegistrationTTL is used.
+	TTL time.Duration
+
+	// BackoffStrategy is the strategy that determines when to retry an update
+	// to the file that fails.
+	BackoffStrategy backoff.Strategy
+
+	// LogError is an optional function that logs errors that occur while
+	// updating the file.
+	LogError func(error)
+}
+
+// Register advertises t until ctx is canceled.
+//
+// Each target name should be registered by at most one registrar at a time.
+// It always returns a non-nil error.
+func (r *FileRegistrar) Register(ctx context.Context, t Target) error {
+	if r.Path == "" {
+		return errors.New("file registrar path must not be empty")
+	}
+
+	ttl := linger.MustCoalesce(r.TTL, DefaultFileRegistrationTTL)
+
+	ctr := &backoff.Counter{
+		Strategy: r.BackoffStrategy,
+	}
+
+	// Remove the registration when we're done, even though ctx has been
+	// canceled.
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), fileLockStaleAfter)
+		defer cancel()
+
+		updateRegistrationFile(ctx, r.Path, func(entries map[string]time.Time) {
+			delete(entries, t.Name)
+		})
+	}()
+
+	for {
+		delay := ttl / 3
+
+		if err := updateRegistrationFile(
+			ctx,
+			r.Path,
+			func(entries map[string]time.Time) {
+				entries[t.Name] = time.Now().Add(ttl)
+			},
+		); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			if r.LogError != nil {
+				r.LogError(err)
+			}
+
+			delay = ctr.Fail(err)
+		} else {
+			ctr.Reset()
+		}
+
+		if err := linger.Sleep(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// FileTargetDiscoverer discovers gRPC targets that are registered in a shared
+// file by a FileRegistrar.
+type FileTargetDiscoverer struct {
+	// Path is the path of the shared file.
+	Path string
+
+	// PollInterval is the interval at which the file is read.
+	//
+	// If it is non-positive, DefaultFilePollInterval is used.
+	PollInterval time.Duration
+
+	// DialOptions returns the dial options used to dial the given address.
+	DialOptions func(addr string) []grpc.DialOption
+
+	// TLS is the TLS configuration used to dial the discovered targets.
+	//
+	// The server certificate is verified against the host part of the target
+	// name. If TLS is nil, only the options returned by DialOptions are used.
+	TLS *TLSConfig
+}
+
+// DiscoverTargets invokes an observer for each gRPC target that is discovered.
+//
+// It runs until ctx is canceled or an error occurs.
+//
+// The context passed to the observer is canceled when the target becomes
+// unavailable or the discover is stopped.
+//
+// The discoverer MAY block on calls to the observer. It is the observer's
+// responsibility to start new goroutines to handle background tasks, as
+// appropriate.
+func (d *FileTargetDiscoverer) DiscoverTargets(ctx context.Context, obs TargetObserver) error {
+	if d.Path == "" {
+		return errors.New("file discoverer path must not be empty")
+	}
+
+	set := targetSet{}
+	defer set.cancelAll()
+
+	for {
+		entries, err := readRegistrationFile(d.Path)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		targets := map[string]Target{}
+
+		for name, expires := range entries {
+			if expires.After(now) {
+				targets[name] = registeredTarget(name, d.DialOptions, d.TLS)
+			}
+		}
+
+		set.sync(ctx, targets, obs)
+
+		if err := linger.Sleep(
+			ctx,
+			linger.MustCoalesce(d.PollInterval, DefaultFilePollInterval),
+		); err != nil {
+			return err
+		}
+	}
+}
+
+// registrationFileEntry is the JSON representation of a single registration
+// within a registration file.
+type registrationFileEntry struct {
+	Name    string    `json:"name"`
+	Expires time.Time `json:"expires"`
+}
+
+// readRegistrationFile returns the expiry time of each target in a
+// registration file.
+//
+// A file that does not exist contains no targets.
+func readRegistrationFile(path string) (map[string]time.Time, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return map[string]time.Time{}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to read registration file: %w", err)
+	}
+
+	var list []registrationFileEntry
+	if len(data) != 0 {
+		if err := json.Unmarshal(data, &list); err != nil {
+			return nil, fmt.Errorf("unable to read registration file: %w", err)
+		}
+	}
+
+	entries := make(map[string]time.Time, len(list))
+	for _, e := range list {
+		entries[e.Name] = e.Expires
+	}
+
+	return entries, nil
+}
+
+// updateRegistrationFile modifies the entries in a registration file while
+// holding its lock. Expired entries are removed.
+func updateRegistrationFile(
+	ctx context.Context,
+	path string,
+	fn func(entries map[string]time.Time),
+) error {
+	unlock, err := lockFile(ctx, path+".lock")
+	if err != nil {
+		return fmt.Errorf("unable to lock registration file: %w", err)
+	}
+	defer unlock()
+
+	entries, err := readRegistrationFile(path)
+	if err != nil {
+		return err
+	}
+
+	fn(entries)
+
+	now := time.Now()
+	list := make([]registrationFileEntry, 0, len(entries))
+
+	for name, expires := range entries {
+		if expires.After(now) {
+			list = append(list, registrationFileEntry{name, expires})
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file then rename it, so that discoverers never
+	// see a partially written file.
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("unable to write registration file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("unable to write registration file: %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("unable to write registration file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("unable to write registration file: %w", err)
+	}
+
+	return nil
+}
+
+// lockFile acquires an exclusive lock by creating a lock file, waiting until
+// it is available or ctx is canceled.
+//
+// A lock file that is older than fileLockStaleAfter is assumed to be
+// abandoned and is removed.
+func lockFile(ctx context.Context, path string) (unlock func(), err error) {
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(path) }, nil
+		}
+
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, err
+		}
+
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > fileLockStaleAfter {
+			os.Remove(path)
+			continue
+		}
+
+		if err := linger.Sleep(ctx, 10*time.Millisecond); err != nil {
+			return nil, err
+		}
+	}
+}
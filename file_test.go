@@ -0,0 +1,317 @@
+package discoverkit_test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/dogmatiq/discoverkit"
+	"github.com/dogmatiq/linger/backoff"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// readRegistrationFile returns the target names in a registration file.
+func readRegistrationFile(path string) []string {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	Expect(err).ShouldNot(HaveOccurred())
+
+	var entries []struct {
+		Name string `json:"name"`
+	}
+	err = json.Unmarshal(data, &entries)
+	Expect(err).ShouldNot(HaveOccurred())
+
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name)
+	}
+	return names
+}
+
+var _ = Describe("type FileRegistrar", func() {
+	var (
+		ctx  context.Context
+		path string
+		reg  *FileRegistrar
+	)
+
+	BeforeEach(func() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(context.Background(), 2*time.Second)
+		DeferCleanup(cancel)
+
+		path = filepath.Join(GinkgoT().TempDir(), "targets.json")
+
+		reg = &FileRegistrar{
+			Path:            path,
+			TTL:             300 * time.Millisecond,
+			BackoffStrategy: backoff.Constant(10 * time.Millisecond),
+		}
+	})
+
+	// register registers t in the background, returning a function that
+	// removes the registration.
+	register := func(reg *FileRegistrar, t Target) func() error {
+		ctx, cancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+		var err error
+
+		go func() {
+			defer close(done)
+			err = reg.Register(ctx, t)
+		}()
+
+		stop := func() error {
+			cancel()
+			<-done
+			return err
+		}
+
+		DeferCleanup(func() {
+			stop()
+		})
+
+		return stop
+	}
+
+	Describe("func Register()", func() {
+		It("adds the target to the file", func() {
+			register(reg, Target{Name: "engine1.example.org:50555"})
+			register(reg, Target{Name: "engine2.example.org:50555"})
+
+			Eventually(func() []string {
+				return readRegistrationFile(path)
+			}).Should(Equal([]string{
+				"engine1.example.org:50555",
+				"engine2.example.org:50555",
+			}))
+		})
+
+		It("refreshes the registration before it expires", func() {
+			register(reg, Target{Name: "engine.example.org:50555"})
+
+			Eventually(func() []string {
+				return readRegistrationFile(path)
+			}).Should(HaveLen(1))
+
+			Consistently(func() []string {
+				return readRegistrationFile(path)
+			}, 600*time.Millisecond).Should(HaveLen(1))
+		})
+
+		It("removes the target from the file when the context is canceled", func() {
+			stop := register(reg, Target{Name: "engine1.example.org:50555"})
+			register(reg, Target{Name: "engine2.example.org:50555"})
+
+			Eventually(func() []string {
+				return readRegistrationFile(path)
+			}).Should(HaveLen(2))
+
+			err := stop()
+			Expect(err).To(Equal(context.Canceled))
+			Expect(readRegistrationFile(path)).To(Equal([]string{
+				"engine2.example.org:50555",
+			}))
+		})
+
+		It("removes a stale lock file", func() {
+			lock := path + ".lock"
+			err := os.WriteFile(lock, nil, 0o644)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			stale := time.Now().Add(-1 * time.Minute)
+			err = os.Chtimes(lock, stale, stale)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			register(reg, Target{Name: "engine.example.org:50555"})
+
+			Eventually(func() []string {
+				return readRegistrationFile(path)
+			}).Should(HaveLen(1))
+		})
+
+		It("waits for a lock file that is not stale", func() {
+			lock := path + ".lock"
+			err := os.WriteFile(lock, nil, 0o644)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			register(reg, Target{Name: "engine.example.org:50555"})
+
+			Consistently(func() []string {
+				return readRegistrationFile(path)
+			}, 100*time.Millisecond).Should(BeEmpty())
+
+			err = os.Remove(lock)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			Eventually(func() []string {
+				return readRegistrationFile(path)
+			}).Should(HaveLen(1))
+		})
+
+		It("logs an error and retries if the file is malformed", func() {
+			errs := make(chan error, 10)
+			reg.LogError = func(err error) {
+				errs <- err
+			}
+
+			err := os.WriteFile(path, []byte("{"), 0o644)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			register(reg, Target{Name: "engine.example.org:50555"})
+
+			Eventually(errs).Should(Receive(MatchError(ContainSubstring("unable to read registration file"))))
+
+			err = os.Remove(path)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			Eventually(func() []string {
+				return readRegistrationFile(path)
+			}).Should(HaveLen(1))
+		})
+
+		It("returns an error if the path is empty", func() {
+			reg.Path = ""
+
+			err := reg.Register(ctx, Target{Name: "engine.example.org:50555"})
+			Expect(err).To(MatchError("file registrar path must not be empty"))
+		})
+	})
+})
+
+var _ = Describe("type FileTargetDiscoverer", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+		path   string
+		disc   *FileTargetDiscoverer
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 2*time.Second)
+		DeferCleanup(cancel)
+
+		path = filepath.Join(GinkgoT().TempDir(), "targets.json")
+
+		disc = &FileTargetDiscoverer{
+			Path:         path,
+			PollInterval: 10 * time.Millisecond,
+		}
+	})
+
+	// register registers t in the background until the current spec ends,
+	// returning a function that removes the registration.
+	register := func(t Target) context.CancelFunc {
+		ctx, cancel := context.WithCancel(ctx)
+		reg := &FileRegistrar{Path: path}
+		done := make(chan struct{})
+
+		go func() {
+			defer close(done)
+			reg.Register(ctx, t)
+		}()
+
+		stop := func() {
+			cancel()
+			<-done
+		}
+
+		DeferCleanup(stop)
+
+		return stop
+	}
+
+	Describe("func DiscoverTargets()", func() {
+		It("invokes the observer for each registered target", func() {
+			register(Target{Name: "engine1.example.org:50555"})
+			register(Target{Name: "engine2.example.org:50555"})
+
+			targets := make(chan Target, 10)
+			runTargetDiscoverer(ctx, cancel, disc, func(_ context.Context, t Target) {
+				targets <- t
+			})
+
+			var t1, t2 Target
+			Eventually(targets).Should(Receive(&t1))
+			Eventually(targets).Should(Receive(&t2))
+			Expect([]Target{t1, t2}).To(ConsistOf(
+				Target{Name: "engine1.example.org:50555"},
+				Target{Name: "engine2.example.org:50555"},
+			))
+		})
+
+		It("does not invoke the observer if the file does not exist", func() {
+			targets := make(chan Target, 10)
+			runTargetDiscoverer(ctx, cancel, disc, func(_ context.Context, t Target) {
+				targets <- t
+			})
+
+			Consistently(targets, 50*time.Millisecond).ShouldNot(Receive())
+		})
+
+		It("cancels the context when the registration ends", func() {
+			contexts := make(chan context.Context, 10)
+			runTargetDiscoverer(ctx, cancel, disc, func(c context.Context, _ Target) {
+				contexts <- c
+			})
+
+			stop := register(Target{Name: "engine.example.org:50555"})
+
+			var targetCtx context.Context
+			Eventually(contexts).Should(Receive(&targetCtx))
+
+			stop()
+
+			Eventually(targetCtx.Done()).Should(BeClosed())
+		})
+
+		It("ignores expired registrations", func() {
+			data, err := json.Marshal([]map[string]any{
+				{"name": "expired.example.org:50555", "expires": time.Now().Add(-time.Second)},
+				{"name": "current.example.org:50555", "expires": time.Now().Add(time.Hour)},
+			})
+			Expect(err).ShouldNot(HaveOccurred())
+
+			err = os.WriteFile(path, data, 0o644)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			targets := make(chan Target, 10)
+			runTargetDiscoverer(ctx, cancel, disc, func(_ context.Context, t Target) {
+				targets <- t
+			})
+
+			Eventually(targets).Should(Receive(Equal(Target{Name: "current.example.org:50555"})))
+			Consistently(targets, 50*time.Millisecond).ShouldNot(Receive())
+		})
+
+		It("uses the TLS configuration to dial the targets", func() {
+			disc.TLS = &TLSConfig{}
+			register(Target{Name: "engine.example.org:50555"})
+
+			targets := make(chan Target, 10)
+			runTargetDiscoverer(ctx, cancel, disc, func(_ context.Context, t Target) {
+				targets <- t
+			})
+
+			Eventually(targets).Should(Receive(
+				HaveField("DialOptions", HaveLen(2)), // credentials and authority
+			))
+		})
+
+		It("returns an error if the file is malformed", func() {
+			err := os.WriteFile(path, []byte("{"), 0o644)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			err = disc.DiscoverTargets(ctx, func(context.Context, Target) {
+				Fail("unexpected call")
+			})
+			Expect(err).To(MatchError(ContainSubstring("unable to read registration file")))
+		})
+	})
+})
@@ -0,0 +1,206 @@
+package discoverkit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/dogmatiq/linger"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const (
+	// DefaultHealthCheckInterval is the default interval at which targets are
+	// checked by a HealthCheckingTargetDiscoverer.
+	DefaultHealthCheckInterval = 10 * time.Second
+)
+
+// HealthCheckingTargetDiscoverer is a TargetDiscoverer that checks the health
+// of the targets discovered by another discoverer using the gRPC health
+// checking protocol, as defined by the grpc.health.v1 package.
+//
+// A target is only passed to the observer while it reports a status of
+// SERVING. The context passed to the observer is canceled when the target
+// reports any other status, or can not be checked. The observer is invoked
+// again if the target returns to the SERVING status.
+type HealthCheckingTargetDiscoverer struct {
+	// Discoverer is the discoverer used to discover the targets to check.
+	Discoverer TargetDiscoverer
+
+	// Service is the name of the service to check. If it is empty, the
+	// overall health of the server is checked.
+	Service string
+
+	// Interval is the interval at which each target is checked. It is also
+	// used as the timeout for each check.
+	//
+	// If it is non-positive, the DefaultHealthCheckInterval constant is used.
+	Interval time.Duration
+
+	// Dial is the function used to dial gRPC targets.
+	//
+	// If it is nil, grpc.DialContext() is used.
+	Dial Dialer
+
+	// LogError is an optional function that logs errors that occur while
+	// checking the health of a target.
+	LogError func(Target, error)
+}
+
+// DiscoverTargets invokes an observer for each healthy gRPC target that is
+// discovered.
+//
+// It runs until ctx is canceled or an error occurs.
+//
+// The context passed to the observer is canceled when the target becomes
+// unavailable, becomes unhealthy or the discover is stopped.
+//
+// The discoverer MAY block on calls to the observer. It is the observer's
+// responsibility to start new goroutines to handle background tasks, as
+// appropriate.
+func (d *HealthCheckingTargetDiscoverer) DiscoverTargets(ctx context.Context, obs TargetObserver) error {
+	var (
+		g sync.WaitGroup
+		m sync.Mutex
+	)
+
+	defer g.Wait()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Serialize calls to the observer, as each target is checked by a
+	// separate goroutine.
+	serialized := func(ctx context.Context, t Target) {
+		m.Lock()
+		defer m.Unlock()
+		obs(ctx, t)
+	}
+
+	return d.Discoverer.DiscoverTargets(
+		ctx,
+		func(ctx context.Context, t Target) {
+			g.Add(1)
+			go func() {
+				defer g.Done()
+				d.check(ctx, t, serialized)
+			}()
+		},
+	)
+}
+
+// check checks the health of t at regular intervals until ctx is canceled.
+func (d *HealthCheckingTargetDiscoverer) check(
+	ctx context.Context,
+	t Target,
+	obs TargetObserver,
+) {
+	dial := d.Dial
+	if dial == nil {
+		dial = grpc.DialContext
+	}
+
+	interval := d.Interval
+	if interval <= 0 {
+		interval = DefaultHealthCheckInterval
+	}
+
+	req := &grpc_health_v1.HealthCheckRequest{
+		Service: d.Service,
+	}
+
+	var (
+		conn    *grpc.ClientConn
+		cli     grpc_health_v1.HealthClient
+		healthy bool
+		stop    = func() {}
+	)
+
+	defer func() {
+		stop()
+
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+
+	for {
+		h := false
+
+		if conn == nil {
+			// We have not yet been able to dial the target. A failure to dial
+			// is treated like a failed check, so we try again after the
+			// interval.
+			c, err := dial(ctx, t.Name, t.DialOptions...)
+			if err != nil {
+				if ctx.Err() == nil {
+					d.logError(t, &WatchError{
+						Phase:  WatchPhaseDial,
+						Target: t,
+						Cause:  err,
+					})
+				}
+			} else {
+				conn = c
+				cli = grpc_health_v1.NewHealthClient(conn)
+			}
+		}
+
+		if cli != nil {
+			h = d.healthy(ctx, cli, req, interval, t)
+		}
+
+		if h && !healthy {
+			// The target has become healthy. Create a context specifically
+			// for this "healthy period", which is canceled if the target
+			// becomes unhealthy.
+			targetCtx, cancel := context.WithCancel(ctx)
+			stop = cancel
+			obs(targetCtx, t)
+		} else if !h && healthy {
+			// The target has become unhealthy.
+			stop()
+		}
+
+		healthy = h
+
+		if err := linger.Sleep(ctx, interval); err != nil {
+			return
+		}
+	}
+}
+
+// healthy returns true if the target reports that it is serving.
+func (d *HealthCheckingTargetDiscoverer) healthy(
+	ctx context.Context,
+	cli grpc_health_v1.HealthClient,
+	req *grpc_health_v1.HealthCheckRequest,
+	timeout time.Duration,
+	t Target,
+) bool {
+	checkCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	res, err := cli.Check(checkCtx, req)
+	if err != nil {
+		// Don't log the error if it was caused by the discoverer stopping.
+		if ctx.Err() == nil {
+			d.logError(t, &WatchError{
+				Phase:  WatchPhaseHealthCheck,
+				Target: t,
+				Cause:  err,
+			})
+		}
+		return false
+	}
+
+	return res.GetStatus() == grpc_health_v1.HealthCheckResponse_SERVING
+}
+
+// logError logs err to the LogError function, if present.
+func (d *HealthCheckingTargetDiscoverer) logError(t Target, err error) {
+	if d.LogError != nil {
+		d.LogError(t, err)
+	}
+}
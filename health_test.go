@@ -0,0 +1,178 @@
+package discoverkit_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"time"
+
+	. "github.com/dogmatiq/discoverkit"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+var _ = Describe("type HealthCheckingTargetDiscoverer", func() {
+	var (
+		ctx     context.Context
+		cancel  context.CancelFunc
+		hserver *health.Server
+		target  Target
+		disc    *HealthCheckingTargetDiscoverer
+		events  chan string
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 2*time.Second)
+		DeferCleanup(cancel)
+
+		hserver = health.NewServer()
+		hserver.SetServingStatus("<service>", grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+
+		listener, err := net.Listen("tcp", "127.0.0.1:")
+		Expect(err).ShouldNot(HaveOccurred())
+
+		gserver := grpc.NewServer()
+		grpc_health_v1.RegisterHealthServer(gserver, hserver)
+		go gserver.Serve(listener)
+		DeferCleanup(gserver.Stop)
+
+		target = Target{
+			Name: listener.Addr().String(),
+			DialOptions: []grpc.DialOption{
+				grpc.WithTransportCredentials(insecure.NewCredentials()),
+			},
+		}
+
+		disc = &HealthCheckingTargetDiscoverer{
+			Discoverer: StaticTargetDiscoverer{target},
+			Service:    "<service>",
+			Interval:   50 * time.Millisecond,
+		}
+
+		events = make(chan string, 100)
+	})
+
+	// discover runs the discoverer in the background, sending "+" to events
+	// when the observer is invoked and "-" when its context is canceled.
+	discover := func() {
+		events := events
+
+		runTargetDiscoverer(
+			ctx,
+			cancel,
+			disc,
+			func(targetCtx context.Context, t Target) {
+				Expect(t).To(Equal(target))
+				events <- "+"
+
+				go func() {
+					<-targetCtx.Done()
+					events <- "-"
+				}()
+			},
+		)
+	}
+
+	Describe("func DiscoverTargets()", func() {
+		It("does not invoke the observer while the target is not serving", func() {
+			discover()
+			Consistently(events, 100*time.Millisecond).ShouldNot(Receive())
+		})
+
+		It("invokes the observer when the target is serving", func() {
+			hserver.SetServingStatus("<service>", grpc_health_v1.HealthCheckResponse_SERVING)
+			discover()
+			Eventually(events).Should(Receive(Equal("+")))
+			Consistently(events, 100*time.Millisecond).ShouldNot(Receive())
+		})
+
+		It("cancels the observer context when the target stops serving", func() {
+			hserver.SetServingStatus("<service>", grpc_health_v1.HealthCheckResponse_SERVING)
+			discover()
+			Eventually(events).Should(Receive(Equal("+")))
+
+			hserver.SetServingStatus("<service>", grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+			Eventually(events).Should(Receive(Equal("-")))
+
+			hserver.SetServingStatus("<service>", grpc_health_v1.HealthCheckResponse_SERVING)
+			Eventually(events).Should(Receive(Equal("+")))
+		})
+
+		It("checks the overall server health if no service is specified", func() {
+			disc.Service = ""
+			discover()
+			Eventually(events).Should(Receive(Equal("+")))
+		})
+
+		It("logs errors that occur while checking the target", func() {
+			disc.Service = "<unknown>"
+
+			errs := make(chan error, 100)
+			disc.LogError = func(t Target, err error) {
+				Expect(t).To(Equal(target))
+				errs <- err
+			}
+
+			discover()
+
+			var err error
+			Eventually(errs).Should(Receive(&err))
+			Expect(err).To(MatchError(ContainSubstring("unable to check health")))
+			Expect(events).NotTo(Receive())
+
+			var watchErr *WatchError
+			Expect(errors.As(err, &watchErr)).To(BeTrue())
+			Expect(watchErr.Phase).To(Equal(WatchPhaseHealthCheck))
+			Expect(watchErr.Target).To(Equal(target))
+		})
+
+		It("retries the check if the target can not be dialed", func() {
+			hserver.SetServingStatus("<service>", grpc_health_v1.HealthCheckResponse_SERVING)
+
+			errs := make(chan error, 100)
+			disc.LogError = func(t Target, err error) {
+				Expect(t).To(Equal(target))
+				errs <- err
+			}
+
+			var attempts atomic.Int32
+			disc.Dial = func(
+				ctx context.Context,
+				name string,
+				options ...grpc.DialOption,
+			) (*grpc.ClientConn, error) {
+				if attempts.Add(1) <= 2 {
+					return nil, errors.New("<error>")
+				}
+				return grpc.DialContext(ctx, name, options...)
+			}
+
+			discover()
+
+			var err error
+			Eventually(errs).Should(Receive(&err))
+			Expect(err).To(MatchError("unable to dial target: <error>"))
+			Eventually(events).Should(Receive(Equal("+")))
+
+			var watchErr *WatchError
+			Expect(errors.As(err, &watchErr)).To(BeTrue())
+			Expect(watchErr.Phase).To(Equal(WatchPhaseDial))
+			Expect(watchErr.Target).To(Equal(target))
+			Expect(attempts.Load()).To(BeNumerically("==", 3))
+		})
+
+		It("cancels the observer context when the discoverer is stopped", func() {
+			hserver.SetServingStatus("<service>", grpc_health_v1.HealthCheckResponse_SERVING)
+			discover()
+			Eventually(events).Should(Receive(Equal("+")))
+
+			cancel()
+			Eventually(events).Should(Receive(Equal("-")))
+		})
+	})
+})
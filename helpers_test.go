@@ -0,0 +1,36 @@
+package discoverkit_test
+
+import (
+	"context"
+
+	. "github.com/dogmatiq/discoverkit"
+	. "github.com/onsi/ginkgo/v2"
+)
+
+// runTargetDiscoverer runs d in the background until the current spec ends.
+func runTargetDiscoverer(
+	ctx context.Context,
+	cancel context.CancelFunc,
+	d TargetDiscoverer,
+	obs TargetObserver,
+) {
+	runInBackground(cancel, func() {
+		d.DiscoverTargets(ctx, obs)
+	})
+}
+
+// runInBackground calls fn in a new goroutine. When the current spec ends,
+// cancel is called and then fn is awaited.
+func runInBackground(cancel context.CancelFunc, fn func()) {
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		fn()
+	}()
+
+	DeferCleanup(func() {
+		cancel()
+		<-done
+	})
+}
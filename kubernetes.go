@@ -2,9 +2,8 @@ package discoverkit
 
 import (
 	"context"
-	"net"
-	"os"
 	"strings"
+	"time"
 
 	"google.golang.org/grpc"
 )
@@ -19,11 +18,42 @@ type KubernetesEnvironmentTargetDiscoverer struct {
 	// PortName is the name (not the number) of the port used to identify a
 	// Dogma target.
 	//
-	// If it is empty, DefaultKubernetesPortName is used.
+	// If it is empty, DefaultKubernetesPortName is used. It is ignored if
+	// Ports is non-empty.
 	PortName string
 
 	// DialOptions returns the dial options used to dial the given address.
+	//
+	// It is ignored if Ports is non-empty.
 	DialOptions func(addr string) []grpc.DialOption
+
+	// TLS is the TLS configuration used to dial the discovered targets.
+	//
+	// The server certificate is verified against the name of the Kubernetes
+	// service, not the IP address in the environment. If TLS is nil, only the
+	// options returned by DialOptions are used. It is ignored if Ports is
+	// non-empty.
+	TLS *TLSConfig
+
+	// Ports is the set of named ports used to identify gRPC targets, each with
+	// its own dial options.
+	//
+	// A service that has more than one of the named ports is discovered as a
+	// separate target for each port. If Ports is empty, a single port is used,
+	// as described by the PortName, DialOptions and TLS fields.
+	Ports []EnvironmentPort
+
+	// Environment is the source of the environment variables.
+	//
+	// If it is nil, the environment variables of the current process are used.
+	Environment EnvironmentSource
+
+	// RefreshInterval is the interval at which the environment is read again
+	// to discover changes.
+	//
+	// If it is non-positive, the environment is read only once and the context
+	// passed to the observer is the context passed to DiscoverTargets().
+	RefreshInterval time.Duration
 }
 
 // DiscoverTargets invokes an observer for each gRPC target that is discovered.
@@ -40,51 +70,57 @@ func (d *KubernetesEnvironmentTargetDiscoverer) DiscoverTargets(
 	ctx context.Context,
 	obs TargetObserver,
 ) error {
-	portName := d.PortName
-	if portName == "" {
-		portName = DefaultKubernetesPortName
-	}
+	return discoverEnvironmentTargets(
+		ctx,
+		d.Environment,
+		d.RefreshInterval,
+		d.parse,
+		obs,
+	)
+}
 
-	separator := "_SERVICE_PORT_" +
-		kubernetesNameToEnv(portName) +
-		"="
+// parse returns the targets described by Kubernetes service environment
+// variables, keyed by the environment variable that contains the port.
+func (d *KubernetesEnvironmentTargetDiscoverer) parse(env map[string]string) map[string]Target {
+	targets := map[string]Target{}
 
-	for _, line := range os.Environ() {
-		i := strings.Index(line, separator)
-		if i < 0 {
-			continue
-		}
+	for _, p := range d.ports() {
+		suffix := "_SERVICE_PORT_" + nameToEnv(p.Name)
 
-		port := line[i+len(separator):]
-		if port == "" {
-			continue
-		}
+		for k, port := range env {
+			prefix, ok := strings.CutSuffix(k, suffix)
+			if !ok || prefix == "" || port == "" {
+				continue
+			}
 
-		host := os.Getenv(line[:i] + "_SERVICE_HOST")
-		if host == "" {
-			continue
-		}
+			host := env[prefix+"_SERVICE_HOST"]
+			if host == "" {
+				continue
+			}
 
-		t := Target{
-			Name: net.JoinHostPort(host, port),
+			targets[k] = p.target(envToName(prefix), host, port)
 		}
+	}
 
-		if d.DialOptions != nil {
-			t.DialOptions = d.DialOptions(t.Name)
-		}
+	return targets
+}
 
-		obs(ctx, t)
+// ports returns the named ports used to identify gRPC targets.
+func (d *KubernetesEnvironmentTargetDiscoverer) ports() []EnvironmentPort {
+	if len(d.Ports) != 0 {
+		return d.Ports
 	}
 
-	<-ctx.Done()
-
-	return ctx.Err()
-}
+	name := d.PortName
+	if name == "" {
+		name = DefaultKubernetesPortName
+	}
 
-// kubernetesNameToEnv converts a kubernetes resource name to an environment
-// variable name, as per Kubernetes own behavior.
-func kubernetesNameToEnv(s string) string {
-	return strings.ToUpper(
-		strings.ReplaceAll(s, "-", "_"),
-	)
+	return []EnvironmentPort{
+		{
+			Name:        name,
+			DialOptions: d.DialOptions,
+			TLS:         d.TLS,
+		},
+	}
 }
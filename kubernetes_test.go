@@ -3,11 +3,14 @@ package discoverkit_test
 import (
 	"context"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	. "github.com/dogmatiq/discoverkit"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
 )
 
 var _ = Describe("type KubernetesEnvironmentTargetDiscoverer", func() {
@@ -80,6 +83,31 @@ var _ = Describe("type KubernetesEnvironmentTargetDiscoverer", func() {
 			Expect(actual).To(ConsistOf(expect))
 		})
 
+		It("uses the TLS configuration to dial the targets", func() {
+			disc.TLS = &TLSConfig{}
+
+			var actual []Target
+
+			err := disc.DiscoverTargets(
+				ctx,
+				func(
+					_ context.Context,
+					t Target,
+				) {
+					actual = append(actual, t)
+
+					if len(actual) == 2 {
+						cancel()
+					}
+				},
+			)
+
+			Expect(err).To(Equal(context.Canceled))
+			Expect(actual).To(HaveEach(
+				HaveField("DialOptions", HaveLen(2)), // credentials and authority
+			))
+		})
+
 		It("allows use of a custom port name", func() {
 			disc.PortName = "custom"
 
@@ -110,3 +138,239 @@ var _ = Describe("type KubernetesEnvironmentTargetDiscoverer", func() {
 		})
 	})
 })
+
+var _ = Describe("type KubernetesEnvironmentTargetDiscoverer (with an environment source)", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+		disc   *KubernetesEnvironmentTargetDiscoverer
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 1*time.Second)
+		DeferCleanup(cancel)
+
+		disc = &KubernetesEnvironmentTargetDiscoverer{
+			Environment: EnvironmentMap{
+				"API_SERVICE_HOST":        "api.example.org",
+				"API_SERVICE_PORT_DOGMA":  "50555",
+				"API_SERVICE_PORT_ADMIN":  "50556",
+				"WEB_SERVICE_HOST":        "web.example.org",
+				"WEB_SERVICE_PORT_HTTP":   "80",
+				"MY_APP_SERVICE_HOST":     "my-app.example.org",
+				"MY_APP_SERVICE_PORT_DOG": "50557",
+			},
+		}
+	})
+
+	Describe("func DiscoverTargets()", func() {
+		It("reads the environment from the source", func() {
+			var actual []Target
+
+			err := disc.DiscoverTargets(
+				ctx,
+				func(
+					c context.Context,
+					t Target,
+				) {
+					Expect(c).To(BeIdenticalTo(ctx))
+					actual = append(actual, t)
+					cancel()
+				},
+			)
+
+			Expect(err).To(Equal(context.Canceled))
+			Expect(actual).To(ConsistOf(
+				Target{Name: "api.example.org:50555"},
+			))
+		})
+
+		It("discovers a separate target for each port", func() {
+			var (
+				adminOptions = []grpc.DialOption{grpc.WithAuthority("admin")}
+				actual       []Target
+			)
+
+			disc.Ports = []EnvironmentPort{
+				{Name: "dogma"},
+				{
+					Name: "admin",
+					DialOptions: func(addr string) []grpc.DialOption {
+						Expect(addr).To(Equal("api.example.org:50556"))
+						return adminOptions
+					},
+				},
+				{Name: "dog"},
+			}
+
+			err := disc.DiscoverTargets(
+				ctx,
+				func(
+					_ context.Context,
+					t Target,
+				) {
+					actual = append(actual, t)
+
+					if len(actual) == 3 {
+						cancel()
+					}
+				},
+			)
+
+			Expect(err).To(Equal(context.Canceled))
+			Expect(actual).To(ConsistOf(
+				Target{Name: "api.example.org:50555"},
+				Target{Name: "api.example.org:50556", DialOptions: adminOptions},
+				Target{Name: "my-app.example.org:50557"},
+			))
+		})
+
+		It("returns an error if the environment can not be read", func() {
+			disc.Environment = EnvironmentFile(filepath.Join(GinkgoT().TempDir(), "missing.env"))
+
+			err := disc.DiscoverTargets(
+				ctx,
+				func(context.Context, Target) {
+					Fail("unexpected call")
+				},
+			)
+
+			Expect(err).To(MatchError(ContainSubstring("unable to read environment")))
+		})
+
+		When("there is a refresh interval", func() {
+			var (
+				file  string
+				start func(TargetObserver)
+			)
+
+			BeforeEach(func() {
+				file = filepath.Join(GinkgoT().TempDir(), "service.env")
+				writeEnvironmentFile(
+					file,
+					"API_SERVICE_HOST=api.example.org",
+					"API_SERVICE_PORT_DOGMA=50555",
+				)
+
+				disc.Environment = EnvironmentFile(file)
+				disc.RefreshInterval = 10 * time.Millisecond
+
+				start = func(obs TargetObserver) {
+					ctx, cancel, disc := ctx, cancel, disc
+					done := make(chan struct{})
+
+					go func() {
+						defer close(done)
+						disc.DiscoverTargets(ctx, obs)
+					}()
+
+					DeferCleanup(func() {
+						cancel()
+						<-done
+					})
+				}
+			})
+
+			It("discovers targets that are added to the environment", func() {
+				targets := make(chan Target, 10)
+				file := file
+
+				start(func(_ context.Context, t Target) {
+					targets <- t
+				})
+
+				Eventually(targets).Should(Receive(Equal(Target{Name: "api.example.org:50555"})))
+
+				writeEnvironmentFile(
+					file,
+					"API_SERVICE_HOST=api.example.org",
+					"API_SERVICE_PORT_DOGMA=50555",
+					"WEB_SERVICE_HOST=web.example.org",
+					"WEB_SERVICE_PORT_DOGMA=50556",
+				)
+
+				Eventually(targets).Should(Receive(Equal(Target{Name: "web.example.org:50556"})))
+				Consistently(targets, 50*time.Millisecond).ShouldNot(Receive())
+			})
+
+			It("cancels the context of targets that are removed from the environment", func() {
+				contexts := make(chan context.Context, 10)
+				file := file
+
+				start(func(c context.Context, _ Target) {
+					contexts <- c
+				})
+
+				var targetCtx context.Context
+				Eventually(contexts).Should(Receive(&targetCtx))
+				Expect(targetCtx).NotTo(BeIdenticalTo(ctx))
+
+				writeEnvironmentFile(file)
+
+				Eventually(targetCtx.Done()).Should(BeClosed())
+			})
+
+			It("replaces targets whose address changes", func() {
+				type discovered struct {
+					Ctx    context.Context
+					Target Target
+				}
+
+				observed := make(chan discovered, 10)
+				file := file
+
+				start(func(c context.Context, t Target) {
+					observed <- discovered{c, t}
+				})
+
+				var first discovered
+				Eventually(observed).Should(Receive(&first))
+
+				writeEnvironmentFile(
+					file,
+					"API_SERVICE_HOST=api2.example.org",
+					"API_SERVICE_PORT_DOGMA=50555",
+				)
+
+				var second discovered
+				Eventually(observed).Should(Receive(&second))
+				Expect(second.Target).To(Equal(Target{Name: "api2.example.org:50555"}))
+				Expect(first.Ctx.Done()).To(BeClosed())
+				Expect(second.Ctx.Err()).ShouldNot(HaveOccurred())
+			})
+
+			It("cancels the context of all targets when it returns", func() {
+				contexts := make(chan context.Context, 10)
+				done := make(chan struct{})
+
+				go func() {
+					defer close(done)
+					disc.DiscoverTargets(
+						ctx,
+						func(c context.Context, _ Target) {
+							contexts <- c
+						},
+					)
+				}()
+
+				var targetCtx context.Context
+				Eventually(contexts).Should(Receive(&targetCtx))
+
+				cancel()
+				Eventually(done).Should(BeClosed())
+				Expect(targetCtx.Done()).To(BeClosed())
+			})
+		})
+	})
+})
+
+// writeEnvironmentFile writes an environment file containing the given lines.
+func writeEnvironmentFile(file string, lines ...string) {
+	tmp := file + ".tmp"
+
+	err := os.WriteFile(tmp, []byte(strings.Join(lines, "\n")+"\n"), 0o644)
+	Expect(err).ShouldNot(HaveOccurred())
+
+	err = os.Rename(tmp, file)
+	Expect(err).ShouldNot(HaveOccurred())
+}
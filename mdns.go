@@ -0,0 +1,582 @@
+package discoverkit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dogmatiq/linger"
+	"golang.org/x/net/dns/dnsmessage"
+	"google.golang.org/grpc"
+)
+
+const (
+	// DefaultMDNSService is the default DNS-SD service type used to advertise
+	// and discover gRPC targets via multicast DNS.
+	DefaultMDNSService = "_dogma._tcp"
+
+	// DefaultMDNSAddress is the default multicast group address used for
+	// multicast DNS.
+	DefaultMDNSAddress = "224.0.0.251:5353"
+
+	// DefaultMDNSTTL is the default TTL of the records announced by an
+	// MDNSRegistrar.
+	DefaultMDNSTTL = 2 * time.Minute
+
+	// DefaultMDNSQueryInterval is the default interval at which an
+	// MDNSTargetDiscoverer queries for targets.
+	DefaultMDNSQueryInterval = 10 * time.Second
+)
+
+// MDNSRegistrar advertises gRPC targets on the local network using DNS-based
+// service discovery (DNS-SD) over multicast DNS. The targets are discovered by
+// an MDNSTargetDiscoverer.
+//
+// It announces each target when it is registered, answers queries for the
+// service type while it remains registered, and announces its removal when
+// the registration ends. Only IPv4 multicast is supported.
+type MDNSRegistrar struct {
+	// Service is the DNS-SD service type, such as "_dogma._tcp".
+	//
+	// If it is empty, DefaultMDNSService is used.
+	Service string
+
+	// Instance is the DNS-SD instance name used to advertise the target.
+	//
+	// If it is empty, a name is derived from the target name.
+	Instance string
+
+	// Address is the multicast group address.
+	//
+	// If it is empty, DefaultMDNSAddress is used.
+	Address string
+
+	// Interface is the network interface used for multicast. If it is nil,
+	// the system's default interface is used.
+	Interface *net.Interface
+
+	// TTL is the TTL of the announced records.
+	//
+	// If it is non-positive, DefaultMDNSTTL is used.
+	TTL time.Duration
+
+	// LogError is an optional function that logs errors that occur while
+	// answering queries.
+	LogError func(error)
+}
+
+// Register advertises t until ctx is canceled.
+//
+// The name of t must consist of a host and port. It always returns a non-nil
+// error.
+func (r *MDNSRegistrar) Register(ctx context.Context, t Target) error {
+	host, port, err := net.SplitHostPort(t.Name)
+	if err != nil {
+		return fmt.Errorf("unable to register target: %w", err)
+	}
+
+	portNumber, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return fmt.Errorf("unable to register target: invalid port: %w", err)
+	}
+
+	instance := r.Instance
+	if instance == "" {
+		instance = mdnsInstanceName(t.Name)
+	}
+
+	records, err := mdnsRecords(
+		mdnsServiceName(r.Service),
+		instance,
+		host,
+		uint16(portNumber),
+	)
+	if err != nil {
+		return fmt.Errorf("unable to register target: %w", err)
+	}
+
+	group, err := mdnsGroup(r.Address)
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.ListenMulticastUDP("udp4", r.Interface, group)
+	if err != nil {
+		return fmt.Errorf("unable to listen for multicast DNS queries: %w", err)
+	}
+	defer conn.Close()
+
+	ttl := uint32(linger.MustCoalesce(r.TTL, DefaultMDNSTTL) / time.Second)
+	ttl = max(ttl, 1)
+
+	if err := mdnsSend(conn, group, records, ttl); err != nil {
+		return fmt.Errorf("unable to announce target: %w", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r.answer(conn, group, records, ttl)
+	}()
+
+	<-ctx.Done()
+
+	// Announce that the records are no longer valid by sending them with a
+	// TTL of zero, then close the connection to stop answering queries.
+	mdnsSend(conn, group, records, 0)
+	conn.Close()
+	<-done
+
+	return ctx.Err()
+}
+
+// answer responds to queries for the advertised records until conn is
+// closed.
+func (r *MDNSRegistrar) answer(
+	conn *net.UDPConn,
+	group *net.UDPAddr,
+	records []dnsmessage.Resource,
+	ttl uint32,
+) {
+	buf := make([]byte, 9000)
+
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+
+		var req dnsmessage.Message
+		if err := req.Unpack(buf[:n]); err != nil || req.Header.Response {
+			continue
+		}
+
+		if !mdnsQuestionMatches(req.Questions, records) {
+			continue
+		}
+
+		if err := mdnsSend(conn, group, records, ttl); err != nil && r.LogError != nil {
+			r.LogError(fmt.Errorf("unable to answer multicast DNS query: %w", err))
+		}
+	}
+}
+
+// MDNSTargetDiscoverer discovers gRPC targets that are advertised on the local
+// network by an MDNSRegistrar, or any other DNS-SD responder, using multicast
+// DNS.
+//
+// Only IPv4 multicast is supported.
+type MDNSTargetDiscoverer struct {
+	// Service is the DNS-SD service type, such as "_dogma._tcp".
+	//
+	// If it is empty, DefaultMDNSService is used.
+	Service string
+
+	// Address is the multicast group address.
+	//
+	// If it is empty, DefaultMDNSAddress is used.
+	Address string
+
+	// Interface is the network interface used for multicast. If it is nil,
+	// the system's default interface is used.
+	Interface *net.Interface
+
+	// QueryInterval is the interval at which the discoverer queries for
+	// targets. Targets are also discovered as they are announced.
+	//
+	// If it is non-positive, DefaultMDNSQueryInterval is used.
+	QueryInterval time.Duration
+
+	// DialOptions returns the dial options used to dial the given address.
+	DialOptions func(addr string) []grpc.DialOption
+
+	// TLS is the TLS configuration used to dial the discovered targets.
+	//
+	// The server certificate is verified against the host part of the target
+	// name. If TLS is nil, only the options returned by DialOptions are used.
+	TLS *TLSConfig
+}
+
+// DiscoverTargets invokes an observer for each gRPC target that is discovered.
+//
+// It runs until ctx is canceled or an error occurs.
+//
+// The context passed to the observer is canceled when the target becomes
+// unavailable or the discover is stopped.
+//
+// The discoverer MAY block on calls to the observer. It is the observer's
+// responsibility to start new goroutines to handle background tasks, as
+// appropriate.
+func (d *MDNSTargetDiscoverer) DiscoverTargets(ctx context.Context, obs TargetObserver) error {
+	service := mdnsServiceName(d.Service)
+
+	name, err := dnsmessage.NewName(service)
+	if err != nil {
+		return fmt.Errorf("invalid service type: %w", err)
+	}
+
+	query, err := (&dnsmessage.Message{
+		Questions: []dnsmessage.Question{
+			{Name: name, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET},
+		},
+	}).Pack()
+	if err != nil {
+		return err
+	}
+
+	group, err := mdnsGroup(d.Address)
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.ListenMulticastUDP("udp4", d.Interface, group)
+	if err != nil {
+		return fmt.Errorf("unable to listen for multicast DNS responses: %w", err)
+	}
+	defer conn.Close()
+
+	// Create a context that is canceled when this function returns, so that
+	// the goroutine that reads responses always stops.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Close the connection if ctx is canceled to abort the blocking read.
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+	defer stop()
+
+	responses := make(chan *dnsmessage.Message)
+	failed := make(chan error, 1)
+
+	go func() {
+		failed <- mdnsReceive(ctx, conn, responses)
+	}()
+
+	instances := map[string]mdnsInstance{}
+	set := targetSet{}
+	defer set.cancelAll()
+
+	interval := linger.MustCoalesce(d.QueryInterval, DefaultMDNSQueryInterval)
+	nextQuery := time.Now()
+
+	for {
+		now := time.Now()
+
+		if !now.Before(nextQuery) {
+			if _, err := conn.WriteToUDP(query, group); err != nil {
+				return fmt.Errorf("unable to send multicast DNS query: %w", err)
+			}
+			nextQuery = now.Add(interval)
+		}
+
+		// Sync the observer state with the instances that have not expired,
+		// and work out when the next one expires.
+		wake := nextQuery
+		targets := map[string]Target{}
+
+		for k, inst := range instances {
+			if !now.Before(inst.expires) {
+				delete(instances, k)
+				continue
+			}
+
+			targets[k] = registeredTarget(inst.name, d.DialOptions, d.TLS)
+
+			if inst.expires.Before(wake) {
+				wake = inst.expires
+			}
+		}
+
+		set.sync(ctx, targets, obs)
+
+		timer := time.NewTimer(time.Until(wake))
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+
+		case err := <-failed:
+			timer.Stop()
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("unable to receive multicast DNS responses: %w", err)
+
+		case res := <-responses:
+			timer.Stop()
+			mdnsUpdateInstances(instances, service, res, time.Now())
+
+		case <-timer.C:
+		}
+	}
+}
+
+// mdnsInstance is a DNS-SD service instance discovered by an
+// MDNSTargetDiscoverer.
+type mdnsInstance struct {
+	name    string
+	expires time.Time
+}
+
+// mdnsReceive reads multicast DNS responses from conn and sends them to the
+// responses channel until an error occurs.
+func mdnsReceive(
+	ctx context.Context,
+	conn *net.UDPConn,
+	responses chan<- *dnsmessage.Message,
+) error {
+	buf := make([]byte, 9000)
+
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return err
+		}
+
+		res := &dnsmessage.Message{}
+		if err := res.Unpack(buf[:n]); err != nil || !res.Header.Response {
+			// Ignore malformed packets and queries, including our own.
+			continue
+		}
+
+		select {
+		case responses <- res:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// mdnsUpdateInstances updates the known instances of a service based on the
+// records in a multicast DNS response.
+func mdnsUpdateInstances(
+	instances map[string]mdnsInstance,
+	service string,
+	res *dnsmessage.Message,
+	now time.Time,
+) {
+	records := append(res.Answers, res.Additionals...)
+
+	for _, ptr := range records {
+		body, ok := ptr.Body.(*dnsmessage.PTRResource)
+		if !ok || !strings.EqualFold(ptr.Header.Name.String(), service) {
+			continue
+		}
+
+		instance := strings.ToLower(body.PTR.String())
+
+		if ptr.Header.TTL == 0 {
+			// A TTL of zero indicates that the instance has been removed.
+			delete(instances, instance)
+			continue
+		}
+
+		host, port, ok := mdnsTarget(records, instance)
+		if !ok {
+			continue
+		}
+
+		instances[instance] = mdnsInstance{
+			name:    net.JoinHostPort(host, strconv.Itoa(int(port))),
+			expires: now.Add(time.Duration(ptr.Header.TTL) * time.Second),
+		}
+	}
+}
+
+// mdnsTarget returns the host and port of a service instance, given the
+// records in a multicast DNS response.
+//
+// If the response contains an address for the host in the instance's SRV
+// record, the address is returned instead of the hostname.
+func mdnsTarget(records []dnsmessage.Resource, instance string) (string, uint16, bool) {
+	for _, srv := range records {
+		body, ok := srv.Body.(*dnsmessage.SRVResource)
+		if !ok || !strings.EqualFold(srv.Header.Name.String(), instance) {
+			continue
+		}
+
+		host := body.Target.String()
+
+		for _, r := range records {
+			if !strings.EqualFold(r.Header.Name.String(), host) {
+				continue
+			}
+
+			switch b := r.Body.(type) {
+			case *dnsmessage.AResource:
+				return net.IP(b.A[:]).String(), body.Port, true
+			case *dnsmessage.AAAAResource:
+				return net.IP(b.AAAA[:]).String(), body.Port, true
+			}
+		}
+
+		return strings.TrimSuffix(host, "."), body.Port, true
+	}
+
+	return "", 0, false
+}
+
+// mdnsRecords returns the DNS-SD records that advertise a service instance.
+//
+// If host is an IP address, the SRV record refers to a hostname within the
+// ".local" domain that is derived from the instance name, and an A or AAAA
+// record maps that hostname to the address.
+func mdnsRecords(
+	service, instance, host string,
+	port uint16,
+) ([]dnsmessage.Resource, error) {
+	serviceName, err := dnsmessage.NewName(service)
+	if err != nil {
+		return nil, fmt.Errorf("invalid service type: %w", err)
+	}
+
+	instanceName, err := dnsmessage.NewName(instance + "." + service)
+	if err != nil {
+		return nil, fmt.Errorf("invalid instance name: %w", err)
+	}
+
+	target := strings.TrimSuffix(host, ".") + "."
+
+	addr, addrErr := netip.ParseAddr(host)
+	if addrErr == nil {
+		target = mdnsInstanceName(instance) + ".local."
+	}
+
+	targetName, err := dnsmessage.NewName(target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid host: %w", err)
+	}
+
+	// The "cache flush" bit is set on the class of records that are unique to
+	// this responder, as per RFC 6762, section 10.2.
+	const cacheFlush = dnsmessage.ClassINET | 1<<15
+
+	records := []dnsmessage.Resource{
+		{
+			Header: dnsmessage.ResourceHeader{Name: serviceName, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET},
+			Body:   &dnsmessage.PTRResource{PTR: instanceName},
+		},
+		{
+			Header: dnsmessage.ResourceHeader{Name: instanceName, Type: dnsmessage.TypeSRV, Class: cacheFlush},
+			Body:   &dnsmessage.SRVResource{Target: targetName, Port: port},
+		},
+		{
+			Header: dnsmessage.ResourceHeader{Name: instanceName, Type: dnsmessage.TypeTXT, Class: cacheFlush},
+			Body:   &dnsmessage.TXTResource{TXT: []string{""}},
+		},
+	}
+
+	if addrErr == nil {
+		if addr.Is4() || addr.Is4In6() {
+			records = append(records, dnsmessage.Resource{
+				Header: dnsmessage.ResourceHeader{Name: targetName, Type: dnsmessage.TypeA, Class: cacheFlush},
+				Body:   &dnsmessage.AResource{A: addr.Unmap().As4()},
+			})
+		} else {
+			records = append(records, dnsmessage.Resource{
+				Header: dnsmessage.ResourceHeader{Name: targetName, Type: dnsmessage.TypeAAAA, Class: cacheFlush},
+				Body:   &dnsmessage.AAAAResource{AAAA: addr.As16()},
+			})
+		}
+	}
+
+	return records, nil
+}
+
+// mdnsQuestionMatches returns true if any of the questions ask for the given
+// records.
+func mdnsQuestionMatches(questions []dnsmessage.Question, records []dnsmessage.Resource) bool {
+	for _, q := range questions {
+		for _, r := range records {
+			if (q.Type == r.Header.Type || q.Type == dnsmessage.TypeALL) &&
+				strings.EqualFold(q.Name.String(), r.Header.Name.String()) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// mdnsSend sends a response containing the given records, with the given
+// TTL, to the multicast group.
+func mdnsSend(
+	conn *net.UDPConn,
+	group *net.UDPAddr,
+	records []dnsmessage.Resource,
+	ttl uint32,
+) error {
+	answers := make([]dnsmessage.Resource, len(records))
+	for i, r := range records {
+		r.Header.TTL = ttl
+		answers[i] = r
+	}
+
+	data, err := (&dnsmessage.Message{
+		Header: dnsmessage.Header{
+			Response:      true,
+			Authoritative: true,
+		},
+		Answers: answers,
+	}).Pack()
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.WriteToUDP(data, group)
+	return err
+}
+
+// mdnsGroup returns the multicast group address to use, given the configured
+// address.
+func mdnsGroup(addr string) (*net.UDPAddr, error) {
+	if addr == "" {
+		addr = DefaultMDNSAddress
+	}
+
+	group, err := net.ResolveUDPAddr("udp4", addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid multicast DNS address: %w", err)
+	}
+
+	if !group.IP.IsMulticast() {
+		return nil, errors.New("invalid multicast DNS address: not a multicast address")
+	}
+
+	return group, nil
+}
+
+// mdnsServiceName returns the fully-qualified name of a DNS-SD service type
+// within the ".local" domain.
+func mdnsServiceName(service string) string {
+	if service == "" {
+		service = DefaultMDNSService
+	}
+
+	return strings.TrimSuffix(service, ".") + ".local."
+}
+
+// mdnsInstanceName returns a DNS label derived from s, for use as an instance
+// name or hostname.
+func mdnsInstanceName(s string) string {
+	label := []byte(strings.ToLower(s))
+
+	for i, c := range label {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') {
+			label[i] = '-'
+		}
+	}
+
+	if len(label) > 63 {
+		label = label[:63]
+	}
+
+	return string(label)
+}
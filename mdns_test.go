@@ -0,0 +1,203 @@
+package discoverkit_test
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"time"
+
+	. "github.com/dogmatiq/discoverkit"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// multicastAddress returns a multicast group address on an unused port, or
+// skips the current spec if multicast is unavailable.
+func multicastAddress() string {
+	pc, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	Expect(err).ShouldNot(HaveOccurred())
+	port := pc.LocalAddr().(*net.UDPAddr).Port
+	pc.Close()
+
+	group := &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: port}
+
+	conn, err := net.ListenMulticastUDP("udp4", nil, group)
+	if err != nil {
+		Skip("multicast is unavailable: " + err.Error())
+	}
+	defer conn.Close()
+
+	if _, err := conn.WriteToUDP([]byte("ping"), group); err != nil {
+		Skip("multicast is unavailable: " + err.Error())
+	}
+
+	conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	if _, _, err := conn.ReadFromUDP(make([]byte, 16)); err != nil {
+		Skip("multicast is unavailable: " + err.Error())
+	}
+
+	return net.JoinHostPort("224.0.0.251", strconv.Itoa(port))
+}
+
+var _ = Describe("type MDNSTargetDiscoverer", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+		addr   string
+		disc   *MDNSTargetDiscoverer
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+		DeferCleanup(cancel)
+
+		addr = multicastAddress()
+
+		disc = &MDNSTargetDiscoverer{
+			Address:       addr,
+			QueryInterval: 100 * time.Millisecond,
+		}
+	})
+
+	// register registers t in the background until the current spec ends,
+	// returning a function that removes the registration.
+	register := func(reg *MDNSRegistrar, t Target) context.CancelFunc {
+		ctx, cancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+
+		go func() {
+			defer close(done)
+			reg.Register(ctx, t)
+		}()
+
+		stop := func() {
+			cancel()
+			<-done
+		}
+
+		DeferCleanup(stop)
+
+		return stop
+	}
+
+	Describe("func DiscoverTargets()", func() {
+		It("discovers targets that are already registered", func() {
+			register(
+				&MDNSRegistrar{Address: addr},
+				Target{Name: "192.0.2.1:50555"},
+			)
+			register(
+				&MDNSRegistrar{Address: addr},
+				Target{Name: "engine.example.org:50556"},
+			)
+
+			// Give the registrars time to start listening for queries.
+			time.Sleep(50 * time.Millisecond)
+
+			targets := make(chan Target, 10)
+			runTargetDiscoverer(ctx, cancel, disc, func(_ context.Context, t Target) {
+				targets <- t
+			})
+
+			var t1, t2 Target
+			Eventually(targets).Should(Receive(&t1))
+			Eventually(targets).Should(Receive(&t2))
+			Expect([]Target{t1, t2}).To(ConsistOf(
+				Target{Name: "192.0.2.1:50555"},
+				Target{Name: "engine.example.org:50556"},
+			))
+		})
+
+		It("discovers targets as they are announced", func() {
+			disc.QueryInterval = time.Hour
+
+			targets := make(chan Target, 10)
+			runTargetDiscoverer(ctx, cancel, disc, func(_ context.Context, t Target) {
+				targets <- t
+			})
+
+			// Give the discoverer time to start listening for announcements.
+			time.Sleep(50 * time.Millisecond)
+
+			register(
+				&MDNSRegistrar{Address: addr},
+				Target{Name: "[2001:db8::1]:50555"},
+			)
+
+			Eventually(targets).Should(Receive(Equal(Target{Name: "[2001:db8::1]:50555"})))
+		})
+
+		It("only discovers targets of the same service type", func() {
+			register(
+				&MDNSRegistrar{Address: addr, Service: "_other._tcp"},
+				Target{Name: "192.0.2.1:50555"},
+			)
+
+			targets := make(chan Target, 10)
+			runTargetDiscoverer(ctx, cancel, disc, func(_ context.Context, t Target) {
+				targets <- t
+			})
+
+			Consistently(targets, 300*time.Millisecond).ShouldNot(Receive())
+		})
+
+		It("cancels the context when the registration ends", func() {
+			contexts := make(chan context.Context, 10)
+			runTargetDiscoverer(ctx, cancel, disc, func(c context.Context, _ Target) {
+				contexts <- c
+			})
+
+			stop := register(
+				&MDNSRegistrar{Address: addr},
+				Target{Name: "192.0.2.1:50555"},
+			)
+
+			var targetCtx context.Context
+			Eventually(contexts).Should(Receive(&targetCtx))
+
+			stop()
+
+			Eventually(targetCtx.Done()).Should(BeClosed())
+		})
+
+		It("cancels the context when the records expire", func() {
+			disc.QueryInterval = time.Hour
+
+			contexts := make(chan context.Context, 10)
+			runTargetDiscoverer(ctx, cancel, disc, func(c context.Context, _ Target) {
+				contexts <- c
+			})
+
+			time.Sleep(50 * time.Millisecond)
+
+			register(
+				&MDNSRegistrar{Address: addr, TTL: 1 * time.Second},
+				Target{Name: "192.0.2.1:50555"},
+			)
+
+			var targetCtx context.Context
+			Eventually(contexts).Should(Receive(&targetCtx))
+			Eventually(targetCtx.Done(), 2*time.Second).Should(BeClosed())
+		})
+
+		It("returns an error if the address is not a multicast address", func() {
+			disc.Address = "127.0.0.1:5353"
+
+			err := disc.DiscoverTargets(ctx, func(context.Context, Target) {
+				Fail("unexpected call")
+			})
+			Expect(err).To(MatchError("invalid multicast DNS address: not a multicast address"))
+		})
+	})
+})
+
+var _ = Describe("type MDNSRegistrar", func() {
+	Describe("func Register()", func() {
+		It("returns an error if the target name does not include a port", func() {
+			reg := &MDNSRegistrar{}
+
+			err := reg.Register(context.Background(), Target{Name: "engine.example.org"})
+			Expect(err).To(MatchError(ContainSubstring("unable to register target")))
+		})
+	})
+})
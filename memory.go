@@ -0,0 +1,118 @@
+package discoverkit
+
+import (
+	"context"
+	"strconv"
+	"sync"
+)
+
+// MemoryTargetDiscoverer is a TargetDiscoverer that "discovers" targets that
+// are added and removed programmatically.
+//
+// It is safe for concurrent use, and supports any number of concurrent calls
+// to DiscoverTargets(). Each caller is notified of the targets that have
+// already been added, followed by any subsequent changes. The zero value is
+// ready to use.
+type MemoryTargetDiscoverer struct {
+	m       sync.Mutex
+	seq     uint64
+	targets map[string]memoryTarget
+	changed chan struct{}
+}
+
+// memoryTarget is a target that has been added to a MemoryTargetDiscoverer.
+type memoryTarget struct {
+	target Target
+
+	// seq identifies the call to Add() that added the target, which
+	// distinguishes it from any target with the same name that replaces it.
+	seq uint64
+}
+
+// Add adds a target.
+//
+// If a target with the same name has already been added it is replaced, in
+// which case the context passed to the observer for the existing target is
+// canceled and the observer is invoked for the new target.
+func (d *MemoryTargetDiscoverer) Add(t Target) {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	d.init()
+
+	d.seq++
+	d.targets[t.Name] = memoryTarget{t, d.seq}
+	d.notify()
+}
+
+// Remove removes the target with the given name, if any.
+//
+// The context passed to the observer for the target is canceled.
+func (d *MemoryTargetDiscoverer) Remove(name string) {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	d.init()
+
+	if _, ok := d.targets[name]; ok {
+		delete(d.targets, name)
+		d.notify()
+	}
+}
+
+// DiscoverTargets invokes an observer for each gRPC target that is discovered.
+//
+// It runs until ctx is canceled or an error occurs.
+//
+// The context passed to the observer is canceled when the target becomes
+// unavailable or the discover is stopped.
+//
+// The discoverer MAY block on calls to the observer. It is the observer's
+// responsibility to start new goroutines to handle background tasks, as
+// appropriate.
+func (d *MemoryTargetDiscoverer) DiscoverTargets(ctx context.Context, obs TargetObserver) error {
+	set := targetSet{}
+	defer set.cancelAll()
+
+	for {
+		targets, changed := d.snapshot()
+		set.sync(ctx, targets, obs)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// snapshot returns the current targets, keyed such that a replaced target has
+// a different key to its replacement, and a channel that is closed when the
+// targets change.
+func (d *MemoryTargetDiscoverer) snapshot() (map[string]Target, <-chan struct{}) {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	d.init()
+
+	targets := make(map[string]Target, len(d.targets))
+	for name, mt := range d.targets {
+		targets[name+"\x00"+strconv.FormatUint(mt.seq, 10)] = mt.target
+	}
+
+	return targets, d.changed
+}
+
+// notify wakes any calls to DiscoverTargets(). d.m must be locked.
+func (d *MemoryTargetDiscoverer) notify() {
+	close(d.changed)
+	d.changed = make(chan struct{})
+}
+
+// init initializes the discoverer's state. d.m must be locked.
+func (d *MemoryTargetDiscoverer) init() {
+	if d.changed == nil {
+		d.targets = map[string]memoryTarget{}
+		d.changed = make(chan struct{})
+	}
+}
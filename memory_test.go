@@ -0,0 +1,149 @@
+package discoverkit_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/dogmatiq/discoverkit"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+)
+
+var _ = Describe("type MemoryTargetDiscoverer", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+		disc   *MemoryTargetDiscoverer
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 2*time.Second)
+		DeferCleanup(cancel)
+
+		disc = &MemoryTargetDiscoverer{}
+	})
+
+	Describe("func DiscoverTargets()", func() {
+		It("invokes the observer for targets added before it is called", func() {
+			disc.Add(Target{Name: "<target-1>"})
+			disc.Add(Target{Name: "<target-2>"})
+
+			targets := make(chan Target, 10)
+
+			runTargetDiscoverer(ctx, cancel, disc, func(_ context.Context, t Target) {
+				targets <- t
+			})
+
+			Eventually(targets).Should(Receive(Equal(Target{Name: "<target-1>"})))
+			Eventually(targets).Should(Receive(Equal(Target{Name: "<target-2>"})))
+		})
+
+		It("invokes the observer for targets added after it is called", func() {
+			targets := make(chan Target, 10)
+
+			runTargetDiscoverer(ctx, cancel, disc, func(_ context.Context, t Target) {
+				targets <- t
+			})
+
+			disc.Add(Target{Name: "<target>"})
+
+			Eventually(targets).Should(Receive(Equal(Target{Name: "<target>"})))
+		})
+
+		It("supports multiple concurrent discoverers", func() {
+			disc.Add(Target{Name: "<target-1>"})
+
+			targets1 := make(chan Target, 10)
+			targets2 := make(chan Target, 10)
+
+			runTargetDiscoverer(ctx, cancel, disc, func(_ context.Context, t Target) {
+				targets1 <- t
+			})
+
+			runTargetDiscoverer(ctx, cancel, disc, func(_ context.Context, t Target) {
+				targets2 <- t
+			})
+
+			disc.Add(Target{Name: "<target-2>"})
+
+			Eventually(targets1).Should(Receive(Equal(Target{Name: "<target-1>"})))
+			Eventually(targets1).Should(Receive(Equal(Target{Name: "<target-2>"})))
+			Eventually(targets2).Should(Receive(Equal(Target{Name: "<target-1>"})))
+			Eventually(targets2).Should(Receive(Equal(Target{Name: "<target-2>"})))
+		})
+
+		It("cancels the context when the target is removed", func() {
+			contexts := make(chan context.Context, 10)
+
+			runTargetDiscoverer(ctx, cancel, disc, func(c context.Context, _ Target) {
+				contexts <- c
+			})
+
+			disc.Add(Target{Name: "<target-1>"})
+
+			var ctx1 context.Context
+			Eventually(contexts).Should(Receive(&ctx1))
+
+			disc.Add(Target{Name: "<target-2>"})
+
+			var ctx2 context.Context
+			Eventually(contexts).Should(Receive(&ctx2))
+
+			disc.Remove("<target-1>")
+
+			Eventually(ctx1.Done()).Should(BeClosed())
+			Consistently(ctx2.Done(), 50*time.Millisecond).ShouldNot(BeClosed())
+		})
+
+		It("replaces a target that is added with the same name", func() {
+			type observed struct {
+				ctx    context.Context
+				target Target
+			}
+
+			observations := make(chan observed, 10)
+
+			runTargetDiscoverer(ctx, cancel, disc, func(c context.Context, t Target) {
+				observations <- observed{c, t}
+			})
+
+			disc.Add(Target{Name: "<target>"})
+
+			var o1 observed
+			Eventually(observations).Should(Receive(&o1))
+
+			disc.Add(Target{
+				Name:        "<target>",
+				DialOptions: []grpc.DialOption{grpc.WithAuthority("<authority>")},
+			})
+
+			var o2 observed
+			Eventually(observations).Should(Receive(&o2))
+
+			Expect(o1.ctx.Done()).To(BeClosed())
+			Expect(o2.target.Name).To(Equal("<target>"))
+			Expect(o2.target.DialOptions).To(HaveLen(1))
+		})
+
+		It("does nothing when removing a target that has not been added", func() {
+			disc.Remove("<target>")
+
+			targets := make(chan Target, 10)
+
+			runTargetDiscoverer(ctx, cancel, disc, func(_ context.Context, t Target) {
+				targets <- t
+			})
+
+			Consistently(targets, 50*time.Millisecond).ShouldNot(Receive())
+		})
+
+		It("returns the context error when the context is canceled", func() {
+			ctx, cancel := context.WithCancel(ctx)
+			cancel()
+
+			err := disc.DiscoverTargets(ctx, func(context.Context, Target) {})
+			Expect(err).To(Equal(context.Canceled))
+		})
+	})
+})
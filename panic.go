@@ -0,0 +1,210 @@
+package discoverkit
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+
+	"github.com/dogmatiq/configkit"
+)
+
+// PanicPolicy determines how a discoverer handles a panic in an observer.
+type PanicPolicy int
+
+const (
+	// PropagatePanics does not recover panics in observers. A panic crashes
+	// the goroutine that invoked the observer, which is typically the
+	// discoverer's own goroutine.
+	PropagatePanics PanicPolicy = iota
+
+	// RecoverPanics recovers panics in observers. The panic is reported as an
+	// *ObserverPanicError to the discoverer's LogError function, if present,
+	// and the discoverer continues to run.
+	RecoverPanics
+
+	// FailOnPanic recovers panics in observers. The panic is reported as an
+	// *ObserverPanicError to the discoverer's LogError function, if present,
+	// then the discoverer stops and returns the error.
+	FailOnPanic
+)
+
+// ObserverPanicError is an error that describes a panic that was recovered
+// from a TargetObserver or an ApplicationObserver.
+type ObserverPanicError struct {
+	// Target is the target that was passed to the observer, or the target that
+	// hosts the application that was passed to the observer.
+	Target Target
+
+	// Identity is the identity of the application that was passed to the
+	// observer. It is the zero value if the observer is a TargetObserver.
+	Identity configkit.Identity
+
+	// Value is the value that was passed to panic().
+	Value any
+
+	// Stack is the stack trace of the goroutine that panicked, as returned by
+	// debug.Stack().
+	Stack []byte
+}
+
+func (e *ObserverPanicError) Error() string {
+	if e.Identity.IsZero() {
+		return fmt.Sprintf(
+			"observer for target %s panicked: %v",
+			e.Target.Name,
+			e.Value,
+		)
+	}
+
+	return fmt.Sprintf(
+		"observer for application %s on target %s panicked: %v",
+		e.Identity,
+		e.Target.Name,
+		e.Value,
+	)
+}
+
+// Unwrap returns the value that was passed to panic(), if it is an error.
+func (e *ObserverPanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// RecoveringTargetDiscoverer is a TargetDiscoverer that recovers panics in the
+// observer that is passed to another discoverer.
+//
+// It can be used to apply a PanicPolicy to any TargetDiscoverer.
+type RecoveringTargetDiscoverer struct {
+	// Discoverer is the discoverer used to discover the targets.
+	Discoverer TargetDiscoverer
+
+	// ObserverPanics determines how a panic in the observer is handled.
+	ObserverPanics PanicPolicy
+
+	// LogError is an optional function that logs panics that are recovered
+	// from the observer.
+	LogError func(Target, error)
+}
+
+// DiscoverTargets invokes an observer for each gRPC target that is discovered.
+//
+// It runs until ctx is canceled or an error occurs. If the policy is
+// FailOnPanic and the observer panics it returns an *ObserverPanicError.
+//
+// The context passed to the observer is canceled when the target becomes
+// unavailable or the discover is stopped.
+//
+// The discoverer MAY block on calls to the observer. It is the observer's
+// responsibility to start new goroutines to handle background tasks, as
+// appropriate.
+func (d *RecoveringTargetDiscoverer) DiscoverTargets(ctx context.Context, obs TargetObserver) error {
+	if d.ObserverPanics == PropagatePanics {
+		return d.Discoverer.DiscoverTargets(ctx, obs)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var failure firstError
+
+	err := d.Discoverer.DiscoverTargets(
+		ctx,
+		func(ctx context.Context, t Target) {
+			if err := observeTarget(ctx, d.ObserverPanics, obs, t); err != nil {
+				if d.LogError != nil {
+					d.LogError(t, err)
+				}
+
+				if d.ObserverPanics == FailOnPanic {
+					failure.set(err)
+					cancel()
+				}
+			}
+		},
+	)
+
+	if err := failure.get(); err != nil {
+		return err
+	}
+
+	return err
+}
+
+// observeTarget invokes obs, recovering any panic as per the given policy.
+//
+// It returns an *ObserverPanicError if a panic is recovered.
+func observeTarget(
+	ctx context.Context,
+	policy PanicPolicy,
+	obs TargetObserver,
+	t Target,
+) (err error) {
+	if policy != PropagatePanics {
+		defer func() {
+			if v := recover(); v != nil {
+				err = &ObserverPanicError{
+					Target: t,
+					Value:  v,
+					Stack:  debug.Stack(),
+				}
+			}
+		}()
+	}
+
+	obs(ctx, t)
+
+	return nil
+}
+
+// observeApplication invokes obs, recovering any panic as per the given
+// policy.
+//
+// It returns an *ObserverPanicError if a panic is recovered.
+func observeApplication(
+	ctx context.Context,
+	policy PanicPolicy,
+	obs ApplicationObserver,
+	a Application,
+) (err error) {
+	if policy != PropagatePanics {
+		defer func() {
+			if v := recover(); v != nil {
+				err = &ObserverPanicError{
+					Target:   a.Target,
+					Identity: a.Identity,
+					Value:    v,
+					Stack:    debug.Stack(),
+				}
+			}
+		}()
+	}
+
+	obs(ctx, a)
+
+	return nil
+}
+
+// firstError records the first of several errors that may occur concurrently.
+type firstError struct {
+	m   sync.Mutex
+	err error
+}
+
+// set records err if no error has been recorded already.
+func (e *firstError) set(err error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	if e.err == nil {
+		e.err = err
+	}
+}
+
+// get returns the recorded error, if any.
+func (e *firstError) get() error {
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	return e.err
+}
@@ -0,0 +1,250 @@
+package discoverkit_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/dogmatiq/configkit"
+	. "github.com/dogmatiq/discoverkit"
+	"github.com/dogmatiq/discoverkit/discoverkittest"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("type ObserverPanicError", func() {
+	Describe("func Error()", func() {
+		It("describes a panic in a target observer", func() {
+			err := &ObserverPanicError{
+				Target: Target{Name: "<target>"},
+				Value:  "<value>",
+			}
+
+			Expect(err.Error()).To(Equal("observer for target <target> panicked: <value>"))
+		})
+
+		It("describes a panic in an application observer", func() {
+			err := &ObserverPanicError{
+				Target:   Target{Name: "<target>"},
+				Identity: configkit.MustNewIdentity("<app-name>", appKey),
+				Value:    "<value>",
+			}
+
+			Expect(err.Error()).To(Equal(
+				"observer for application <app-name>/" + appKey + " on target <target> panicked: <value>",
+			))
+		})
+	})
+
+	Describe("func Unwrap()", func() {
+		It("returns the panic value if it is an error", func() {
+			cause := errors.New("<error>")
+			err := &ObserverPanicError{Value: cause}
+
+			Expect(err).To(MatchError(cause))
+		})
+
+		It("returns nil if the panic value is not an error", func() {
+			err := &ObserverPanicError{Value: "<value>"}
+
+			Expect(err.Unwrap()).To(BeNil())
+		})
+	})
+})
+
+var _ = Describe("type RecoveringTargetDiscoverer", func() {
+	var (
+		ctx      context.Context
+		cancel   context.CancelFunc
+		upstream *MemoryTargetDiscoverer
+		disc     *RecoveringTargetDiscoverer
+		errors   chan error
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 2*time.Second)
+		DeferCleanup(cancel)
+
+		upstream = &MemoryTargetDiscoverer{}
+		upstream.Add(Target{Name: "<panic>"})
+		upstream.Add(Target{Name: "<target>"})
+
+		errors = make(chan error, 10)
+
+		disc = &RecoveringTargetDiscoverer{
+			Discoverer: upstream,
+			LogError: func(_ Target, err error) {
+				errors <- err
+			},
+		}
+	})
+
+	// observer returns an observer that panics when it observes the "<panic>"
+	// target, and sends any other targets to the given channel.
+	observer := func(targets chan<- Target) TargetObserver {
+		return func(_ context.Context, t Target) {
+			if t.Name == "<panic>" {
+				panic("<value>")
+			}
+			targets <- t
+		}
+	}
+
+	Describe("func DiscoverTargets()", func() {
+		It("does not recover panics when the policy is PropagatePanics", func() {
+			disc.ObserverPanics = PropagatePanics
+
+			Expect(func() {
+				disc.DiscoverTargets(ctx, observer(nil))
+			}).To(PanicWith("<value>"))
+		})
+
+		It("logs panics and continues when the policy is RecoverPanics", func() {
+			disc.ObserverPanics = RecoverPanics
+
+			targets := make(chan Target, 10)
+			done := make(chan error, 1)
+
+			go func() {
+				done <- disc.DiscoverTargets(ctx, observer(targets))
+			}()
+
+			var err error
+			Eventually(errors).Should(Receive(&err))
+
+			var panicErr *ObserverPanicError
+			Expect(err).To(BeAssignableToTypeOf(panicErr))
+			Expect(err).To(MatchError("observer for target <panic> panicked: <value>"))
+
+			Eventually(targets).Should(Receive(Equal(Target{Name: "<target>"})))
+
+			cancel()
+			Eventually(done).Should(Receive(Equal(context.Canceled)))
+		})
+
+		It("logs panics and returns the error when the policy is FailOnPanic", func() {
+			disc.ObserverPanics = FailOnPanic
+
+			err := disc.DiscoverTargets(ctx, observer(make(chan Target, 10)))
+
+			var panicErr *ObserverPanicError
+			Expect(err).To(BeAssignableToTypeOf(panicErr))
+			Expect(err).To(MatchError("observer for target <panic> panicked: <value>"))
+
+			panicErr = err.(*ObserverPanicError)
+			Expect(panicErr.Target).To(Equal(Target{Name: "<panic>"}))
+			Expect(string(panicErr.Stack)).To(ContainSubstring("panic_test.go"))
+
+			Expect(errors).To(Receive(Equal(err)))
+		})
+	})
+})
+
+var _ = Describe("type ApplicationDiscoverer (observer panics)", func() {
+	var (
+		ctx        context.Context
+		cancel     context.CancelFunc
+		app1, app2 configkit.Identity
+		server     *discoverkittest.Server
+		discoverer *ApplicationDiscoverer
+		errors     chan error
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 2*time.Second)
+		DeferCleanup(cancel)
+
+		app1 = configkit.MustNewIdentity("<app-1-name>", "a2b30343-b86c-485c-94e0-de84dda069a7")
+		app2 = configkit.MustNewIdentity("<app-2-name>", "e7f11e2c-791f-4083-8c71-6aa966fc3db1")
+
+		server = discoverkittest.NewServer(nil)
+		DeferCleanup(server.Close)
+
+		server.Available(app1)
+
+		errors = make(chan error, 10)
+
+		discoverer = &ApplicationDiscoverer{
+			LogError: func(_ Target, err error) {
+				errors <- err
+			},
+		}
+	})
+
+	// observer returns an observer that panics when it observes app1, and
+	// sends any other applications to the given channel.
+	observer := func(apps chan<- configkit.Identity) ApplicationObserver {
+		return func(_ context.Context, a Application) {
+			if a.Identity == app1 {
+				panic("<value>")
+			}
+			apps <- a.Identity
+		}
+	}
+
+	When("the policy is RecoverPanics", func() {
+		BeforeEach(func() {
+			discoverer.ObserverPanics = RecoverPanics
+		})
+
+		It("logs the panic and continues to discover applications", func() {
+			apps := make(chan configkit.Identity, 10)
+			done := make(chan error, 1)
+
+			go func() {
+				done <- discoverer.DiscoverApplications(ctx, server.Target, observer(apps))
+			}()
+
+			var err error
+			Eventually(errors).Should(Receive(&err))
+
+			var panicErr *ObserverPanicError
+			Expect(err).To(BeAssignableToTypeOf(panicErr))
+
+			panicErr = err.(*ObserverPanicError)
+			Expect(panicErr.Identity).To(Equal(app1))
+			Expect(panicErr.Target.Name).To(Equal(server.Target.Name))
+
+			server.Available(app2)
+			Eventually(apps).Should(Receive(Equal(app2)))
+
+			cancel()
+			Eventually(done).Should(Receive(Equal(context.Canceled)))
+		})
+	})
+
+	When("the policy is FailOnPanic", func() {
+		BeforeEach(func() {
+			discoverer.ObserverPanics = FailOnPanic
+		})
+
+		Describe("func DiscoverApplications()", func() {
+			It("logs the panic and returns the error", func() {
+				err := discoverer.DiscoverApplications(ctx, server.Target, observer(nil))
+
+				var panicErr *ObserverPanicError
+				Expect(err).To(BeAssignableToTypeOf(panicErr))
+				Expect(err).To(MatchError(ContainSubstring("observer for application <app-1-name>")))
+				Expect(errors).To(Receive(Equal(err)))
+				Expect(errors).NotTo(Receive())
+			})
+		})
+
+		Describe("func DiscoverApplicationsOnTargets()", func() {
+			It("stops discovering applications on every target and returns the error", func() {
+				other := discoverkittest.NewServer(nil)
+				defer other.Close()
+
+				targets := &MemoryTargetDiscoverer{}
+				targets.Add(server.Target)
+				targets.Add(other.Target)
+
+				err := discoverer.DiscoverApplicationsOnTargets(ctx, targets, observer(make(chan configkit.Identity, 10)))
+
+				var panicErr *ObserverPanicError
+				Expect(err).To(BeAssignableToTypeOf(panicErr))
+				Expect(ctx.Err()).ShouldNot(HaveOccurred())
+			})
+		})
+	})
+})
@@ -0,0 +1,156 @@
+package discoverkit
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"google.golang.org/grpc"
+)
+
+// Registrar is an interface for services that advertise gRPC targets so that
+// they can be found by a corresponding TargetDiscoverer.
+type Registrar interface {
+	// Register advertises t until ctx is canceled.
+	//
+	// The advertisement is withdrawn before Register returns. It always
+	// returns a non-nil error.
+	Register(ctx context.Context, t Target) error
+}
+
+var (
+	_ Registrar = (*EtcdRegistrar)(nil)
+	_ Registrar = (*FileRegistrar)(nil)
+	_ Registrar = (*MDNSRegistrar)(nil)
+	_ Registrar = (*MemoryRegistry)(nil)
+)
+
+// MemoryRegistry is an in-memory Registrar and TargetDiscoverer.
+//
+// Targets registered by calling Register() are discovered by any concurrent
+// calls to DiscoverTargets(). It is intended for use within a single process,
+// such as in tests. The zero value is ready to use.
+type MemoryRegistry struct {
+	seq atomic.Uint64
+
+	m       sync.Mutex
+	targets map[string]Target
+	changed chan struct{}
+}
+
+// Register advertises t until ctx is canceled.
+//
+// Each call is a separate registration, even if another registration has the
+// same target name. It always returns a non-nil error.
+func (r *MemoryRegistry) Register(ctx context.Context, t Target) error {
+	key := strconv.FormatUint(r.seq.Add(1), 10)
+
+	r.update(func(targets map[string]Target) {
+		targets[key] = t
+	})
+
+	<-ctx.Done()
+
+	r.update(func(targets map[string]Target) {
+		delete(targets, key)
+	})
+
+	return ctx.Err()
+}
+
+// DiscoverTargets invokes an observer for each gRPC target that is discovered.
+//
+// It runs until ctx is canceled or an error occurs.
+//
+// The context passed to the observer is canceled when the target becomes
+// unavailable or the discover is stopped.
+//
+// The discoverer MAY block on calls to the observer. It is the observer's
+// responsibility to start new goroutines to handle background tasks, as
+// appropriate.
+func (r *MemoryRegistry) DiscoverTargets(ctx context.Context, obs TargetObserver) error {
+	set := targetSet{}
+	defer set.cancelAll()
+
+	for {
+		targets, changed := r.snapshot()
+		set.sync(ctx, targets, obs)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// update modifies the registered targets and notifies the discoverers.
+func (r *MemoryRegistry) update(fn func(map[string]Target)) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	r.init()
+	fn(r.targets)
+
+	close(r.changed)
+	r.changed = make(chan struct{})
+}
+
+// snapshot returns a copy of the registered targets, and a channel that is
+// closed when they change.
+func (r *MemoryRegistry) snapshot() (map[string]Target, <-chan struct{}) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	r.init()
+
+	targets := make(map[string]Target, len(r.targets))
+	for k, t := range r.targets {
+		targets[k] = t
+	}
+
+	return targets, r.changed
+}
+
+// init initializes the registry's state. r.m must be locked.
+func (r *MemoryRegistry) init() {
+	if r.changed == nil {
+		r.targets = map[string]Target{}
+		r.changed = make(chan struct{})
+	}
+}
+
+// registeredTarget returns the target with the given name, as found by a
+// discoverer that is paired with a Registrar.
+//
+// The server certificate is verified against the host part of the target
+// name.
+func registeredTarget(
+	name string,
+	dialOptions func(addr string) []grpc.DialOption,
+	tls *TLSConfig,
+) Target {
+	t := Target{
+		Name: name,
+	}
+
+	if dialOptions != nil {
+		t.DialOptions = dialOptions(t.Name)
+	}
+
+	if tls != nil {
+		host, _, err := net.SplitHostPort(t.Name)
+		if err != nil {
+			host = t.Name
+		}
+
+		t.DialOptions = append(
+			t.DialOptions,
+			tls.DialOptions(host)...,
+		)
+	}
+
+	return t
+}
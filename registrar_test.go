@@ -0,0 +1,156 @@
+package discoverkit_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/dogmatiq/discoverkit"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("type MemoryRegistry", func() {
+	var (
+		ctx      context.Context
+		cancel   context.CancelFunc
+		registry *MemoryRegistry
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 2*time.Second)
+		DeferCleanup(cancel)
+
+		registry = &MemoryRegistry{}
+	})
+
+	// register registers t in the background, returning a function that
+	// removes the registration.
+	register := func(t Target) context.CancelFunc {
+		ctx, cancel := context.WithCancel(ctx)
+		registry := registry
+		done := make(chan struct{})
+
+		go func() {
+			defer close(done)
+			registry.Register(ctx, t)
+		}()
+
+		stop := func() {
+			cancel()
+			<-done
+		}
+
+		DeferCleanup(stop)
+
+		return stop
+	}
+
+	Describe("func Register()", func() {
+		It("returns the context error when the context is canceled", func() {
+			ctx, cancel := context.WithCancel(ctx)
+			cancel()
+
+			err := registry.Register(ctx, Target{Name: "<target>"})
+			Expect(err).To(Equal(context.Canceled))
+		})
+	})
+
+	Describe("func DiscoverTargets()", func() {
+		It("invokes the observer for targets registered before it is called", func() {
+			register(Target{Name: "<target-1>"})
+			register(Target{Name: "<target-2>"})
+
+			targets := make(chan Target, 10)
+
+			runTargetDiscoverer(ctx, cancel, registry, func(_ context.Context, t Target) {
+				targets <- t
+			})
+
+			var t1, t2 Target
+			Eventually(targets).Should(Receive(&t1))
+			Eventually(targets).Should(Receive(&t2))
+			Expect([]Target{t1, t2}).To(ConsistOf(
+				Target{Name: "<target-1>"},
+				Target{Name: "<target-2>"},
+			))
+		})
+
+		It("invokes the observer for targets registered after it is called", func() {
+			targets := make(chan Target, 10)
+
+			runTargetDiscoverer(ctx, cancel, registry, func(_ context.Context, t Target) {
+				targets <- t
+			})
+
+			register(Target{Name: "<target>"})
+
+			Eventually(targets).Should(Receive(Equal(Target{Name: "<target>"})))
+		})
+
+		It("supports multiple concurrent discoverers", func() {
+			targets1 := make(chan Target, 10)
+			targets2 := make(chan Target, 10)
+
+			runTargetDiscoverer(ctx, cancel, registry, func(_ context.Context, t Target) {
+				targets1 <- t
+			})
+
+			runTargetDiscoverer(ctx, cancel, registry, func(_ context.Context, t Target) {
+				targets2 <- t
+			})
+
+			register(Target{Name: "<target>"})
+
+			Eventually(targets1).Should(Receive(Equal(Target{Name: "<target>"})))
+			Eventually(targets2).Should(Receive(Equal(Target{Name: "<target>"})))
+		})
+
+		It("cancels the context when the registration ends", func() {
+			contexts := make(chan context.Context, 10)
+
+			runTargetDiscoverer(ctx, cancel, registry, func(c context.Context, _ Target) {
+				contexts <- c
+			})
+
+			stop1 := register(Target{Name: "<target-1>"})
+
+			var ctx1 context.Context
+			Eventually(contexts).Should(Receive(&ctx1))
+
+			register(Target{Name: "<target-2>"})
+
+			var ctx2 context.Context
+			Eventually(contexts).Should(Receive(&ctx2))
+
+			stop1()
+
+			Eventually(ctx1.Done()).Should(BeClosed())
+			Consistently(ctx2.Done(), 50*time.Millisecond).ShouldNot(BeClosed())
+		})
+
+		It("treats registrations with the same name as separate targets", func() {
+			contexts := make(chan context.Context, 10)
+
+			runTargetDiscoverer(ctx, cancel, registry, func(c context.Context, t Target) {
+				Expect(t).To(Equal(Target{Name: "<target>"}))
+				contexts <- c
+			})
+
+			stop1 := register(Target{Name: "<target>"})
+
+			var ctx1 context.Context
+			Eventually(contexts).Should(Receive(&ctx1))
+
+			register(Target{Name: "<target>"})
+
+			var ctx2 context.Context
+			Eventually(contexts).Should(Receive(&ctx2))
+			Expect(ctx1.Done()).NotTo(BeClosed())
+
+			stop1()
+
+			Eventually(ctx1.Done()).Should(BeClosed())
+			Consistently(ctx2.Done(), 50*time.Millisecond).ShouldNot(BeClosed())
+		})
+	})
+})
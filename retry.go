@@ -0,0 +1,79 @@
+package discoverkit
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dogmatiq/linger/backoff"
+)
+
+// RetryPolicy determines how an ApplicationDiscoverer retries watching a
+// specific target after a failure.
+//
+// It includes a circuit breaker that "opens" once the target has failed a
+// number of consecutive times. While the circuit is open the discoverer either
+// probes the target at a slower rate, or gives up entirely.
+type RetryPolicy struct {
+	// BackoffStrategy is the strategy that determines when to retry watching
+	// the target while the circuit is closed.
+	//
+	// If it is nil, the discoverer's BackoffStrategy is used.
+	BackoffStrategy backoff.Strategy
+
+	// MaxFailures is the number of consecutive failures after which the
+	// circuit opens.
+	//
+	// If it is non-positive, the circuit never opens.
+	MaxFailures int
+
+	// ProbeInterval is the interval at which the discoverer attempts to watch
+	// the target while the circuit is open. The circuit closes again once the
+	// target is watched successfully, that is, once a response is received on
+	// its watch stream.
+	//
+	// If it is non-positive, the discoverer gives up when the circuit opens,
+	// returning a *CircuitOpenError.
+	ProbeInterval time.Duration
+}
+
+// CircuitOpenError is an error that indicates that an ApplicationDiscoverer
+// has stopped watching a target because the circuit breaker in its RetryPolicy
+// opened.
+type CircuitOpenError struct {
+	// Target is the target that is no longer being watched.
+	Target Target
+
+	// Failures is the number of consecutive failures that occurred.
+	Failures uint
+
+	// Cause is the error that caused the most recent failure.
+	Cause error
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf(
+		"gave up watching target after %d consecutive failures: %s",
+		e.Failures,
+		e.Cause,
+	)
+}
+
+// Unwrap returns the error that caused the most recent failure.
+func (e *CircuitOpenError) Unwrap() error {
+	return e.Cause
+}
+
+// retryPolicy returns the policy used to retry watching t.
+func (d *ApplicationDiscoverer) retryPolicy(t Target) RetryPolicy {
+	var p RetryPolicy
+
+	if t.RetryPolicy != nil {
+		p = t.RetryPolicy()
+	}
+
+	if p.BackoffStrategy == nil {
+		p.BackoffStrategy = d.BackoffStrategy
+	}
+
+	return p
+}
@@ -0,0 +1,243 @@
+package discoverkit_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/dogmatiq/configkit"
+	. "github.com/dogmatiq/discoverkit"
+	"github.com/dogmatiq/discoverkit/discoverkittest"
+	"github.com/dogmatiq/interopspec/discoverspec"
+	"github.com/dogmatiq/linger/backoff"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+var _ = Describe("type RetryPolicy", func() {
+	var (
+		ctx        context.Context
+		cancel     context.CancelFunc
+		discoverer *ApplicationDiscoverer
+		errs       chan error
+		target     Target
+		policy     RetryPolicy
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 2*time.Second)
+		DeferCleanup(cancel)
+
+		errs = make(chan error, 100)
+
+		discoverer = &ApplicationDiscoverer{
+			BackoffStrategy: backoff.Constant(1 * time.Hour),
+			LogError: func(_ Target, err error) {
+				errs <- err
+			},
+		}
+
+		policy = RetryPolicy{
+			BackoffStrategy: backoff.Constant(1 * time.Millisecond),
+		}
+
+		// The target has no transport credentials, which causes the dialer
+		// to fail.
+		target = Target{
+			Name: "<target>",
+			RetryPolicy: func() RetryPolicy {
+				return policy
+			},
+		}
+	})
+
+	// discover runs the discoverer in the background until the current spec
+	// ends.
+	discover := func(t Target) {
+		ctx, discoverer := ctx, discoverer
+
+		runInBackground(cancel, func() {
+			discoverer.DiscoverApplications(ctx, t, func(context.Context, Application) {})
+		})
+	}
+
+	It("uses the policy's backoff strategy instead of the discoverer's", func() {
+		discover(target)
+
+		for i := 0; i < 3; i++ {
+			Eventually(errs).Should(Receive())
+		}
+	})
+
+	It("uses the discoverer's backoff strategy if the policy does not have one", func() {
+		policy.BackoffStrategy = nil
+
+		discover(target)
+
+		Eventually(errs).Should(Receive())
+		Consistently(errs, 50*time.Millisecond).ShouldNot(Receive())
+	})
+
+	When("the policy has no probe interval", func() {
+		BeforeEach(func() {
+			policy.MaxFailures = 3
+		})
+
+		It("gives up once the circuit opens", func() {
+			err := discoverer.DiscoverApplications(ctx, target, nil)
+
+			var circuitErr *CircuitOpenError
+			Expect(errors.As(err, &circuitErr)).To(BeTrue())
+			Expect(circuitErr.Target.Name).To(Equal("<target>"))
+			Expect(circuitErr.Failures).To(BeEquivalentTo(3))
+			Expect(err).To(MatchError(ContainSubstring(
+				"gave up watching target after 3 consecutive failures: unable to dial target",
+			)))
+
+			var watchErr *WatchError
+			Expect(errors.As(err, &watchErr)).To(BeTrue())
+			Expect(watchErr.Phase).To(Equal(WatchPhaseDial))
+
+			for i := 0; i < 3; i++ {
+				Expect(errs).To(Receive(Not(BeAssignableToTypeOf(circuitErr))))
+			}
+			Expect(errs).To(Receive(Equal(err)))
+
+			Expect(discoverer.Targets()).To(BeEmpty())
+		})
+
+		It("opens the circuit if the stream is opened but every read fails", func() {
+			listener, err := net.Listen("tcp", "127.0.0.1:")
+			Expect(err).ShouldNot(HaveOccurred())
+
+			gserver := grpc.NewServer()
+			discoverspec.RegisterDiscoverAPIServer(gserver, &serverStub{
+				WatchApplicationsFunc: func(
+					*discoverspec.WatchApplicationsRequest,
+					discoverspec.DiscoverAPI_WatchApplicationsServer,
+				) error {
+					return status.Error(codes.Unavailable, "<error>")
+				},
+			})
+			go gserver.Serve(listener)
+			DeferCleanup(gserver.Stop)
+
+			target.Name = listener.Addr().String()
+			target.DialOptions = []grpc.DialOption{
+				grpc.WithTransportCredentials(insecure.NewCredentials()),
+			}
+
+			err = discoverer.DiscoverApplications(ctx, target, nil)
+
+			var circuitErr *CircuitOpenError
+			Expect(errors.As(err, &circuitErr)).To(BeTrue())
+			Expect(circuitErr.Failures).To(BeEquivalentTo(3))
+
+			var watchErr *WatchError
+			Expect(errors.As(err, &watchErr)).To(BeTrue())
+			Expect(watchErr.Phase).To(Equal(WatchPhaseReceive))
+		})
+
+		It("stops watching the target within DiscoverApplicationsOnTargets()", func() {
+			targets := &MemoryTargetDiscoverer{}
+			targets.Add(target)
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				discoverer.DiscoverApplicationsOnTargets(ctx, targets, func(context.Context, Application) {})
+			}()
+			DeferCleanup(func() {
+				cancel()
+				<-done
+			})
+
+			Eventually(errs).Should(Receive(BeAssignableToTypeOf(&CircuitOpenError{})))
+			Eventually(discoverer.Targets).Should(BeEmpty())
+		})
+	})
+
+	When("the policy has a probe interval", func() {
+		BeforeEach(func() {
+			policy.MaxFailures = 2
+			policy.ProbeInterval = 1 * time.Hour
+		})
+
+		It("probes the target at the probe interval once the circuit opens", func() {
+			discover(target)
+
+			Eventually(discoverer.Targets).Should(ContainElement(And(
+				HaveField("Failures", BeEquivalentTo(2)),
+				HaveField("CircuitOpen", BeTrue()),
+				HaveField("RetryAt", BeTemporally("~", time.Now().Add(policy.ProbeInterval), time.Second)),
+			)))
+		})
+
+		It("closes the circuit once the target is watched successfully", func() {
+			policy.ProbeInterval = 20 * time.Millisecond
+
+			app := configkit.MustNewIdentity("<app-name>", appKey)
+
+			server := discoverkittest.NewServer(nil)
+			DeferCleanup(server.Close)
+			server.Available(app)
+
+			// Fail to dial the server until the circuit has opened.
+			var (
+				m        sync.Mutex
+				attempts int
+			)
+
+			discoverer.Dial = func(
+				ctx context.Context,
+				name string,
+				options ...grpc.DialOption,
+			) (*grpc.ClientConn, error) {
+				m.Lock()
+				attempts++
+				n := attempts
+				m.Unlock()
+
+				if n <= 3 {
+					return nil, errors.New("<error>")
+				}
+
+				return server.Dial(ctx, name, options...)
+			}
+
+			target.Name = server.Target.Name
+
+			discover(target)
+
+			Eventually(discoverer.Targets).Should(ContainElement(And(
+				HaveField("CircuitOpen", BeTrue()),
+				HaveField("Failures", BeEquivalentTo(3)),
+			)))
+
+			Eventually(discoverer.Targets).Should(ContainElement(And(
+				HaveField("CircuitOpen", BeFalse()),
+				HaveField("Failures", BeEquivalentTo(0)),
+				HaveField("Applications", ConsistOf(app)),
+			)))
+		})
+	})
+})
+
+var _ = Describe("type CircuitOpenError", func() {
+	It("unwraps to the cause", func() {
+		cause := errors.New("<cause>")
+		err := &CircuitOpenError{
+			Failures: 5,
+			Cause:    cause,
+		}
+
+		Expect(err).To(MatchError(cause))
+		Expect(err).To(MatchError("gave up watching target after 5 consecutive failures: <cause>"))
+	})
+})
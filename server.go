@@ -1,10 +1,18 @@
 package discoverkit
 
 import (
+	"slices"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/dogmatiq/configkit"
 	"github.com/dogmatiq/interopspec/discoverspec"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
 )
 
 const (
@@ -15,6 +23,19 @@ const (
 
 // Server is an implementation of discoverspec.DiscoverAPIServer.
 type Server struct {
+	// Authenticate is an optional function that authenticates the client of
+	// each WatchApplications() call.
+	//
+	// If it is nil, all clients are permitted.
+	Authenticate Authenticator
+
+	// Authorize is an optional function that determines whether a client may
+	// see a specific application. Applications that the client is not
+	// authorized to see are never sent to it.
+	//
+	// If it is nil, all clients may see all applications.
+	Authorize Authorizer
+
 	m sync.Mutex
 
 	// available is the set of applications that are currently available indexed
@@ -27,11 +48,73 @@ type Server struct {
 	//
 	// This allows many goroutines to read from any given "version" of the map
 	// without holding any locks.
-	available map[string]*discoverspec.Identity
+	available map[string]*advertisedApplication
+
+	// sources is the set of sources that make each application available,
+	// indexed by identity key. An application is available for as long as it
+	// has at least one source.
+	sources map[string]*applicationSources
 
 	// changed is a "broadcast" channel that is closed to signal that the set of
 	// available applications has been replaced with a new "version".
 	changed chan struct{}
+
+	// watchers is the set of WatchApplications() calls that are currently in
+	// progress.
+	watchers map[*WatcherStatus]struct{}
+
+	// origins is the set of IDs of the federation bridges that re-advertise
+	// applications on this server, along with a reference count for each. See
+	// FederationBridge.
+	origins map[string]int
+
+	// originsVersion is incremented each time a new origin is added.
+	originsVersion uint64
+}
+
+// advertisedApplication is an application that is advertised by a Server.
+//
+// It is treated as though it is immutable.
+type advertisedApplication struct {
+	identity *discoverspec.Identity
+
+	// origins is the sorted list of IDs of the federation bridges through
+	// which the application was re-advertised, as far as is known by this
+	// server. It is empty if the application is hosted directly by the server.
+	origins []string
+}
+
+// applicationSources is the set of sources that make a single application
+// available on a Server.
+type applicationSources struct {
+	// local is the identity passed to Server.Available(), or nil if the
+	// application is not available locally.
+	local *configkit.Identity
+
+	// routes is the set of routes via which a FederationBridge re-advertises
+	// the application.
+	routes map[*applicationRoute]struct{}
+}
+
+// applicationRoute is a path via which a FederationBridge re-advertises an
+// application that it discovered on an upstream target.
+type applicationRoute struct {
+	identity configkit.Identity
+
+	// origins is the sorted list of IDs of the federation bridges through
+	// which the application was re-advertised, including the bridge that
+	// added the route.
+	origins []string
+}
+
+// WatcherStatus describes a client that is currently watching a Server for
+// changes to application availability.
+type WatcherStatus struct {
+	// Peer is the network address of the client, if known.
+	Peer string
+
+	// ConnectedAt is the time at which the client started watching.
+	ConnectedAt time.Time
 }
 
 var _ discoverspec.DiscoverAPIServer = (*Server)(nil)
@@ -55,45 +138,147 @@ func (s *Server) update(id configkit.Identity, available bool) {
 	s.m.Lock()
 	defer s.m.Unlock()
 
-	if _, ok := s.available[id.Key]; ok == available {
-		// The desired availability is the same as the app's current
-		// availability, do nothing.
-		return
+	src := s.sourcesOf(id.Key)
+
+	if available {
+		if src.local == nil {
+			src.local = &id
+		}
+	} else {
+		src.local = nil
 	}
 
-	// Create a clone of s.available. This avoids any data races with other
-	// goroutines reading the map currently referenced by s.available.
-	next := make(map[string]*discoverspec.Identity, len(s.available))
+	s.advertise(id.Key)
+}
 
-	// Add the newly available application.
-	if available {
-		next[id.Key] = &discoverspec.Identity{
-			Name: id.Name,
-			Key:  id.Key,
+// sourcesOf returns the sources of the application with the given key.
+//
+// s.m must be held.
+func (s *Server) sourcesOf(key string) *applicationSources {
+	src, ok := s.sources[key]
+	if !ok {
+		if s.sources == nil {
+			s.sources = map[string]*applicationSources{}
 		}
+
+		src = &applicationSources{
+			routes: map[*applicationRoute]struct{}{},
+		}
+		s.sources[key] = src
+	}
+
+	return src
+}
+
+// advertise updates the advertised state of the application with the given
+// key to reflect its current sources.
+//
+// An application that is available locally is advertised without any origins.
+// Otherwise, it is advertised via the route with the fewest origins.
+//
+// s.m must be held.
+func (s *Server) advertise(key string) {
+	var app *advertisedApplication
+
+	if src := s.sources[key]; src.local != nil {
+		app = &advertisedApplication{
+			identity: &discoverspec.Identity{
+				Name: src.local.Name,
+				Key:  src.local.Key,
+			},
+		}
+	} else if r := bestRoute(src.routes); r != nil {
+		app = &advertisedApplication{
+			identity: &discoverspec.Identity{
+				Name: r.identity.Name,
+				Key:  r.identity.Key,
+			},
+			origins: r.origins,
+		}
+	} else {
+		delete(s.sources, key)
+	}
+
+	if prev, ok := s.available[key]; ok == (app != nil) && (app == nil || app.equal(prev)) {
+		// The desired state is the same as the app's current state, do
+		// nothing.
+		return
 	}
 
-	// Copy the existing applications excluding the current app if it has become
-	// unavailable.
+	// Create a clone of s.available. This avoids any data races with other
+	// goroutines reading the map currently referenced by s.available.
+	next := make(map[string]*advertisedApplication, len(s.available)+1)
+
+	// Copy the existing applications excluding the current app.
 	for k, v := range s.available {
-		if available || k != id.Key {
+		if k != key {
 			next[k] = v
 		}
 	}
 
+	// Add the application if it is available.
+	if app != nil {
+		next[key] = app
+	}
+
 	// Replace s.available with the clone.
 	s.available = next
 
 	// Notify the watchers that a change has been made.
+	s.notify()
+}
+
+// bestRoute returns the route with the fewest origins, or nil if there are no
+// routes. Ties are broken deterministically.
+func bestRoute(routes map[*applicationRoute]struct{}) *applicationRoute {
+	var best *applicationRoute
+
+	for r := range routes {
+		if best == nil || r.less(best) {
+			best = r
+		}
+	}
+
+	return best
+}
+
+// less returns true if r is preferred over x.
+func (r *applicationRoute) less(x *applicationRoute) bool {
+	if len(r.origins) != len(x.origins) {
+		return len(r.origins) < len(x.origins)
+	}
+
+	if a, b := strings.Join(r.origins, "\x00"), strings.Join(x.origins, "\x00"); a != b {
+		return a < b
+	}
+
+	if r.identity.Name != x.identity.Name {
+		return r.identity.Name < x.identity.Name
+	}
+
+	return false
+}
+
+// equal returns true if a and x are advertised identically.
+func (a *advertisedApplication) equal(x *advertisedApplication) bool {
+	return a.identity.GetName() == x.identity.GetName() &&
+		a.identity.GetKey() == x.identity.GetKey() &&
+		slices.Equal(a.origins, x.origins)
+}
+
+// notify signals to the watchers that a change has been made.
+//
+// s.m must be held.
+func (s *Server) notify() {
 	if s.changed != nil {
 		close(s.changed)
 		s.changed = nil
 	}
 }
 
-// snapshot returns the current set of available applications, and a channel that
-// is closed if the set of available applications changes.
-func (s *Server) snapshot() (map[string]*discoverspec.Identity, <-chan struct{}) {
+// snapshot returns the current set of available applications, the current
+// origins version, and a channel that is closed if either of these changes.
+func (s *Server) snapshot() (map[string]*advertisedApplication, uint64, <-chan struct{}) {
 	s.m.Lock()
 	defer s.m.Unlock()
 
@@ -101,7 +286,73 @@ func (s *Server) snapshot() (map[string]*discoverspec.Identity, <-chan struct{})
 		s.changed = make(chan struct{})
 	}
 
-	return s.available, s.changed
+	return s.available, s.originsVersion, s.changed
+}
+
+// Applications returns the applications that are currently available, sorted
+// by name.
+func (s *Server) Applications() []configkit.Identity {
+	available, _, _ := s.snapshot()
+
+	ids := make([]configkit.Identity, 0, len(available))
+	for _, app := range available {
+		ids = append(ids, configkit.Identity{
+			Name: app.identity.GetName(),
+			Key:  app.identity.GetKey(),
+		})
+	}
+
+	sortIdentities(ids)
+
+	return ids
+}
+
+// Watchers returns the clients that are currently watching the server, sorted
+// by the time at which they started watching.
+func (s *Server) Watchers() []WatcherStatus {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	watchers := make([]WatcherStatus, 0, len(s.watchers))
+	for w := range s.watchers {
+		watchers = append(watchers, *w)
+	}
+
+	sort.Slice(watchers, func(i, j int) bool {
+		return watchers[i].ConnectedAt.Before(watchers[j].ConnectedAt)
+	})
+
+	return watchers
+}
+
+// addWatcher records a new watcher for the given stream context.
+func (s *Server) addWatcher(stream discoverspec.DiscoverAPI_WatchApplicationsServer) *WatcherStatus {
+	w := &WatcherStatus{
+		ConnectedAt: time.Now(),
+	}
+
+	if p, ok := peer.FromContext(stream.Context()); ok && p.Addr != nil {
+		w.Peer = p.Addr.String()
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.watchers == nil {
+		s.watchers = map[*WatcherStatus]struct{}{}
+	}
+
+	s.watchers[w] = struct{}{}
+
+	return w
+}
+
+// removeWatcher removes a watcher that was added by addWatcher().
+func (s *Server) removeWatcher(w *WatcherStatus) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	delete(s.watchers, w)
 }
 
 // WatchApplications starts watching the server for updates to the availability
@@ -110,8 +361,30 @@ func (s *Server) WatchApplications(
 	_ *discoverspec.WatchApplicationsRequest,
 	stream discoverspec.DiscoverAPI_WatchApplicationsServer,
 ) error {
-	// Keep a reference to the previous map of available applications. This is
-	// used to compute a "diff" when the available applications is updated.
+	principal, err := s.authenticate(stream.Context())
+	if err != nil {
+		return err
+	}
+
+	authorized := s.authorizer(stream.Context(), principal)
+	bridges := watcherBridges(stream.Context())
+
+	w := s.addWatcher(stream)
+	defer s.removeWatcher(w)
+
+	// Send the header metadata immediately, rather than waiting for the first
+	// response, so that bridges know the federation origins of this server
+	// before any applications become available.
+	origins, version := s.originList()
+	if err := stream.SendHeader(
+		metadata.MD{OriginMetadataKey: origins},
+	); err != nil {
+		return err
+	}
+
+	// Keep a reference to the previous set of applications that were sent to
+	// the client. This is used to compute a "diff" when the available
+	// applications is updated.
 	//
 	// This approach is taken as it allows changes to the available applications
 	// to be applied in s.Available() and s.Unavailable() without waiting for
@@ -120,7 +393,20 @@ func (s *Server) WatchApplications(
 
 	for {
 		// Read the current list of available applications.
-		next, changed := s.snapshot()
+		available, v, changed := s.snapshot()
+
+		// If the client is a bridge and new origins have been added since the
+		// header was sent we end the stream so that it reconnects and sees the
+		// new origins. Other clients do not use the origins, so they are never
+		// disconnected.
+		if len(bridges) != 0 && v != version {
+			return status.Error(
+				codes.Unavailable,
+				"the federation origins of the server have changed",
+			)
+		}
+
+		next := visibleApplications(available, authorized, bridges)
 
 		// Send an "available" response for each application that is in "next",
 		// but not in "prev".
@@ -145,15 +431,51 @@ func (s *Server) WatchApplications(
 	}
 }
 
+// visibleApplications returns the identities of the applications in
+// available that may be sent to a client.
+//
+// It excludes the applications that the client is not authorized to see, and
+// those that were re-advertised via any of the given federation bridges, which
+// would otherwise be re-advertised in a loop.
+func visibleApplications(
+	available map[string]*advertisedApplication,
+	authorized func(configkit.Identity) bool,
+	bridges []string,
+) map[string]*discoverspec.Identity {
+	visible := make(map[string]*discoverspec.Identity, len(available))
+
+	for k, app := range available {
+		if !authorized(configkit.Identity{
+			Name: app.identity.GetName(),
+			Key:  app.identity.GetKey(),
+		}) {
+			continue
+		}
+
+		if slices.ContainsFunc(bridges, func(b string) bool {
+			return slices.Contains(app.origins, b)
+		}) {
+			continue
+		}
+
+		visible[k] = app.identity
+	}
+
+	return visible
+}
+
 // diff sends a WatchResponse for each application that is present in lhs but
 // not present in rhs.
+//
+// An application that has a different name in lhs and rhs is considered to be
+// a different application, as it is by clients.
 func (s *Server) diff(
 	stream discoverspec.DiscoverAPI_WatchApplicationsServer,
 	available bool,
 	lhs, rhs map[string]*discoverspec.Identity,
 ) error {
 	for k, id := range lhs {
-		if _, ok := rhs[k]; ok {
+		if x, ok := rhs[k]; ok && id.GetName() == x.GetName() {
 			continue
 		}
 
@@ -174,6 +174,45 @@ var _ = Describe("type Server", func() {
 		})
 	})
 
+	Describe("func Applications()", func() {
+		It("returns the available applications sorted by name", func() {
+			server.Available(app2)
+			server.Available(app1)
+			server.Available(app3)
+			server.Unavailable(app3)
+
+			Expect(server.Applications()).To(Equal(
+				[]configkit.Identity{app1, app2},
+			))
+		})
+	})
+
+	Describe("func Watchers()", func() {
+		It("returns the clients that are currently watching", func() {
+			Expect(server.Watchers()).To(BeEmpty())
+
+			watchCtx, cancelWatch := context.WithCancel(ctx)
+			defer cancelWatch()
+
+			server.Available(app1)
+
+			stream, err := cli.WatchApplications(watchCtx, &discoverspec.WatchApplicationsRequest{})
+			Expect(err).ShouldNot(HaveOccurred())
+
+			_, err = stream.Recv() // read "available" notification
+			Expect(err).ShouldNot(HaveOccurred())
+
+			watchers := server.Watchers()
+			Expect(watchers).To(HaveLen(1))
+			Expect(watchers[0].Peer).NotTo(BeEmpty())
+			Expect(watchers[0].ConnectedAt).NotTo(BeZero())
+
+			cancelWatch()
+
+			Eventually(server.Watchers).Should(BeEmpty())
+		})
+	})
+
 	Describe("func WatchApplications()", func() {
 		It("sends the current state when a call is first made", func() {
 			server.Available(app1)
@@ -0,0 +1,203 @@
+package discoverkit
+
+import (
+	"sort"
+	"time"
+
+	"github.com/dogmatiq/configkit"
+	"google.golang.org/grpc"
+)
+
+// TargetStatus is a snapshot of the state of a gRPC target that is being
+// watched by an ApplicationDiscoverer.
+type TargetStatus struct {
+	// Target is the gRPC target being watched.
+	Target Target
+
+	// Connected is true if the discoverer currently has an open "watch stream"
+	// to the target.
+	Connected bool
+
+	// ConnectedAt is the time at which the current watch stream was opened. It
+	// is the zero-value if Connected is false.
+	ConnectedAt time.Time
+
+	// Failures is the number of successive failed attempts to watch the
+	// target since the last successful connection.
+	Failures uint
+
+	// LastError is the most recent error passed to the discoverer's LogError
+	// function for this target, if any.
+	LastError error
+
+	// LastErrorAt is the time at which LastError occurred.
+	LastErrorAt time.Time
+
+	// RetryAt is the time at which the discoverer will next attempt to watch
+	// the target. It is the zero-value if the discoverer is not waiting to
+	// retry.
+	RetryAt time.Time
+
+	// CircuitOpen is true if the target has failed enough consecutive times to
+	// open the circuit breaker in its RetryPolicy, such that the discoverer is
+	// probing it at the policy's ProbeInterval.
+	CircuitOpen bool
+
+	// Applications is the set of applications that are currently available on
+	// the target, sorted by name. It includes applications that are retained
+	// during the discoverer's grace period while the target is disconnected.
+	Applications []configkit.Identity
+}
+
+// targetState is the mutable state of a single call to
+// ApplicationDiscoverer.DiscoverApplications().
+//
+// It is protected by the discoverer's mutex.
+type targetState struct {
+	target       Target
+	connectedAt  time.Time
+	failures     uint
+	lastError    error
+	lastErrorAt  time.Time
+	retryAt      time.Time
+	circuitOpen  bool
+	applications map[configkit.Identity]*applicationState
+
+	// conn is the connection that is retained between attempts to watch the
+	// target when the discoverer has a grace period. It is only accessed by
+	// the goroutine running DiscoverApplications().
+	conn *grpc.ClientConn
+}
+
+// Targets returns the status of each gRPC target that is currently being
+// watched by the discoverer, sorted by target name.
+func (d *ApplicationDiscoverer) Targets() []TargetStatus {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	statuses := make([]TargetStatus, 0, len(d.targets))
+
+	for st := range d.targets {
+		s := TargetStatus{
+			Target:      st.target,
+			Connected:   !st.connectedAt.IsZero(),
+			ConnectedAt: st.connectedAt,
+			Failures:    st.failures,
+			LastError:   st.lastError,
+			LastErrorAt: st.lastErrorAt,
+			RetryAt:     st.retryAt,
+			CircuitOpen: st.circuitOpen,
+		}
+
+		for id := range st.applications {
+			s.Applications = append(s.Applications, id)
+		}
+
+		sortIdentities(s.Applications)
+		statuses = append(statuses, s)
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Target.Name < statuses[j].Target.Name
+	})
+
+	return statuses
+}
+
+// track starts tracking the state of the given target.
+func (d *ApplicationDiscoverer) track(t Target) *targetState {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	st := &targetState{
+		target:       t,
+		applications: map[configkit.Identity]*applicationState{},
+	}
+
+	if d.targets == nil {
+		d.targets = map[*targetState]struct{}{}
+	}
+
+	d.targets[st] = struct{}{}
+
+	return st
+}
+
+// untrack stops tracking the state of a target.
+func (d *ApplicationDiscoverer) untrack(st *targetState) {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	delete(d.targets, st)
+}
+
+// connected records that a watch stream has been opened to a target.
+func (d *ApplicationDiscoverer) connected(st *targetState) {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	st.connectedAt = time.Now()
+	st.retryAt = time.Time{}
+}
+
+// succeeded records that a target has been watched successfully, which resets
+// its consecutive failure count and closes its circuit breaker.
+func (d *ApplicationDiscoverer) succeeded(st *targetState) {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	st.failures = 0
+	st.circuitOpen = false
+}
+
+// disconnected records that the watch stream to a target has been closed.
+func (d *ApplicationDiscoverer) disconnected(st *targetState) {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	st.connectedAt = time.Time{}
+}
+
+// failed records a failed attempt to watch a target, and returns the number of
+// consecutive failures.
+func (d *ApplicationDiscoverer) failed(st *targetState) uint {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	st.failures++
+	return st.failures
+}
+
+// retrying records that the discoverer will retry watching a target after
+// the given delay, and whether the target's circuit breaker is open.
+func (d *ApplicationDiscoverer) retrying(st *targetState, delay time.Duration, open bool) {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	st.retryAt = time.Now().Add(delay)
+	st.circuitOpen = open
+}
+
+// logError records err as the last error that occurred on a target and logs
+// it to the LogError function, if present.
+func (d *ApplicationDiscoverer) logError(st *targetState, t Target, err error) {
+	d.m.Lock()
+	st.lastError = err
+	st.lastErrorAt = time.Now()
+	d.m.Unlock()
+
+	if d.LogError != nil {
+		d.LogError(t, err)
+	}
+}
+
+// sortIdentities sorts a slice of identities by name, then by key.
+func sortIdentities(ids []configkit.Identity) {
+	sort.Slice(ids, func(i, j int) bool {
+		if ids[i].Name != ids[j].Name {
+			return ids[i].Name < ids[j].Name
+		}
+
+		return ids[i].Key < ids[j].Key
+	})
+}
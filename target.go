@@ -17,6 +17,15 @@ type Target struct {
 
 	// DialOptions is a set of grpc.DialOptions used when dialing this target.
 	DialOptions []grpc.DialOption
+
+	// RetryPolicy is an optional function that returns the policy that an
+	// ApplicationDiscoverer uses to retry watching this target after a
+	// failure. It is called after each failure, so the policy may change over
+	// time.
+	//
+	// If it is nil, the discoverer retries indefinitely using its own
+	// BackoffStrategy.
+	RetryPolicy func() RetryPolicy
 }
 
 // TargetObserver is a function that handles the discovery of a gRPC target.
@@ -0,0 +1,74 @@
+package discoverkit
+
+import (
+	"context"
+	"sort"
+)
+
+// targetSet is the set of targets that a discoverer has reported to its
+// observer, keyed by a discoverer-specific value that identifies the source of
+// each target.
+type targetSet map[string]*targetEntry
+
+// targetEntry is the state of a single target within a targetSet.
+type targetEntry struct {
+	// name is the name of the target.
+	name string
+
+	// cancel cancels the context passed to the observer for the target.
+	cancel context.CancelFunc
+}
+
+// sync synchronizes the set with the latest targets.
+//
+// It cancels the context of any target that is no longer present, or whose
+// name has changed, then invokes the observer for each new target in order of
+// its key.
+func (s targetSet) sync(
+	ctx context.Context,
+	targets map[string]Target,
+	obs TargetObserver,
+) {
+	for k, e := range s {
+		if t, ok := targets[k]; !ok || t.Name != e.name {
+			delete(s, k)
+			e.cancel()
+		}
+	}
+
+	for _, k := range sortedTargetKeys(targets) {
+		if _, ok := s[k]; ok {
+			continue
+		}
+
+		t := targets[k]
+
+		// Create a new context specifically for this target. It will be
+		// canceled when the target is removed from the set.
+		targetCtx, cancel := context.WithCancel(ctx)
+		s[k] = &targetEntry{
+			name:   t.Name,
+			cancel: cancel,
+		}
+
+		obs(targetCtx, t)
+	}
+}
+
+// cancelAll cancels the contexts of all targets in the set.
+func (s targetSet) cancelAll() {
+	for k, e := range s {
+		delete(s, k)
+		e.cancel()
+	}
+}
+
+// sortedTargetKeys returns the keys of targets in order.
+func sortedTargetKeys(targets map[string]Target) []string {
+	keys := make([]string, 0, len(targets))
+	for k := range targets {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,420 @@
+package discoverkit
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// DefaultTLSReloadInterval is the default minimum interval between checks for
+// changes to the files referenced by a TLSConfig.
+const DefaultTLSReloadInterval = 10 * time.Second
+
+// TLSConfig is a TLS configuration that can be shared by the discoverers, and
+// by the gRPC server that serves a Server.
+//
+// The certificate files are loaded lazily and are re-loaded when they change
+// on disk, which allows certificates to be rotated without restarting the
+// process.
+type TLSConfig struct {
+	// CAFile is the path to a PEM file containing the CA certificates used to
+	// verify peer certificates.
+	//
+	// If it is empty, the system's root CA certificates are used to verify
+	// servers, and clients are not required to present a certificate.
+	CAFile string
+
+	// CertFile and KeyFile are the paths to the PEM files containing the
+	// certificate (and its private key) that is presented to peers.
+	//
+	// When dialing, the certificate is used for mutual TLS, if the server
+	// requests it. Both files are required when serving.
+	CertFile, KeyFile string
+
+	// ServerName, if non-empty, is the name used to verify server
+	// certificates, overriding the name derived from the target.
+	ServerName string
+
+	// SPIFFEIDs is a set of SPIFFE IDs, such as
+	// "spiffe://example.org/engine", that are accepted as peer identities.
+	//
+	// If SPIFFEIDs or SPIFFETrustDomain is non-empty, peer certificates are
+	// verified using their SPIFFE ID URI SAN instead of by hostname.
+	SPIFFEIDs []string
+
+	// SPIFFETrustDomain, if non-empty, accepts any peer with a SPIFFE ID in
+	// this trust domain, such as "example.org".
+	SPIFFETrustDomain string
+
+	// ReloadInterval is the minimum interval between checks for changes to
+	// the certificate files.
+	//
+	// If it is non-positive, DefaultTLSReloadInterval is used.
+	ReloadInterval time.Duration
+
+	m         sync.Mutex
+	checkedAt time.Time
+	versions  [3]fileVersion
+	roots     *x509.CertPool
+	cert      *tls.Certificate
+}
+
+// DialOptions returns the gRPC dial options that use this configuration to
+// dial a target.
+//
+// serverName is the name used to verify the server's certificate and is used
+// as the target's authority and TLS server name (SNI). It should be the name
+// that was queried to discover the target, rather than a resolved IP address.
+// If it is empty the host portion of the target name is used.
+func (c *TLSConfig) DialOptions(serverName string) []grpc.DialOption {
+	if c.ServerName != "" {
+		serverName = c.ServerName
+	}
+
+	options := []grpc.DialOption{
+		grpc.WithTransportCredentials(c.TransportCredentials(serverName)),
+	}
+
+	if serverName != "" {
+		options = append(options, grpc.WithAuthority(serverName))
+	}
+
+	return options
+}
+
+// TransportCredentials returns client-side transport credentials that use
+// this configuration.
+//
+// serverName is the name used to verify the server's certificate. If it is
+// empty, the host portion of the authority of the dialed target is used. IP
+// addresses are verified against the certificate's IP address SANs.
+func (c *TLSConfig) TransportCredentials(serverName string) credentials.TransportCredentials {
+	if c.ServerName != "" {
+		serverName = c.ServerName
+	}
+
+	return &tlsCredentials{
+		TransportCredentials: credentials.NewTLS(c.clientConfig(serverName)),
+		config:               c,
+		serverName:           serverName,
+	}
+}
+
+// clientConfig returns the client-side TLS configuration used to verify a
+// server with the given name.
+func (c *TLSConfig) clientConfig(serverName string) *tls.Config {
+	return &tls.Config{
+		ServerName: serverName,
+		MinVersion: tls.VersionTLS12,
+
+		// Verification is performed by VerifyConnection so that it uses the
+		// most recently loaded CA certificates and supports SPIFFE IDs.
+		InsecureSkipVerify: true,
+
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			_, cert, err := c.load()
+			if err != nil {
+				return nil, err
+			}
+
+			if cert == nil {
+				// Send no certificate, the server decides whether this is
+				// acceptable.
+				return &tls.Certificate{}, nil
+			}
+
+			return cert, nil
+		},
+
+		// The name is captured here rather than read from the connection
+		// state, which has no server name when dialing an IP address.
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			return c.verify(cs.PeerCertificates, serverName, x509.ExtKeyUsageServerAuth)
+		},
+	}
+}
+
+// tlsCredentials is the client-side transport credentials returned by
+// TLSConfig.TransportCredentials().
+//
+// It verifies the server against the authority of each dialed target when no
+// server name is configured.
+type tlsCredentials struct {
+	credentials.TransportCredentials
+
+	config     *TLSConfig
+	serverName string
+}
+
+func (t *tlsCredentials) ClientHandshake(
+	ctx context.Context,
+	authority string,
+	conn net.Conn,
+) (net.Conn, credentials.AuthInfo, error) {
+	if t.serverName != "" {
+		return t.TransportCredentials.ClientHandshake(ctx, authority, conn)
+	}
+
+	name := authority
+	if host, _, err := net.SplitHostPort(authority); err == nil {
+		name = host
+	}
+
+	return credentials.
+		NewTLS(t.config.clientConfig(name)).
+		ClientHandshake(ctx, authority, conn)
+}
+
+func (t *tlsCredentials) Clone() credentials.TransportCredentials {
+	return &tlsCredentials{
+		TransportCredentials: t.TransportCredentials.Clone(),
+		config:               t.config,
+		serverName:           t.serverName,
+	}
+}
+
+func (t *tlsCredentials) OverrideServerName(serverName string) error {
+	t.serverName = serverName
+	t.TransportCredentials = credentials.NewTLS(t.config.clientConfig(serverName))
+	return nil
+}
+
+// ServerTransportCredentials returns server-side transport credentials that
+// use this configuration.
+//
+// If c.CAFile is non-empty, clients are required to present a certificate
+// that is signed by one of its CAs.
+func (c *TLSConfig) ServerTransportCredentials() credentials.TransportCredentials {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			_, cert, err := c.load()
+			if err != nil {
+				return nil, err
+			}
+
+			if cert == nil {
+				return nil, errors.New("no server certificate is configured")
+			}
+
+			return cert, nil
+		},
+	}
+
+	if c.CAFile != "" {
+		cfg.ClientAuth = tls.RequireAnyClientCert
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			return c.verify(cs.PeerCertificates, "", x509.ExtKeyUsageClientAuth)
+		}
+	}
+
+	return credentials.NewTLS(cfg)
+}
+
+// verify verifies a peer's certificate chain.
+func (c *TLSConfig) verify(
+	chain []*x509.Certificate,
+	serverName string,
+	usage x509.ExtKeyUsage,
+) error {
+	if len(chain) == 0 {
+		return errors.New("peer did not present a certificate")
+	}
+
+	roots, _, err := c.load()
+	if err != nil {
+		return err
+	}
+
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{usage},
+	}
+
+	for _, cert := range chain[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+
+	useSPIFFE := len(c.SPIFFEIDs) != 0 || c.SPIFFETrustDomain != ""
+
+	if !useSPIFFE {
+		if usage == x509.ExtKeyUsageServerAuth && serverName == "" {
+			// There is nothing to verify the server's identity against, so
+			// any certificate signed by the CA would otherwise be accepted.
+			return errors.New("unable to verify server certificate: no server name or SPIFFE ID is configured")
+		}
+
+		// VerifyHostname() checks the IP address SANs if the name is an IP
+		// address, and the DNS name SANs otherwise.
+		opts.DNSName = serverName
+	}
+
+	if _, err := chain[0].Verify(opts); err != nil {
+		return err
+	}
+
+	if !useSPIFFE {
+		return nil
+	}
+
+	id, err := SPIFFEID(chain[0])
+	if err != nil {
+		return err
+	}
+
+	if c.SPIFFETrustDomain != "" && id.Host == c.SPIFFETrustDomain {
+		return nil
+	}
+
+	for _, x := range c.SPIFFEIDs {
+		if id.String() == x {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("SPIFFE ID %s is not permitted", id)
+}
+
+// load returns the current CA pool and certificate, re-loading the files if
+// they have changed.
+//
+// If the files have changed but can not be loaded, for example because they
+// are only partially written during rotation, the previously loaded values are
+// returned.
+func (c *TLSConfig) load() (*x509.CertPool, *tls.Certificate, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	loaded := !c.checkedAt.IsZero()
+	interval := c.ReloadInterval
+	if interval <= 0 {
+		interval = DefaultTLSReloadInterval
+	}
+
+	if loaded && time.Since(c.checkedAt) < interval {
+		return c.roots, c.cert, nil
+	}
+
+	var versions [3]fileVersion
+	for i, f := range []string{c.CAFile, c.CertFile, c.KeyFile} {
+		v, err := statFile(f)
+		if err != nil {
+			if loaded {
+				return c.roots, c.cert, nil
+			}
+			return nil, nil, err
+		}
+		versions[i] = v
+	}
+
+	if loaded && versions == c.versions {
+		c.checkedAt = time.Now()
+		return c.roots, c.cert, nil
+	}
+
+	roots, cert, err := c.read()
+	if err != nil {
+		if loaded {
+			return c.roots, c.cert, nil
+		}
+		return nil, nil, err
+	}
+
+	c.checkedAt = time.Now()
+	c.versions = versions
+	c.roots = roots
+	c.cert = cert
+
+	return roots, cert, nil
+}
+
+// read reads the CA pool and certificate from their files.
+func (c *TLSConfig) read() (*x509.CertPool, *tls.Certificate, error) {
+	var (
+		roots *x509.CertPool
+		cert  *tls.Certificate
+	)
+
+	if c.CAFile != "" {
+		data, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to read CA file: %w", err)
+		}
+
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(data) {
+			return nil, nil, fmt.Errorf("unable to read CA file: %s contains no PEM certificates", c.CAFile)
+		}
+	}
+
+	if c.CertFile != "" || c.KeyFile != "" {
+		kp, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to load certificate: %w", err)
+		}
+
+		cert = &kp
+	}
+
+	return roots, cert, nil
+}
+
+// fileVersion identifies a specific version of a file's content.
+type fileVersion struct {
+	modTime time.Time
+	size    int64
+}
+
+// statFile returns the version of the file at the given path. It returns the
+// zero-value if the path is empty.
+func statFile(path string) (fileVersion, error) {
+	if path == "" {
+		return fileVersion{}, nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileVersion{}, err
+	}
+
+	return fileVersion{info.ModTime(), info.Size()}, nil
+}
+
+// SPIFFEID returns the SPIFFE ID of the given certificate.
+//
+// It returns an error if the certificate does not have exactly one URI SAN
+// with the "spiffe" scheme.
+func SPIFFEID(cert *x509.Certificate) (*url.URL, error) {
+	var id *url.URL
+
+	for _, u := range cert.URIs {
+		if !strings.EqualFold(u.Scheme, "spiffe") {
+			continue
+		}
+
+		if id != nil {
+			return nil, errors.New("certificate has multiple SPIFFE IDs")
+		}
+
+		id = u
+	}
+
+	if id == nil {
+		return nil, errors.New("certificate does not have a SPIFFE ID")
+	}
+
+	return id, nil
+}
@@ -0,0 +1,359 @@
+package discoverkit_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/dogmatiq/configkit"
+	. "github.com/dogmatiq/discoverkit"
+	"github.com/dogmatiq/interopspec/discoverspec"
+	"github.com/dogmatiq/linger/backoff"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+)
+
+var _ = Describe("type TLSConfig", func() {
+	var (
+		ctx        context.Context
+		cancel     context.CancelFunc
+		dir        string
+		ca         *testCA
+		server     *TLSConfig
+		client     *TLSConfig
+		listenAddr string
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 2*time.Second)
+		DeferCleanup(cancel)
+
+		dir = GinkgoT().TempDir()
+		ca = newTestCA()
+
+		ca.WriteCA(filepath.Join(dir, "ca.pem"))
+		ca.WriteCert(
+			filepath.Join(dir, "server.pem"),
+			filepath.Join(dir, "server-key.pem"),
+			"engine.example.org",
+			"spiffe://example.org/engine",
+		)
+		ca.WriteCert(
+			filepath.Join(dir, "client.pem"),
+			filepath.Join(dir, "client-key.pem"),
+			"",
+			"spiffe://example.org/client",
+		)
+
+		server = &TLSConfig{
+			CAFile:   filepath.Join(dir, "ca.pem"),
+			CertFile: filepath.Join(dir, "server.pem"),
+			KeyFile:  filepath.Join(dir, "server-key.pem"),
+		}
+
+		client = &TLSConfig{
+			CAFile:   filepath.Join(dir, "ca.pem"),
+			CertFile: filepath.Join(dir, "client.pem"),
+			KeyFile:  filepath.Join(dir, "client-key.pem"),
+		}
+
+		s := &Server{}
+		s.Available(configkit.MustNewIdentity("<app-name>", appKey))
+
+		listener, err := net.Listen("tcp", "127.0.0.1:")
+		Expect(err).ShouldNot(HaveOccurred())
+
+		gserver := grpc.NewServer(grpc.Creds(server.ServerTransportCredentials()))
+		discoverspec.RegisterDiscoverAPIServer(gserver, s)
+		go gserver.Serve(listener)
+		DeferCleanup(gserver.Stop)
+
+		listenAddr = listener.Addr().String()
+	})
+
+	// discover attempts to discover applications on the test server using
+	// the given dial options. It returns nil if an application is
+	// discovered, or the first error that occurs.
+	discover := func(options []grpc.DialOption) error {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		result := make(chan error, 1)
+
+		d := &ApplicationDiscoverer{
+			BackoffStrategy: backoff.Constant(10 * time.Millisecond),
+			LogError: func(_ Target, err error) {
+				select {
+				case result <- err:
+				default:
+				}
+			},
+		}
+
+		go d.DiscoverApplications(
+			ctx,
+			Target{
+				Name:        listenAddr,
+				DialOptions: options,
+			},
+			func(context.Context, Application) {
+				select {
+				case result <- nil:
+				default:
+				}
+			},
+		)
+
+		select {
+		case err := <-result:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	Describe("func DialOptions()", func() {
+		It("verifies the server using the given server name", func() {
+			err := discover(client.DialOptions("engine.example.org"))
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+
+		It("fails if the server certificate does not match the server name", func() {
+			err := discover(client.DialOptions("other.example.org"))
+			Expect(err).To(MatchError(ContainSubstring("certificate is valid for engine.example.org, not other.example.org")))
+		})
+
+		It("verifies the server using the address of the target if no server name is given", func() {
+			ca.WriteCert(
+				filepath.Join(dir, "server.pem"),
+				filepath.Join(dir, "server-key.pem"),
+				"127.0.0.1",
+				"",
+			)
+
+			err := discover(client.DialOptions(""))
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+
+		It("fails if the server certificate does not match the IP address of the target", func() {
+			ca.WriteCert(
+				filepath.Join(dir, "server.pem"),
+				filepath.Join(dir, "server-key.pem"),
+				"evil.example",
+				"",
+			)
+
+			err := discover(client.DialOptions(""))
+			Expect(err).To(MatchError(ContainSubstring("cannot validate certificate for 127.0.0.1")))
+		})
+
+		It("prefers the configured server name", func() {
+			client.ServerName = "engine.example.org"
+
+			err := discover(client.DialOptions("other.example.org"))
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+
+		It("fails if the client does not present a certificate", func() {
+			client.CertFile = ""
+			client.KeyFile = ""
+
+			err := discover(client.DialOptions("engine.example.org"))
+			Expect(err).Should(HaveOccurred())
+		})
+
+		It("accepts a server with a permitted SPIFFE ID", func() {
+			client.SPIFFEIDs = []string{"spiffe://example.org/engine"}
+
+			err := discover(client.DialOptions("<not-a-dns-name>"))
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+
+		It("accepts a server with a SPIFFE ID in the trust domain", func() {
+			client.SPIFFETrustDomain = "example.org"
+
+			err := discover(client.DialOptions("<not-a-dns-name>"))
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+
+		It("rejects a server without a permitted SPIFFE ID", func() {
+			client.SPIFFEIDs = []string{"spiffe://example.org/other"}
+
+			err := discover(client.DialOptions("engine.example.org"))
+			Expect(err).To(MatchError(ContainSubstring("SPIFFE ID spiffe://example.org/engine is not permitted")))
+		})
+
+		It("reloads the CA file when it changes", func() {
+			client.ReloadInterval = time.Nanosecond
+			client.CAFile = filepath.Join(dir, "other-ca.pem")
+			newTestCA().WriteCA(client.CAFile)
+
+			err := discover(client.DialOptions("engine.example.org"))
+			Expect(err).To(MatchError(ContainSubstring("certificate signed by unknown authority")))
+
+			// Ensure the modification time changes on filesystems with a
+			// coarse timestamp resolution.
+			time.Sleep(10 * time.Millisecond)
+			ca.WriteCA(client.CAFile)
+
+			err = discover(client.DialOptions("engine.example.org"))
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+
+		It("fails if the files can not be loaded", func() {
+			client.CAFile = filepath.Join(dir, "nonexistent.pem")
+
+			err := discover(client.DialOptions("engine.example.org"))
+			Expect(err).Should(HaveOccurred())
+		})
+	})
+
+	Describe("func TransportCredentials()", func() {
+		It("fails if there is no server name or SPIFFE ID to verify", func() {
+			conn, err := net.Dial("tcp", listenAddr)
+			Expect(err).ShouldNot(HaveOccurred())
+			defer conn.Close()
+
+			creds := client.TransportCredentials("")
+			_, _, err = creds.ClientHandshake(ctx, "", conn)
+			Expect(err).To(MatchError(ContainSubstring("no server name or SPIFFE ID is configured")))
+		})
+	})
+
+	Describe("func ServerTransportCredentials()", func() {
+		It("rejects clients without a permitted SPIFFE ID", func() {
+			server.SPIFFEIDs = []string{"spiffe://example.org/other"}
+
+			err := discover(client.DialOptions("engine.example.org"))
+			Expect(err).Should(HaveOccurred())
+		})
+
+		It("accepts clients with a permitted SPIFFE ID", func() {
+			server.SPIFFEIDs = []string{"spiffe://example.org/client"}
+
+			err := discover(client.DialOptions("engine.example.org"))
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+	})
+})
+
+var _ = Describe("func SPIFFEID()", func() {
+	It("returns the SPIFFE ID of the certificate", func() {
+		id, err := SPIFFEID(&x509.Certificate{
+			URIs: []*url.URL{
+				{Scheme: "https", Host: "example.org"},
+				{Scheme: "spiffe", Host: "example.org", Path: "/engine"},
+			},
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(id.String()).To(Equal("spiffe://example.org/engine"))
+	})
+
+	It("returns an error if there is no SPIFFE ID", func() {
+		_, err := SPIFFEID(&x509.Certificate{})
+		Expect(err).To(MatchError("certificate does not have a SPIFFE ID"))
+	})
+
+	It("returns an error if there are multiple SPIFFE IDs", func() {
+		_, err := SPIFFEID(&x509.Certificate{
+			URIs: []*url.URL{
+				{Scheme: "spiffe", Host: "example.org", Path: "/a"},
+				{Scheme: "spiffe", Host: "example.org", Path: "/b"},
+			},
+		})
+		Expect(err).To(MatchError("certificate has multiple SPIFFE IDs"))
+	})
+})
+
+// testCA is a certificate authority used to issue certificates for tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+// newTestCA returns a new self-signed CA.
+func newTestCA() *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ExpectWithOffset(1, err).ShouldNot(HaveOccurred())
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "<test-ca>"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	ExpectWithOffset(1, err).ShouldNot(HaveOccurred())
+
+	cert, err := x509.ParseCertificate(der)
+	ExpectWithOffset(1, err).ShouldNot(HaveOccurred())
+
+	return &testCA{cert, key, der}
+}
+
+// WriteCA writes the CA certificate to a PEM file.
+func (ca *testCA) WriteCA(file string) {
+	writePEM(file, "CERTIFICATE", ca.der)
+}
+
+// WriteCert issues a certificate for the given DNS name (or IP address) and
+// SPIFFE ID and writes it and its private key to PEM files.
+func (ca *testCA) WriteCert(certFile, keyFile, dnsName, spiffeID string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ExpectWithOffset(1, err).ShouldNot(HaveOccurred())
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{
+			x509.ExtKeyUsageServerAuth,
+			x509.ExtKeyUsageClientAuth,
+		},
+	}
+
+	if ip := net.ParseIP(dnsName); ip != nil {
+		tmpl.IPAddresses = []net.IP{ip}
+	} else if dnsName != "" {
+		tmpl.DNSNames = []string{dnsName}
+	}
+
+	if spiffeID != "" {
+		u, err := url.Parse(spiffeID)
+		ExpectWithOffset(1, err).ShouldNot(HaveOccurred())
+		tmpl.URIs = []*url.URL{u}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	ExpectWithOffset(1, err).ShouldNot(HaveOccurred())
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	ExpectWithOffset(1, err).ShouldNot(HaveOccurred())
+
+	writePEM(certFile, "CERTIFICATE", der)
+	writePEM(keyFile, "EC PRIVATE KEY", keyDER)
+}
+
+// writePEM writes a single PEM block to a file.
+func writePEM(file, typ string, der []byte) {
+	data := pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der})
+	err := os.WriteFile(file, data, 0600)
+	ExpectWithOffset(2, err).ShouldNot(HaveOccurred())
+}
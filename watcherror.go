@@ -0,0 +1,102 @@
+package discoverkit
+
+import (
+	"fmt"
+
+	"github.com/dogmatiq/configkit"
+)
+
+// WatchPhase is the phase of watching a target in which an error occurred.
+type WatchPhase int
+
+const (
+	// WatchPhaseDial is the phase in which the target is dialed.
+	WatchPhaseDial WatchPhase = iota + 1
+
+	// WatchPhaseOpenStream is the phase in which the WatchApplications()
+	// stream is opened.
+	WatchPhaseOpenStream
+
+	// WatchPhaseReceive is the phase in which responses are read from the
+	// WatchApplications() stream.
+	WatchPhaseReceive
+
+	// WatchPhaseInvalidIdentity is the phase in which the identity of an
+	// application announced by the target is validated.
+	WatchPhaseInvalidIdentity
+
+	// WatchPhaseHealthCheck is the phase in which a
+	// HealthCheckingTargetDiscoverer checks the health of the target.
+	WatchPhaseHealthCheck
+
+	// WatchPhaseQuery is the phase in which a DNSTargetDiscoverer queries the
+	// DNS records of a host. The name of the target is the query host.
+	WatchPhaseQuery
+)
+
+func (p WatchPhase) String() string {
+	switch p {
+	case WatchPhaseDial:
+		return "dial"
+	case WatchPhaseOpenStream:
+		return "open stream"
+	case WatchPhaseReceive:
+		return "receive"
+	case WatchPhaseInvalidIdentity:
+		return "invalid identity"
+	case WatchPhaseHealthCheck:
+		return "health check"
+	case WatchPhaseQuery:
+		return "query"
+	default:
+		return fmt.Sprintf("WatchPhase(%d)", int(p))
+	}
+}
+
+// WatchError is an error that occurred while a discoverer was watching a
+// target.
+//
+// It is returned by, or passed to the LogError function of, an
+// ApplicationDiscoverer, HealthCheckingTargetDiscoverer or DNSTargetDiscoverer,
+// and may be inspected using errors.As().
+type WatchError struct {
+	// Phase is the phase of watching the target in which the error occurred.
+	Phase WatchPhase
+
+	// Target is the target that was being watched.
+	Target Target
+
+	// Identity is the identity of the application that the error relates to,
+	// if any.
+	//
+	// If Phase is WatchPhaseInvalidIdentity it contains the invalid identity
+	// as sent by the target. Otherwise, it is the zero value.
+	Identity configkit.Identity
+
+	// Cause is the underlying error.
+	Cause error
+}
+
+func (e *WatchError) Error() string {
+	switch e.Phase {
+	case WatchPhaseDial:
+		return "unable to dial target: " + e.Cause.Error()
+	case WatchPhaseOpenStream:
+		return "unable to watch target: " + e.Cause.Error()
+	case WatchPhaseReceive:
+		return "unable to read from stream: " + e.Cause.Error()
+	case WatchPhaseInvalidIdentity:
+		return "invalid application identity: " + e.Cause.Error()
+	case WatchPhaseHealthCheck:
+		return "unable to check health: " + e.Cause.Error()
+	case WatchPhaseQuery:
+		return "unable to query " + e.Target.Name + ": " + e.Cause.Error()
+	default:
+		return fmt.Sprintf("unable to watch target (%s): %s", e.Phase, e.Cause)
+	}
+}
+
+// Unwrap returns the underlying error.
+func (e *WatchError) Unwrap() error {
+	return e.Cause
+}
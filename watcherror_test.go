@@ -0,0 +1,75 @@
+package discoverkit_test
+
+import (
+	"errors"
+
+	"github.com/dogmatiq/configkit"
+	. "github.com/dogmatiq/discoverkit"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("type WatchError", func() {
+	DescribeTable(
+		"func Error()",
+		func(phase WatchPhase, expect string) {
+			err := &WatchError{
+				Phase:  phase,
+				Target: Target{Name: "<target>"},
+				Cause:  errors.New("<cause>"),
+			}
+
+			Expect(err).To(MatchError(expect))
+		},
+		Entry("dial", WatchPhaseDial, "unable to dial target: <cause>"),
+		Entry("open stream", WatchPhaseOpenStream, "unable to watch target: <cause>"),
+		Entry("receive", WatchPhaseReceive, "unable to read from stream: <cause>"),
+		Entry("invalid identity", WatchPhaseInvalidIdentity, "invalid application identity: <cause>"),
+		Entry("health check", WatchPhaseHealthCheck, "unable to check health: <cause>"),
+		Entry("query", WatchPhaseQuery, "unable to query <target>: <cause>"),
+		Entry("unknown", WatchPhase(100), "unable to watch target (WatchPhase(100)): <cause>"),
+	)
+
+	Describe("func Unwrap()", func() {
+		It("returns the cause", func() {
+			cause := errors.New("<cause>")
+			err := &WatchError{
+				Phase: WatchPhaseReceive,
+				Cause: cause,
+			}
+
+			Expect(err).To(MatchError(cause))
+		})
+	})
+
+	It("can be inspected using errors.As()", func() {
+		var err error = &WatchError{
+			Phase:    WatchPhaseInvalidIdentity,
+			Target:   Target{Name: "<target>"},
+			Identity: configkit.Identity{Key: appKey},
+			Cause:    errors.New("<cause>"),
+		}
+
+		var watchErr *WatchError
+		Expect(errors.As(err, &watchErr)).To(BeTrue())
+		Expect(watchErr.Phase).To(Equal(WatchPhaseInvalidIdentity))
+		Expect(watchErr.Target.Name).To(Equal("<target>"))
+		Expect(watchErr.Identity.Key).To(Equal(appKey))
+	})
+})
+
+var _ = Describe("type WatchPhase", func() {
+	DescribeTable(
+		"func String()",
+		func(phase WatchPhase, expect string) {
+			Expect(phase.String()).To(Equal(expect))
+		},
+		Entry("dial", WatchPhaseDial, "dial"),
+		Entry("open stream", WatchPhaseOpenStream, "open stream"),
+		Entry("receive", WatchPhaseReceive, "receive"),
+		Entry("invalid identity", WatchPhaseInvalidIdentity, "invalid identity"),
+		Entry("health check", WatchPhaseHealthCheck, "health check"),
+		Entry("query", WatchPhaseQuery, "query"),
+		Entry("unknown", WatchPhase(100), "WatchPhase(100)"),
+	)
+})